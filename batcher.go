@@ -0,0 +1,385 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchEncoder encodes a batch of texts in one call. *Embedder and
+// *EmbedderPool implement it.
+type BatchEncoder interface {
+	EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// BatcherConfig configures a Batcher. Zero fields take their defaults.
+type BatcherConfig struct {
+	// MaxBatchSize is the largest number of texts sent in one engine call.
+	// Defaults to 32.
+	MaxBatchSize int
+	// MaxDelay is how long the first text of a batch waits for others to
+	// join it before the batch is sent anyway. Defaults to 5ms.
+	MaxDelay time.Duration
+	// MaxConcurrent is the number of batches encoded at once. Set it to the
+	// pool size when wrapping an EmbedderPool. Defaults to 1.
+	MaxConcurrent int
+}
+
+// BatcherStats is a snapshot of a Batcher's counters since it was created.
+type BatcherStats struct {
+	// Batches is the number of engine calls made.
+	Batches uint64
+	// Requests is the number of texts sent to the engine.
+	Requests uint64
+	// Errors is the number of batches whose engine call failed.
+	Errors uint64
+	// Retries is the number of texts sent again after their batch failed.
+	// Retried texts are also counted in Requests.
+	Retries uint64
+	// MaxBatchSize is the largest batch sent.
+	MaxBatchSize int
+	// BatchSizes counts batches by size; BatchSizes[n] is the number of
+	// batches of n texts.
+	BatchSizes []uint64
+	// QueueTime is the total time texts spent waiting between the Encode
+	// call and their batch being sent to the engine.
+	QueueTime time.Duration
+	// MaxQueueTime is the longest any single text waited.
+	MaxQueueTime time.Duration
+}
+
+// MeanBatchSize returns the average number of texts per engine call.
+func (s BatcherStats) MeanBatchSize() float64 {
+	if s.Batches == 0 {
+		return 0
+	}
+	return float64(s.Requests) / float64(s.Batches)
+}
+
+// MeanQueueTime returns the average time a text waited before its batch
+// was sent.
+func (s BatcherStats) MeanQueueTime() time.Duration {
+	n := s.Requests - s.Retries
+	if n == 0 {
+		return 0
+	}
+	return s.QueueTime / time.Duration(n)
+}
+
+// Batcher collects single-text Encode calls from many goroutines into
+// batches, so concurrent callers share the throughput of EncodeBatch. A
+// batch is sent when it reaches MaxBatchSize texts or when its first text
+// has waited MaxDelay, whichever comes first. A Batcher is safe for
+// concurrent use.
+type Batcher struct {
+	enc  BatchEncoder
+	cfg  BatcherConfig
+	reqs chan *batchRequest
+	done chan struct{}
+	once sync.Once
+	loop sync.WaitGroup
+	busy sync.WaitGroup
+
+	mu    sync.Mutex
+	stats BatcherStats
+}
+
+type batchRequest struct {
+	ctx    context.Context
+	text   string
+	queued time.Time
+	result chan batchResult
+}
+
+type batchResult struct {
+	vec []float32
+	err error
+}
+
+// NewBatcher starts a batcher that encodes with enc. The caller still owns
+// enc and must close it after closing the batcher.
+func NewBatcher(enc BatchEncoder, cfg BatcherConfig) (*Batcher, error) {
+	if enc == nil {
+		return nil, invalidConfig("batcher needs an encoder")
+	}
+	if cfg.MaxBatchSize == 0 {
+		cfg.MaxBatchSize = 32
+	}
+	if cfg.MaxDelay == 0 {
+		cfg.MaxDelay = 5 * time.Millisecond
+	}
+	if cfg.MaxConcurrent == 0 {
+		cfg.MaxConcurrent = 1
+	}
+	switch {
+	case cfg.MaxBatchSize < 1:
+		return nil, invalidConfig("MaxBatchSize must be positive, got %d", cfg.MaxBatchSize)
+	case cfg.MaxDelay < 0:
+		return nil, invalidConfig("MaxDelay must not be negative, got %v", cfg.MaxDelay)
+	case cfg.MaxConcurrent < 1:
+		return nil, invalidConfig("MaxConcurrent must be positive, got %d", cfg.MaxConcurrent)
+	}
+
+	b := &Batcher{
+		enc:   enc,
+		cfg:   cfg,
+		reqs:  make(chan *batchRequest),
+		done:  make(chan struct{}),
+		stats: BatcherStats{BatchSizes: make([]uint64, cfg.MaxBatchSize+1)},
+	}
+	b.loop.Add(1)
+	go b.run()
+	return b, nil
+}
+
+// Encode returns the embedding vector for the given text, encoded as part
+// of a batch with other concurrent calls.
+func (b *Batcher) Encode(text string) ([]float32, error) {
+	return b.EncodeContext(context.Background(), text)
+}
+
+// EncodeContext is like Encode but returns early with ErrCancelled or
+// ErrTimeout if ctx is done before the result is ready. A text whose ctx is
+// done before its batch is sent is dropped from the batch.
+func (b *Batcher) EncodeContext(ctx context.Context, text string) ([]float32, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+	req := &batchRequest{
+		ctx:    ctx,
+		text:   text,
+		queued: time.Now(),
+		result: make(chan batchResult, 1),
+	}
+	select {
+	case b.reqs <- req:
+	case <-b.done:
+		return nil, errors.New("batcher is closed")
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+	select {
+	case res := <-req.result:
+		return res.vec, res.err
+	case <-ctx.Done():
+		return nil, contextError(ctx.Err())
+	}
+}
+
+// Stats returns a snapshot of the batcher's counters.
+func (b *Batcher) Stats() BatcherStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.BatchSizes = append([]uint64(nil), b.stats.BatchSizes...)
+	return s
+}
+
+// Close stops accepting texts, sends any batch being collected and waits
+// for batches in progress to finish. It does not close the encoder. Safe to
+// call multiple times.
+func (b *Batcher) Close() error {
+	b.once.Do(func() {
+		close(b.done)
+		b.loop.Wait()
+		b.busy.Wait()
+	})
+	return nil
+}
+
+// run collects requests into batches until the batcher is closed.
+func (b *Batcher) run() {
+	defer b.loop.Done()
+	slots := make(chan struct{}, b.cfg.MaxConcurrent)
+	timer := time.NewTimer(0)
+	<-timer.C
+
+	for {
+		var first *batchRequest
+		select {
+		case first = <-b.reqs:
+		case <-b.done:
+			return
+		}
+
+		batch := []*batchRequest{first}
+		closing := false
+		timer.Reset(b.cfg.MaxDelay)
+	collect:
+		for len(batch) < b.cfg.MaxBatchSize {
+			select {
+			case req := <-b.reqs:
+				batch = append(batch, req)
+			case <-timer.C:
+				break collect
+			case <-b.done:
+				closing = true
+				break collect
+			}
+		}
+		if !timer.Stop() {
+			// the timer may have fired as the batch filled up; drain it so
+			// the next Reset starts clean
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+
+		slots <- struct{}{}
+		b.busy.Add(1)
+		go func() {
+			defer func() {
+				<-slots
+				b.busy.Done()
+			}()
+			b.encode(batch)
+		}()
+		if closing {
+			return
+		}
+	}
+}
+
+// encode sends one batch to the engine and fans the results out. Requests
+// whose callers have already given up are left out.
+func (b *Batcher) encode(batch []*batchRequest) {
+	now := time.Now()
+	live := batch[:0]
+	var queued, maxQueued time.Duration
+	for _, req := range batch {
+		if req.ctx.Err() != nil {
+			continue
+		}
+		live = append(live, req)
+		wait := now.Sub(req.queued)
+		queued += wait
+		if wait > maxQueued {
+			maxQueued = wait
+		}
+	}
+	if len(live) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	b.stats.QueueTime += queued
+	if maxQueued > b.stats.MaxQueueTime {
+		b.stats.MaxQueueTime = maxQueued
+	}
+	b.mu.Unlock()
+
+	b.send(live)
+}
+
+// send makes one engine call for reqs. The call is cancelled once every
+// caller in reqs has given up. When the call fails because of one input,
+// that input should not fail the others: if the error names the input that
+// is too long, only that request fails and the rest are sent again; other
+// input errors send each text on its own, so every caller gets its own
+// result. Any other error fails every request, so that a failing engine is
+// not sent more work.
+func (b *Batcher) send(reqs []*batchRequest) {
+	texts := make([]string, len(reqs))
+	for i, req := range reqs {
+		texts[i] = req.text
+	}
+	ctx, cancel := batchContext(reqs)
+	vecs, err := b.enc.EncodeBatchContext(ctx, texts)
+	cancel()
+	if err == nil && len(vecs) != len(texts) {
+		err = fmt.Errorf("encoder returned %d vectors for %d texts", len(vecs), len(texts))
+	}
+	b.record(len(reqs), err)
+
+	switch {
+	case err == nil:
+		for i, req := range reqs {
+			req.result <- batchResult{vec: vecs[i]}
+		}
+		return
+	case len(reqs) == 1 || !inputError(err):
+		for _, req := range reqs {
+			req.result <- batchResult{err: err}
+		}
+		return
+	}
+
+	var tl *InputTooLongError
+	if errors.As(err, &tl) && tl.Index >= 0 && tl.Index < len(reqs) {
+		// renumber the error for the caller, whose text is input 0
+		i := tl.Index
+		reqs[i].result <- batchResult{err: tooLong(0, tl.Tokens, tl.Max)}
+		rest := append(reqs[:i:i], reqs[i+1:]...)
+		if rest = b.retry(rest); len(rest) > 0 {
+			b.send(rest)
+		}
+		return
+	}
+	for _, req := range b.retry(reqs) {
+		b.send([]*batchRequest{req})
+	}
+}
+
+// batchContext returns a context that is done once the context of every
+// request in reqs is done. The returned function releases its resources.
+func batchContext(reqs []*batchRequest) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var waiting atomic.Int64
+	waiting.Store(int64(len(reqs)))
+	stops := make([]func() bool, len(reqs))
+	for i, req := range reqs {
+		stops[i] = context.AfterFunc(req.ctx, func() {
+			if waiting.Add(-1) == 0 {
+				cancel()
+			}
+		})
+	}
+	return ctx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancel()
+	}
+}
+
+// inputError reports whether err was caused by an input rather than by the
+// engine, so that sending the inputs again on their own can isolate it.
+func inputError(err error) bool {
+	var ke *KjarniError
+	return errors.As(err, &ke) && (ke.Code == ErrInputTooLong || ke.Code == ErrInvalidUtf8)
+}
+
+// retry counts reqs as retried and returns those whose callers are still
+// waiting.
+func (b *Batcher) retry(reqs []*batchRequest) []*batchRequest {
+	live := reqs[:0]
+	for _, req := range reqs {
+		if req.ctx.Err() == nil {
+			live = append(live, req)
+		}
+	}
+	b.mu.Lock()
+	b.stats.Retries += uint64(len(live))
+	b.mu.Unlock()
+	return live
+}
+
+// record counts one engine call of n texts.
+func (b *Batcher) record(n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := &b.stats
+	s.Batches++
+	s.Requests += uint64(n)
+	if err != nil {
+		s.Errors++
+	}
+	s.BatchSizes[n]++
+	if n > s.MaxBatchSize {
+		s.MaxBatchSize = n
+	}
+}
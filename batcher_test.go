@@ -0,0 +1,415 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testEncoder returns [len(text)] for each text, or the error fail returns
+// for the batch.
+type testEncoder struct {
+	fail func(texts []string) error
+
+	mu    sync.Mutex
+	calls [][]string
+}
+
+func (e *testEncoder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	e.mu.Lock()
+	e.calls = append(e.calls, append([]string(nil), texts...))
+	e.mu.Unlock()
+	if e.fail != nil {
+		if err := e.fail(texts); err != nil {
+			return nil, err
+		}
+	}
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = []float32{float32(len(t))}
+	}
+	return out, nil
+}
+
+// encodeAll encodes texts from one goroutine each. The batcher must be
+// configured so that they all land in one batch.
+func encodeAll(b *Batcher, texts []string) ([][]float32, []error) {
+	vecs := make([][]float32, len(texts))
+	errs := make([]error, len(texts))
+	var wg sync.WaitGroup
+	for i, t := range texts {
+		wg.Add(1)
+		go func(i int, t string) {
+			defer wg.Done()
+			vecs[i], errs[i] = b.Encode(t)
+		}(i, t)
+	}
+	wg.Wait()
+	return vecs, errs
+}
+
+func TestNewBatcherConfig(t *testing.T) {
+	enc := &testEncoder{}
+	tests := []struct {
+		enc BatchEncoder
+		cfg BatcherConfig
+		ok  bool
+	}{
+		{enc, BatcherConfig{}, true},
+		{enc, BatcherConfig{MaxBatchSize: 1, MaxDelay: time.Second, MaxConcurrent: 4}, true},
+		{nil, BatcherConfig{}, false},
+		{enc, BatcherConfig{MaxBatchSize: -1}, false},
+		{enc, BatcherConfig{MaxDelay: -time.Millisecond}, false},
+		{enc, BatcherConfig{MaxConcurrent: -2}, false},
+	}
+	for _, tt := range tests {
+		b, err := NewBatcher(tt.enc, tt.cfg)
+		if tt.ok != (err == nil) {
+			t.Errorf("%+v: error %v", tt.cfg, err)
+		}
+		if err == nil {
+			b.Close()
+		} else if errorCode(err) != ErrInvalidConfig {
+			t.Errorf("%+v: got %v, want ErrInvalidConfig", tt.cfg, err)
+		}
+	}
+}
+
+func TestBatcherGroupsCalls(t *testing.T) {
+	tests := []struct {
+		texts     int
+		size      int
+		delay     time.Duration
+		wantCalls int
+	}{
+		// a full batch is sent without waiting for the delay
+		{8, 8, time.Hour, 1},
+		{12, 4, time.Hour, 3},
+		{1, 32, time.Millisecond, 1},
+	}
+	for _, tt := range tests {
+		enc := &testEncoder{}
+		b, err := NewBatcher(enc, BatcherConfig{MaxBatchSize: tt.size, MaxDelay: tt.delay, MaxConcurrent: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		texts := make([]string, tt.texts)
+		for i := range texts {
+			texts[i] = strings.Repeat("x", i)
+		}
+		vecs, errs := encodeAll(b, texts)
+		for i := range texts {
+			if errs[i] != nil || len(vecs[i]) != 1 || vecs[i][0] != float32(i) {
+				t.Errorf("%d texts: text %d got %v, %v", tt.texts, i, vecs[i], errs[i])
+			}
+		}
+		b.Close()
+
+		s := b.Stats()
+		if len(enc.calls) != tt.wantCalls || s.Batches != uint64(tt.wantCalls) {
+			t.Errorf("%d texts in batches of %d: %d calls, stats %d, want %d",
+				tt.texts, tt.size, len(enc.calls), s.Batches, tt.wantCalls)
+		}
+		if s.Requests != uint64(tt.texts) || s.Errors != 0 || s.Retries != 0 {
+			t.Errorf("%d texts: stats %+v", tt.texts, s)
+		}
+		if want := float64(tt.texts) / float64(tt.wantCalls); s.MeanBatchSize() != want {
+			t.Errorf("%d texts: mean batch %v, want %v", tt.texts, s.MeanBatchSize(), want)
+		}
+	}
+}
+
+func TestBatcherFailedBatch(t *testing.T) {
+	errBad := errors.New("bad text")
+	tests := []struct {
+		name        string
+		fail        func(texts []string) error
+		texts       []string
+		wantErr     map[int]ErrorCode // by text index; others must succeed
+		wantCalls   int
+		wantRetries uint64
+	}{
+		{
+			name: "too long input fails alone",
+			fail: func(texts []string) error {
+				for i, t := range texts {
+					if t == "long" {
+						return tooLong(i, 600, 512)
+					}
+				}
+				return nil
+			},
+			texts:       []string{"a", "bb", "long", "ccc"},
+			wantErr:     map[int]ErrorCode{2: ErrInputTooLong},
+			wantCalls:   2,
+			wantRetries: 3,
+		},
+		{
+			name: "two too long inputs",
+			fail: func(texts []string) error {
+				for i, t := range texts {
+					if t == "long" {
+						return tooLong(i, 600, 512)
+					}
+				}
+				return nil
+			},
+			texts:       []string{"long", "a", "long"},
+			wantErr:     map[int]ErrorCode{0: ErrInputTooLong, 2: ErrInputTooLong},
+			wantCalls:   3,
+			wantRetries: 3,
+		},
+		{
+			name: "invalid utf8 retries one by one",
+			fail: func(texts []string) error {
+				for _, t := range texts {
+					if t == "bad" {
+						return &KjarniError{Code: ErrInvalidUtf8, Message: "invalid utf-8"}
+					}
+				}
+				return nil
+			},
+			texts:       []string{"a", "bad", "bb"},
+			wantErr:     map[int]ErrorCode{1: ErrInvalidUtf8},
+			wantCalls:   4,
+			wantRetries: 3,
+		},
+		{
+			name: "engine errors fail every caller",
+			fail: func(texts []string) error {
+				return &KjarniError{Code: ErrInferenceFailed, Message: "engine down"}
+			},
+			texts:     []string{"a", "b", "c"},
+			wantErr:   map[int]ErrorCode{0: ErrInferenceFailed, 1: ErrInferenceFailed, 2: ErrInferenceFailed},
+			wantCalls: 1,
+		},
+		{
+			name: "other errors fail every caller",
+			fail: func(texts []string) error {
+				return errBad
+			},
+			texts:     []string{"a", "bad"},
+			wantErr:   map[int]ErrorCode{0: ErrOk, 1: ErrOk}, // not a KjarniError
+			wantCalls: 1,
+		},
+		{
+			name: "index out of range retries one by one",
+			fail: func(texts []string) error {
+				if len(texts) > 1 {
+					return tooLong(len(texts), 600, 512)
+				}
+				return nil
+			},
+			texts:       []string{"a", "b"},
+			wantCalls:   3,
+			wantRetries: 2,
+		},
+	}
+	for _, tt := range tests {
+		enc := &testEncoder{fail: tt.fail}
+		b, err := NewBatcher(enc, BatcherConfig{MaxBatchSize: len(tt.texts), MaxDelay: time.Hour})
+		if err != nil {
+			t.Fatal(err)
+		}
+		vecs, errs := encodeAll(b, tt.texts)
+		b.Close()
+
+		for i, text := range tt.texts {
+			code, fails := tt.wantErr[i]
+			switch {
+			case !fails && (errs[i] != nil || vecs[i][0] != float32(len(text))):
+				t.Errorf("%s: text %d got %v, %v", tt.name, i, vecs[i], errs[i])
+			case fails && errs[i] == nil:
+				t.Errorf("%s: text %d succeeded, want an error", tt.name, i)
+			case fails && errorCode(errs[i]) != code:
+				t.Errorf("%s: text %d got %v, want code %d", tt.name, i, errs[i], code)
+			}
+		}
+		if len(enc.calls) != tt.wantCalls {
+			t.Errorf("%s: %d engine calls %q, want %d", tt.name, len(enc.calls), enc.calls, tt.wantCalls)
+		}
+		s := b.Stats()
+		if s.Retries != tt.wantRetries || s.Batches != uint64(tt.wantCalls) {
+			t.Errorf("%s: stats %+v", tt.name, s)
+		}
+		if s.Requests-s.Retries != uint64(len(tt.texts)) {
+			t.Errorf("%s: %d requests less %d retries, want %d", tt.name, s.Requests, s.Retries, len(tt.texts))
+		}
+		// retried requests are queued once, so they must not dilute the mean
+		if want := s.QueueTime / time.Duration(len(tt.texts)); s.MeanQueueTime() != want {
+			t.Errorf("%s: mean queue time %v, want %v", tt.name, s.MeanQueueTime(), want)
+		}
+	}
+}
+
+func TestBatcherTooLongRenumbered(t *testing.T) {
+	enc := &testEncoder{fail: func(texts []string) error {
+		if len(texts) == 2 {
+			return tooLong(1, 600, 512)
+		}
+		return nil
+	}}
+	b, _ := NewBatcher(enc, BatcherConfig{MaxBatchSize: 2, MaxDelay: time.Hour})
+	defer b.Close()
+	_, errs := encodeAll(b, []string{"a", "b"})
+
+	// the caller sent one text, so the error must not point at input 1
+	failed := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		failed++
+		var tl *InputTooLongError
+		if !errors.As(err, &tl) || *tl != (InputTooLongError{Index: 0, Tokens: 600, Max: 512}) {
+			t.Errorf("got %v", err)
+		}
+	}
+	if failed != 1 {
+		t.Errorf("%d requests failed, want 1", failed)
+	}
+}
+
+// blockingEncoder reports each call's context on calls and blocks until it
+// is done.
+type blockingEncoder struct {
+	calls chan context.Context
+}
+
+func (e *blockingEncoder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls <- ctx
+	<-ctx.Done()
+	return nil, contextError(ctx.Err())
+}
+
+func TestBatcherCancelsAbandonedBatch(t *testing.T) {
+	enc := &blockingEncoder{calls: make(chan context.Context, 1)}
+	b, _ := NewBatcher(enc, BatcherConfig{MaxBatchSize: 2, MaxDelay: time.Hour})
+	defer b.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	for _, t := range []string{"a", "b"} {
+		go func(t string) {
+			_, err := b.EncodeContext(ctx, t)
+			errs <- err
+		}(t)
+	}
+	engineCtx := <-enc.calls
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-errs; errorCode(err) != ErrCancelled {
+			t.Errorf("caller: got %v", err)
+		}
+	}
+	select {
+	case <-engineCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("engine call not cancelled after every caller gave up")
+	}
+}
+
+func TestBatchContext(t *testing.T) {
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	reqs := []*batchRequest{{ctx: ctx1}, {ctx: ctx2}}
+
+	ctx, stop := batchContext(reqs)
+	defer stop()
+	cancel1()
+	time.Sleep(10 * time.Millisecond)
+	if ctx.Err() != nil {
+		t.Fatal("batch cancelled while a caller is waiting")
+	}
+	cancel2()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("batch not cancelled after every caller gave up")
+	}
+}
+
+func TestBatcherStatsMeanQueueTime(t *testing.T) {
+	tests := []struct {
+		s    BatcherStats
+		want time.Duration
+	}{
+		{BatcherStats{}, 0},
+		{BatcherStats{Requests: 4, QueueTime: 40 * time.Millisecond}, 10 * time.Millisecond},
+		// three retries of four texts: the mean is over the four
+		{BatcherStats{Requests: 7, Retries: 3, QueueTime: 40 * time.Millisecond}, 10 * time.Millisecond},
+		{BatcherStats{Requests: 3, Retries: 3, QueueTime: time.Millisecond}, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.s.MeanQueueTime(); got != tt.want {
+			t.Errorf("%+v: got %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestBatcherContext(t *testing.T) {
+	b, _ := NewBatcher(&testEncoder{}, BatcherConfig{MaxDelay: time.Hour})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := b.EncodeContext(ctx, "a"); errorCode(err) != ErrCancelled {
+		t.Errorf("cancelled: got %v", err)
+	}
+
+	// a caller waiting for its batch returns when its deadline passes
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := b.EncodeContext(ctx, "a"); errorCode(err) != ErrTimeout {
+		t.Errorf("timeout: got %v", err)
+	}
+
+	b.Close()
+	b.Close()
+	if _, err := b.Encode("a"); err == nil {
+		t.Error("Encode after Close succeeded")
+	}
+}
+
+func TestBatcherCloseSendsPending(t *testing.T) {
+	enc := &testEncoder{}
+	b, _ := NewBatcher(enc, BatcherConfig{MaxDelay: time.Hour})
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Encode("a")
+		done <- err
+	}()
+	// give the text time to be queued; the batch then waits for the delay
+	time.Sleep(50 * time.Millisecond)
+	if b.Stats().Batches != 0 {
+		t.Fatal("batch sent before Close")
+	}
+	b.Close()
+	if err := <-done; err != nil {
+		t.Errorf("pending text: %v", err)
+	}
+	if len(enc.calls) != 1 {
+		t.Errorf("%d engine calls, want 1", len(enc.calls))
+	}
+}
+
+func TestBatcherStatsQueueTime(t *testing.T) {
+	b, _ := NewBatcher(&testEncoder{}, BatcherConfig{MaxDelay: 20 * time.Millisecond})
+	defer b.Close()
+	encodeAll(b, []string{"a", "b"})
+
+	s := b.Stats()
+	if s.MaxQueueTime < 20*time.Millisecond || s.MeanQueueTime() <= 0 || s.MeanQueueTime() > s.MaxQueueTime {
+		t.Errorf("queue time: mean %v, max %v", s.MeanQueueTime(), s.MaxQueueTime)
+	}
+	if s.BatchSizes[2]+s.BatchSizes[1] == 0 {
+		t.Errorf("batch sizes %v", s.BatchSizes)
+	}
+	s.BatchSizes[1] = 99
+	if b.Stats().BatchSizes[1] == 99 {
+		t.Error("Stats shares BatchSizes with the batcher")
+	}
+}
@@ -0,0 +1,426 @@
+package kjarni
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// CacheEncoder is an encoder whose output is fully determined by its model
+// name and normalization setting. Dim is the length of every vector it
+// returns. *Embedder and *EmbedderPool implement it.
+type CacheEncoder interface {
+	BatchEncoder
+	Model() string
+	Normalized() bool
+	Dim() int
+}
+
+// CacheConfig configures a CachedEmbedder. Zero fields take their defaults.
+type CacheConfig struct {
+	// MaxEntries bounds the number of vectors kept in memory. The least
+	// recently used vector is evicted first. Defaults to 10000.
+	MaxEntries int
+	// Dir, if set, also stores every vector on disk under this directory,
+	// so the cache survives restarts and can be shared by several
+	// processes. Vectors found on disk are promoted to memory. Nothing
+	// removes files from Dir: it grows by one file per distinct text and
+	// model, and Purge only clears memory. Delete the directory, or old
+	// files in it, to reclaim space.
+	Dir string
+}
+
+// CacheStats is a snapshot of a CachedEmbedder's counters.
+type CacheStats struct {
+	// Hits counts texts found in memory, including texts another caller
+	// was already sending to the engine.
+	Hits uint64
+	// DiskHits counts texts missing from memory but found on disk.
+	DiskHits uint64
+	// Misses counts texts sent to the engine.
+	Misses uint64
+	// Evictions counts vectors dropped from memory to stay within
+	// MaxEntries.
+	Evictions uint64
+	// Entries is the number of vectors currently in memory.
+	Entries int
+}
+
+// HitRate returns the fraction of lookups served from memory or disk.
+func (s CacheStats) HitRate() float64 {
+	total := s.Hits + s.DiskHits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits+s.DiskHits) / float64(total)
+}
+
+// CachedEmbedder remembers the vectors an encoder returns, so repeated texts
+// are encoded once. Entries are keyed by a SHA-256 hash of the model name,
+// the normalization setting and the text, so caches for different models
+// can share a directory. A CachedEmbedder is safe for concurrent use;
+// concurrent misses for the same text make one encoder call.
+type CachedEmbedder struct {
+	enc    CacheEncoder
+	cfg    CacheConfig
+	dim    int
+	prefix []byte // hashed ahead of each text
+
+	mu       sync.Mutex
+	lru      *list.List // front is most recently used
+	entries  map[cacheKey]*list.Element
+	inflight map[cacheKey]*cacheCall
+	stats    CacheStats
+}
+
+type cacheKey [sha256.Size]byte
+
+type cacheEntry struct {
+	key cacheKey
+	vec []float32
+}
+
+// cacheCall is a text being encoded. Other callers missing the same key
+// wait for it instead of encoding the text again.
+type cacheCall struct {
+	key  cacheKey
+	text string
+	done chan struct{} // closed once vec or err is set
+	vec  []float32
+	err  error
+}
+
+// NewCachedEmbedder wraps enc with a cache. The caller still owns enc and
+// must close it when done.
+func NewCachedEmbedder(enc CacheEncoder, cfg CacheConfig) (*CachedEmbedder, error) {
+	if enc == nil {
+		return nil, invalidConfig("cache needs an encoder")
+	}
+	if cfg.MaxEntries == 0 {
+		cfg.MaxEntries = 10000
+	}
+	if cfg.MaxEntries < 0 {
+		return nil, invalidConfig("MaxEntries must be positive, got %d", cfg.MaxEntries)
+	}
+	if cfg.Dir != "" {
+		if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	// a NUL cannot appear in a model name, so the prefix is unambiguous
+	prefix := append([]byte(enc.Model()), 0, 0, 0)
+	if enc.Normalized() {
+		prefix[len(prefix)-2] = 1
+	}
+	return &CachedEmbedder{
+		enc:      enc,
+		cfg:      cfg,
+		dim:      enc.Dim(),
+		prefix:   prefix,
+		lru:      list.New(),
+		entries:  make(map[cacheKey]*list.Element),
+		inflight: make(map[cacheKey]*cacheCall),
+	}, nil
+}
+
+// Encode returns the embedding vector for the given text, from the cache if
+// possible.
+func (c *CachedEmbedder) Encode(text string) ([]float32, error) {
+	return c.EncodeContext(context.Background(), text)
+}
+
+// EncodeContext is like Encode but passes ctx to the encoder on a miss.
+func (c *CachedEmbedder) EncodeContext(ctx context.Context, text string) ([]float32, error) {
+	vecs, err := c.EncodeBatchContext(ctx, []string{text})
+	if err != nil {
+		return nil, err
+	}
+	return vecs[0], nil
+}
+
+// EncodeBatch returns embedding vectors for multiple texts, in input order.
+// Only texts missing from the cache are sent to the encoder, in a single
+// batch with duplicates removed. Texts another caller is already encoding
+// are waited for rather than sent again.
+func (c *CachedEmbedder) EncodeBatch(texts []string) ([][]float32, error) {
+	return c.EncodeBatchContext(context.Background(), texts)
+}
+
+// EncodeBatchContext is like EncodeBatch but passes ctx to the encoder.
+func (c *CachedEmbedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := checkContext(ctx); err != nil {
+		return nil, err
+	}
+
+	out := make([][]float32, len(texts))
+	// misses maps each distinct missing key to the inputs waiting for it
+	misses := make(map[cacheKey][]int)
+	var missTexts []string
+	var missKeys []cacheKey
+
+	for i, t := range texts {
+		k := c.key(t)
+		if vec, ok := c.lookup(k); ok {
+			out[i] = vec
+			continue
+		}
+		if _, seen := misses[k]; !seen {
+			missTexts = append(missTexts, t)
+			missKeys = append(missKeys, k)
+		}
+		misses[k] = append(misses[k], i)
+	}
+	// wait for texts another caller is encoding and encode the rest; if a
+	// call being waited for fails, its texts are claimed again
+	for len(missKeys) > 0 {
+		own, wait := c.claim(missKeys, missTexts)
+		if len(own) > 0 {
+			if err := c.encode(ctx, own); err != nil {
+				return nil, err
+			}
+		}
+		missKeys, missTexts = nil, nil
+		var hits uint64
+		for j, call := range append(own, wait...) {
+			select {
+			case <-call.done:
+			case <-ctx.Done():
+				return nil, contextError(ctx.Err())
+			}
+			if call.err != nil {
+				missKeys = append(missKeys, call.key)
+				missTexts = append(missTexts, call.text)
+				continue
+			}
+			if j >= len(own) {
+				hits++
+			}
+			for _, i := range misses[call.key] {
+				out[i] = append([]float32(nil), call.vec...)
+			}
+		}
+		c.mu.Lock()
+		c.stats.Hits += hits
+		c.mu.Unlock()
+	}
+	return out, nil
+}
+
+// claim returns a call for each key. Keys another caller is encoding, or
+// that reached memory since they were looked up, are returned in wait. For
+// the rest, own holds new calls that the caller must pass to encode.
+func (c *CachedEmbedder) claim(keys []cacheKey, texts []string) (own, wait []*cacheCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j, k := range keys {
+		if call, ok := c.inflight[k]; ok {
+			wait = append(wait, call)
+			continue
+		}
+		call := &cacheCall{key: k, text: texts[j], done: make(chan struct{})}
+		if el, ok := c.entries[k]; ok {
+			c.lru.MoveToFront(el)
+			call.vec = el.Value.(*cacheEntry).vec
+			close(call.done)
+			wait = append(wait, call)
+			continue
+		}
+		c.inflight[k] = call
+		own = append(own, call)
+	}
+	return own, wait
+}
+
+// encode sends the texts of calls to the encoder in one batch, stores the
+// vectors and completes the calls.
+func (c *CachedEmbedder) encode(ctx context.Context, calls []*cacheCall) error {
+	texts := make([]string, len(calls))
+	for j, call := range calls {
+		texts[j] = call.text
+	}
+	c.mu.Lock()
+	c.stats.Misses += uint64(len(calls))
+	c.mu.Unlock()
+
+	vecs, err := c.enc.EncodeBatchContext(ctx, texts)
+	if err == nil && len(vecs) != len(texts) {
+		err = fmt.Errorf("encoder returned %d vectors for %d texts", len(vecs), len(texts))
+	}
+	for j, call := range calls {
+		if err != nil {
+			call.err = err
+			continue
+		}
+		call.vec = append([]float32(nil), vecs[j]...)
+		c.store(call.key, call.vec)
+	}
+	c.mu.Lock()
+	for _, call := range calls {
+		delete(c.inflight, call.key)
+	}
+	c.mu.Unlock()
+	for _, call := range calls {
+		close(call.done)
+	}
+	return err
+}
+
+// Model returns the wrapped encoder's model name.
+func (c *CachedEmbedder) Model() string {
+	return c.enc.Model()
+}
+
+// Normalized reports whether the wrapped encoder returns unit-length vectors.
+func (c *CachedEmbedder) Normalized() bool {
+	return c.enc.Normalized()
+}
+
+// Dim returns the wrapped encoder's vector dimension.
+func (c *CachedEmbedder) Dim() int {
+	return c.dim
+}
+
+// Stats returns a snapshot of the cache counters.
+func (c *CachedEmbedder) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.stats
+	s.Entries = c.lru.Len()
+	return s
+}
+
+// Purge drops every vector held in memory. Vectors on disk are kept.
+func (c *CachedEmbedder) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[cacheKey]*list.Element)
+}
+
+func (c *CachedEmbedder) key(text string) cacheKey {
+	h := sha256.New()
+	h.Write(c.prefix)
+	h.Write([]byte(text))
+	var k cacheKey
+	h.Sum(k[:0])
+	return k
+}
+
+// lookup returns a copy of the cached vector for k from memory or disk.
+func (c *CachedEmbedder) lookup(k cacheKey) ([]float32, bool) {
+	c.mu.Lock()
+	if el, ok := c.entries[k]; ok {
+		c.lru.MoveToFront(el)
+		c.stats.Hits++
+		vec := append([]float32(nil), el.Value.(*cacheEntry).vec...)
+		c.mu.Unlock()
+		return vec, true
+	}
+	c.mu.Unlock()
+
+	if c.cfg.Dir == "" {
+		return nil, false
+	}
+	// a vector of the wrong length was written by a different model under
+	// the same name, and is treated as missing
+	vec, ok := c.readDisk(k)
+	if !ok || len(vec) != c.dim {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.stats.DiskHits++
+	c.insert(k, vec)
+	c.mu.Unlock()
+	return append([]float32(nil), vec...), true
+}
+
+// store adds vec to memory and, if configured, to disk.
+func (c *CachedEmbedder) store(k cacheKey, vec []float32) {
+	vec = append([]float32(nil), vec...)
+	c.mu.Lock()
+	c.insert(k, vec)
+	c.mu.Unlock()
+	if c.cfg.Dir != "" {
+		// a failed write only costs a future miss
+		c.writeDisk(k, vec)
+	}
+}
+
+// insert adds or refreshes an entry, evicting from the back of the list.
+// The caller must hold c.mu.
+func (c *CachedEmbedder) insert(k cacheKey, vec []float32) {
+	if el, ok := c.entries[k]; ok {
+		el.Value.(*cacheEntry).vec = vec
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[k] = c.lru.PushFront(&cacheEntry{key: k, vec: vec})
+	for c.lru.Len() > c.cfg.MaxEntries {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.entries, el.Value.(*cacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// On disk each vector is a file named by the hex key, in a subdirectory
+// named by its first two characters. The file holds the little-endian
+// float32 values followed by a CRC-32C of those bytes.
+
+func (c *CachedEmbedder) path(k cacheKey) string {
+	name := hex.EncodeToString(k[:])
+	return filepath.Join(c.cfg.Dir, name[:2], name)
+}
+
+func (c *CachedEmbedder) readDisk(k cacheKey) ([]float32, bool) {
+	data, err := os.ReadFile(c.path(k))
+	if err != nil || len(data) < 4 || len(data)%4 != 0 {
+		return nil, false
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	if crc32.Checksum(body, storeCRC) != binary.LittleEndian.Uint32(sum) {
+		return nil, false
+	}
+	vec := make([]float32, len(body)/4)
+	for i := range vec {
+		vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(body[i*4:]))
+	}
+	return vec, true
+}
+
+func (c *CachedEmbedder) writeDisk(k cacheKey, vec []float32) error {
+	data := make([]byte, len(vec)*4+4)
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(data[i*4:], math.Float32bits(v))
+	}
+	binary.LittleEndian.PutUint32(data[len(vec)*4:], crc32.Checksum(data[:len(vec)*4], storeCRC))
+
+	path := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file and rename so readers never see a partial
+	// vector
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
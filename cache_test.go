@@ -0,0 +1,345 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testCacheEncoder is a testEncoder with a model name. Its vectors have
+// length 1, whatever dim says.
+type testCacheEncoder struct {
+	testEncoder
+	model      string
+	normalized bool
+	dim        int
+}
+
+func (e *testCacheEncoder) Model() string    { return e.model }
+func (e *testCacheEncoder) Normalized() bool { return e.normalized }
+func (e *testCacheEncoder) Dim() int         { return e.dim }
+
+func newTestCache(t *testing.T, cfg CacheConfig) (*CachedEmbedder, *testCacheEncoder) {
+	t.Helper()
+	enc := &testCacheEncoder{model: "test-model", dim: 1}
+	c, err := NewCachedEmbedder(enc, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, enc
+}
+
+// encodeVecs encodes texts and checks each vector is [len(text)].
+func encodeVecs(t *testing.T, c *CachedEmbedder, texts ...string) {
+	t.Helper()
+	vecs, err := c.EncodeBatch(texts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, text := range texts {
+		if !reflect.DeepEqual(vecs[i], []float32{float32(len(text))}) {
+			t.Errorf("%q: got %v", text, vecs[i])
+		}
+	}
+}
+
+func TestNewCachedEmbedderConfig(t *testing.T) {
+	if _, err := NewCachedEmbedder(nil, CacheConfig{}); errorCode(err) != ErrInvalidConfig {
+		t.Errorf("nil encoder: got %v", err)
+	}
+	if _, err := NewCachedEmbedder(&testCacheEncoder{}, CacheConfig{MaxEntries: -1}); errorCode(err) != ErrInvalidConfig {
+		t.Errorf("negative MaxEntries: got %v", err)
+	}
+}
+
+func TestCachedEmbedderHits(t *testing.T) {
+	c, enc := newTestCache(t, CacheConfig{})
+	steps := []struct {
+		texts     []string
+		wantCall  []string // texts sent to the encoder, nil for none
+		wantStats CacheStats
+	}{
+		{[]string{"a", "bb", "a"}, []string{"a", "bb"}, CacheStats{Misses: 2, Entries: 2}},
+		{[]string{"bb"}, nil, CacheStats{Hits: 1, Misses: 2, Entries: 2}},
+		{[]string{"a", "ccc", "ccc"}, []string{"ccc"}, CacheStats{Hits: 2, Misses: 3, Entries: 3}},
+		{nil, nil, CacheStats{Hits: 2, Misses: 3, Entries: 3}},
+	}
+	for i, step := range steps {
+		calls := len(enc.calls)
+		encodeVecs(t, c, step.texts...)
+		switch {
+		case step.wantCall == nil && len(enc.calls) != calls:
+			t.Errorf("step %d: encoder called with %q", i, enc.calls[calls:])
+		case step.wantCall != nil && (len(enc.calls) != calls+1 || !reflect.DeepEqual(enc.calls[calls], step.wantCall)):
+			t.Errorf("step %d: encoder calls %q, want %q", i, enc.calls[calls:], step.wantCall)
+		}
+		if s := c.Stats(); s != step.wantStats {
+			t.Errorf("step %d: stats %+v, want %+v", i, s, step.wantStats)
+		}
+	}
+	if got := c.Stats().HitRate(); got != 0.4 {
+		t.Errorf("hit rate %v, want 0.4", got)
+	}
+}
+
+func TestCachedEmbedderReturnsCopies(t *testing.T) {
+	c, _ := newTestCache(t, CacheConfig{})
+	vecs, _ := c.EncodeBatch([]string{"a", "a"})
+	vecs[0][0] = 99
+	if vecs[1][0] != 1 {
+		t.Error("duplicate texts share a vector")
+	}
+	vec, _ := c.Encode("a")
+	if vec[0] != 1 {
+		t.Errorf("cached vector changed by caller: %v", vec)
+	}
+	vec[0] = 99
+	if vec, _ := c.Encode("a"); vec[0] != 1 {
+		t.Errorf("cached vector changed by caller: %v", vec)
+	}
+}
+
+func TestCachedEmbedderEviction(t *testing.T) {
+	c, enc := newTestCache(t, CacheConfig{MaxEntries: 2})
+	encodeVecs(t, c, "a", "bb")
+	encodeVecs(t, c, "a")   // a is now most recently used
+	encodeVecs(t, c, "ccc") // evicts bb
+
+	s := c.Stats()
+	if s.Entries != 2 || s.Evictions != 1 {
+		t.Errorf("stats %+v", s)
+	}
+	calls := len(enc.calls)
+	encodeVecs(t, c, "a", "ccc")
+	if len(enc.calls) != calls {
+		t.Errorf("recently used entries evicted: %q", enc.calls[calls:])
+	}
+	encodeVecs(t, c, "bb")
+	if len(enc.calls) != calls+1 {
+		t.Error("least recently used entry not evicted")
+	}
+
+	c.Purge()
+	if s := c.Stats(); s.Entries != 0 {
+		t.Errorf("%d entries after Purge", s.Entries)
+	}
+}
+
+func TestCachedEmbedderKeys(t *testing.T) {
+	enc := &testCacheEncoder{model: "m"}
+	c, _ := NewCachedEmbedder(enc, CacheConfig{})
+	other := []*testCacheEncoder{
+		{model: "m2"},
+		{model: "m", normalized: true},
+		// the model name ends where the text begins
+		{model: "m\x00"},
+	}
+	for _, o := range other {
+		oc, _ := NewCachedEmbedder(o, CacheConfig{})
+		if c.key("a") == oc.key("a") {
+			t.Errorf("model %q, normalized %v: same key as model %q", o.model, o.normalized, enc.model)
+		}
+	}
+	if c.key("a") == c.key("b") || c.key("a") != c.key("a") {
+		t.Error("keys do not follow the text")
+	}
+}
+
+func TestCachedEmbedderError(t *testing.T) {
+	c, enc := newTestCache(t, CacheConfig{})
+	errEngine := errors.New("engine failed")
+	enc.fail = func([]string) error { return errEngine }
+	if _, err := c.EncodeBatch([]string{"a"}); !errors.Is(err, errEngine) {
+		t.Fatalf("got %v", err)
+	}
+	enc.fail = nil
+	if s := c.Stats(); s.Entries != 0 {
+		t.Errorf("failed encode cached %d entries", s.Entries)
+	}
+	encodeVecs(t, c, "a")
+}
+
+func TestCachedEmbedderDisk(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := newTestCache(t, CacheConfig{Dir: dir})
+	encodeVecs(t, c, "a", "bb")
+
+	// a second cache on the same directory starts with the vectors on disk
+	c2, enc2 := newTestCache(t, CacheConfig{Dir: dir})
+	encodeVecs(t, c2, "a", "bb")
+	if len(enc2.calls) != 0 {
+		t.Errorf("encoder called with %q", enc2.calls)
+	}
+	if s := c2.Stats(); s.DiskHits != 2 || s.Hits != 0 || s.Entries != 2 {
+		t.Errorf("stats %+v", s)
+	}
+
+	// Purge keeps the disk copy
+	c2.Purge()
+	encodeVecs(t, c2, "a")
+	if s := c2.Stats(); s.DiskHits != 3 || len(enc2.calls) != 0 {
+		t.Errorf("after Purge: stats %+v, calls %q", s, enc2.calls)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(files) != 2 {
+		t.Errorf("files on disk: %v", files)
+	}
+}
+
+func TestCachedEmbedderCorruptDisk(t *testing.T) {
+	corrupt := []struct {
+		name   string
+		modify func([]byte) []byte
+	}{
+		{"empty", func([]byte) []byte { return nil }},
+		{"truncated", func(b []byte) []byte { return b[:len(b)-1] }},
+		{"checksum only", func(b []byte) []byte { return b[len(b)-4:] }},
+		{"flipped bit", func(b []byte) []byte { b[0] ^= 1; return b }},
+		{"bad checksum", func(b []byte) []byte { b[len(b)-1] ^= 0x80; return b }},
+		{"extra data", func(b []byte) []byte { return append(b, 0, 0, 0, 0) }},
+	}
+	for _, tt := range corrupt {
+		dir := t.TempDir()
+		c, _ := newTestCache(t, CacheConfig{Dir: dir})
+		encodeVecs(t, c, "abcd")
+		path := c.path(c.key("abcd"))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, tt.modify(append([]byte(nil), data...)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// a corrupt file is a miss, and the fresh vector replaces it
+		c2, enc2 := newTestCache(t, CacheConfig{Dir: dir})
+		encodeVecs(t, c2, "abcd")
+		if len(enc2.calls) != 1 || c2.Stats().DiskHits != 0 {
+			t.Errorf("%s: corrupt file used, calls %q", tt.name, enc2.calls)
+		}
+		if got, _ := os.ReadFile(path); !reflect.DeepEqual(got, data) {
+			t.Errorf("%s: file not rewritten", tt.name)
+		}
+	}
+}
+
+func TestCachedEmbedderDiskDim(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := newTestCache(t, CacheConfig{Dir: dir})
+	encodeVecs(t, c, "a")
+
+	// a model of another size under the same name must not use the file
+	enc := &testCacheEncoder{model: "test-model", dim: 2}
+	c2, _ := NewCachedEmbedder(enc, CacheConfig{Dir: dir})
+	if _, err := c2.Encode("a"); err != nil {
+		t.Fatal(err)
+	}
+	if len(enc.calls) != 1 || c2.Stats().DiskHits != 0 {
+		t.Errorf("vector of the wrong length used: stats %+v", c2.Stats())
+	}
+}
+
+func TestCachedEmbedderInFlight(t *testing.T) {
+	errEngine := errors.New("engine failed")
+	tests := []struct {
+		name      string
+		firstErr  error // returned by the blocked call
+		wantCalls [][]string
+		wantStats CacheStats
+	}{
+		{
+			name:      "waits for the text in flight",
+			wantCalls: [][]string{{"a"}, {"bb"}},
+			wantStats: CacheStats{Hits: 1, Misses: 2, Entries: 2},
+		},
+		{
+			name:      "encodes the text if the call in flight fails",
+			firstErr:  errEngine,
+			wantCalls: [][]string{{"a"}, {"bb"}, {"a"}},
+			wantStats: CacheStats{Misses: 3, Entries: 2},
+		},
+	}
+	for _, tt := range tests {
+		c, enc := newTestCache(t, CacheConfig{})
+		started, release := make(chan struct{}), make(chan struct{})
+		var once sync.Once
+		enc.fail = func(texts []string) error {
+			var err error
+			once.Do(func() {
+				close(started)
+				<-release
+				err = tt.firstErr
+			})
+			return err
+		}
+
+		first := make(chan error, 1)
+		go func() {
+			_, err := c.Encode("a")
+			first <- err
+		}()
+		<-started
+
+		// a caller that gives up while waiting returns its own error
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if _, err := c.EncodeContext(ctx, "a"); errorCode(err) != ErrTimeout {
+			t.Errorf("%s: waiting caller timed out with %v", tt.name, err)
+		}
+		cancel()
+
+		second := make(chan error, 1)
+		go func() {
+			vecs, err := c.EncodeBatch([]string{"a", "bb"})
+			if err == nil && (vecs[0][0] != 1 || vecs[1][0] != 2) {
+				err = fmt.Errorf("got %v", vecs)
+			}
+			second <- err
+		}()
+		// let the second caller send bb and start waiting for a
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+
+		if err := <-first; err != tt.firstErr {
+			t.Errorf("%s: first caller got %v", tt.name, err)
+		}
+		if err := <-second; err != nil {
+			t.Errorf("%s: second caller got %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(enc.calls, tt.wantCalls) {
+			t.Errorf("%s: encoder calls %q, want %q", tt.name, enc.calls, tt.wantCalls)
+		}
+		if s := c.Stats(); s != tt.wantStats {
+			t.Errorf("%s: stats %+v, want %+v", tt.name, s, tt.wantStats)
+		}
+	}
+}
+
+func TestCachedEmbedderConcurrent(t *testing.T) {
+	c, _ := newTestCache(t, CacheConfig{MaxEntries: 8, Dir: t.TempDir()})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				text := fmt.Sprint((g + i) % 20)
+				vec, err := c.Encode(text)
+				if err != nil || vec[0] != float32(len(text)) {
+					t.Errorf("%q: got %v, %v", text, vec, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if s := c.Stats(); s.Entries > 8 || s.Hits+s.DiskHits+s.Misses != 400 {
+		t.Errorf("stats %+v", s)
+	}
+}
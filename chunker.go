@@ -0,0 +1,583 @@
+package kjarni
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ChunkStrategy selects where a Chunker prefers to split text.
+type ChunkStrategy int
+
+const (
+	// ChunkSentences packs whole sentences into each chunk.
+	ChunkSentences ChunkStrategy = 0
+	// ChunkParagraphs packs whole paragraphs, separated by blank lines, into
+	// each chunk.
+	ChunkParagraphs ChunkStrategy = 1
+	// ChunkMarkdown starts a new chunk at every heading and keeps fenced code
+	// blocks whole where they fit. Chunk.Heading holds the nearest heading.
+	ChunkMarkdown ChunkStrategy = 2
+	// ChunkCode splits source code between top-level blocks: a blank line
+	// followed by an unindented line.
+	ChunkCode ChunkStrategy = 3
+)
+
+// Chunk is a piece of a longer text produced by a Chunker. Start and End
+// are byte offsets into the original text, so Text == text[Start:End].
+type Chunk struct {
+	Index   int
+	Text    string
+	Start   int
+	End     int
+	Heading string
+}
+
+// Chunker splits long texts into chunks that fit a size limit, breaking at
+// natural boundaries so each chunk stays readable on its own. Pieces that
+// are too large on their own are split further by line, sentence, word and
+// finally character. Consecutive chunks share up to the overlap size of
+// trailing sentences or paragraphs from the previous chunk.
+//
+// Sizes are measured in characters by default, matching the Indexer. Pass
+// WithLengthFunc to measure in model tokens instead. Each piece of text and
+// each gap between pieces is measured once, and a chunk's length is the
+// sum of its parts, so a length function that does not add up over
+// concatenation, such as a subword tokenizer, is only approximated.
+type Chunker struct {
+	strategy ChunkStrategy
+	size     int
+	overlap  int
+	length   func(string) (int, error)
+	base     int // length of the empty string, e.g. special tokens
+}
+
+// NewChunker creates a chunker using the given strategy. WithChunkSize and
+// WithChunkOverlap set the limits, with the same defaults as the Indexer.
+func NewChunker(strategy ChunkStrategy, opts ...Option) (*Chunker, error) {
+	if strategy < ChunkSentences || strategy > ChunkCode {
+		return nil, invalidConfig("unknown chunk strategy %d", int(strategy))
+	}
+	o := applyOptions(opts)
+	if err := o.validateChunker(); err != nil {
+		return nil, err
+	}
+	length := o.lengthFunc
+	if length == nil {
+		length = func(s string) (int, error) { return utf8.RuneCountInString(s), nil }
+	}
+	base, err := length("")
+	if err != nil {
+		return nil, err
+	}
+	if base >= o.chunkSize {
+		return nil, invalidConfig("chunk size (%d) must be larger than the length of empty text (%d)", o.chunkSize, base)
+	}
+	return &Chunker{
+		strategy: strategy,
+		size:     o.chunkSize,
+		overlap:  o.chunkOverlap,
+		length:   length,
+		base:     base,
+	}, nil
+}
+
+// span is a run of text[start:end], trimmed of surrounding whitespace.
+// A hard span always starts a new chunk. Once measured, n is its length
+// and gap the length of the text between it and the previous span, both
+// less the chunker's base.
+type span struct {
+	start, end int
+	hard       bool
+	heading    string
+	n, gap     int
+}
+
+// Split divides text into chunks in document order. Whitespace-only text
+// yields no chunks. It returns the first error from the length function.
+func (c *Chunker) Split(text string) ([]Chunk, error) {
+	var units []span
+	switch c.strategy {
+	case ChunkSentences:
+		for _, p := range paragraphSpans(text, 0, len(text)) {
+			refined, err := c.refine(text, sentenceSpans(text, p.start, p.end), levelWords)
+			if err != nil {
+				return nil, err
+			}
+			units = append(units, refined...)
+		}
+	case ChunkParagraphs:
+		refined, err := c.refine(text, paragraphSpans(text, 0, len(text)), levelSentences)
+		if err != nil {
+			return nil, err
+		}
+		units = refined
+	case ChunkMarkdown:
+		for _, s := range markdownSpans(text) {
+			next := levelSentences
+			if strings.HasPrefix(text[s.start:s.end], "```") || strings.HasPrefix(text[s.start:s.end], "~~~") {
+				next = levelLines
+			}
+			refined, err := c.refine(text, []span{s}, next)
+			if err != nil {
+				return nil, err
+			}
+			for _, r := range refined {
+				r.heading = s.heading
+				units = append(units, r)
+			}
+		}
+	case ChunkCode:
+		refined, err := c.refine(text, codeSpans(text), levelLines)
+		if err != nil {
+			return nil, err
+		}
+		units = refined
+	}
+	return c.pack(text, units)
+}
+
+// measure returns the length of s less the base, so that the lengths of
+// adjacent pieces add up even when every text also counts special tokens.
+func (c *Chunker) measure(s string) (int, error) {
+	n, err := c.length(s)
+	return n - c.base, err
+}
+
+// Refinement levels, from coarsest to finest.
+const (
+	levelLines = iota
+	levelSentences
+	levelWords
+	levelRunes
+)
+
+// refine splits every span longer than the chunk size, starting at the
+// given level and moving to finer levels until each piece fits, and
+// measures the pieces. The first piece of a split span keeps its hard flag.
+func (c *Chunker) refine(text string, spans []span, level int) ([]span, error) {
+	var out []span
+	for _, s := range spans {
+		n, err := c.measure(text[s.start:s.end])
+		if err != nil {
+			return nil, err
+		}
+		if c.base+n <= c.size {
+			s.n = n
+			out = append(out, s)
+			continue
+		}
+		l := level
+		var pieces []span
+		for ; l <= levelRunes; l++ {
+			if pieces, err = c.splitAt(text, s, l); err != nil {
+				return nil, err
+			}
+			if len(pieces) > 1 {
+				break
+			}
+		}
+		if len(pieces) <= 1 {
+			// a single character larger than the limit
+			s.n = n
+			out = append(out, s)
+			continue
+		}
+		pieces[0].hard = s.hard
+		if l < levelRunes {
+			// runeSpans measures its pieces; coarser ones may not fit yet
+			if pieces, err = c.refine(text, pieces, l+1); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, pieces...)
+	}
+	return out, nil
+}
+
+func (c *Chunker) splitAt(text string, s span, level int) ([]span, error) {
+	switch level {
+	case levelLines:
+		return lineSpans(text, s.start, s.end), nil
+	case levelSentences:
+		return sentenceSpans(text, s.start, s.end), nil
+	case levelWords:
+		return wordSpans(text, s.start, s.end), nil
+	default:
+		return c.runeSpans(text, s.start, s.end)
+	}
+}
+
+// runeSpans cuts text[start:end] into the longest pieces that fit the chunk
+// size, at character boundaries. Each character is measured once.
+func (c *Chunker) runeSpans(text string, start, end int) ([]span, error) {
+	var out []span
+	cur := span{start: start, end: start}
+	for cur.end < end {
+		_, w := utf8.DecodeRuneInString(text[cur.end:end])
+		n, err := c.measure(text[cur.end : cur.end+w])
+		if err != nil {
+			return nil, err
+		}
+		if cur.end > cur.start && c.base+cur.n+n > c.size {
+			out = append(out, cur)
+			cur = span{start: cur.end, end: cur.end}
+		}
+		cur.end += w
+		cur.n += n
+	}
+	if cur.end > cur.start {
+		out = append(out, cur)
+	}
+	return out, nil
+}
+
+// pack greedily fills chunks with consecutive measured spans. The length
+// of a chunk is the base plus the lengths of its spans and the gaps
+// between them.
+func (c *Chunker) pack(text string, units []span) ([]Chunk, error) {
+	for i := 1; i < len(units); i++ {
+		gap, err := c.measure(text[units[i-1].end:units[i].start])
+		if err != nil {
+			return nil, err
+		}
+		units[i].gap = gap
+	}
+
+	var chunks []Chunk
+	var cur []span
+	n := 0 // length of cur less the base
+	flush := func() {
+		if len(cur) == 0 {
+			return
+		}
+		start, end := cur[0].start, cur[len(cur)-1].end
+		chunks = append(chunks, Chunk{
+			Index:   len(chunks),
+			Text:    text[start:end],
+			Start:   start,
+			End:     end,
+			Heading: cur[len(cur)-1].heading,
+		})
+	}
+
+	for _, u := range units {
+		switch {
+		case len(cur) == 0:
+			cur, n = []span{u}, u.n
+		case u.hard:
+			flush()
+			cur, n = []span{u}, u.n
+		case c.base+n+u.gap+u.n <= c.size:
+			cur = append(cur, u)
+			n += u.gap + u.n
+		default:
+			flush()
+			cur, n = c.overlapTail(cur, u)
+			if len(cur) > 0 {
+				n += u.gap
+			}
+			cur = append(cur, u)
+			n += u.n
+		}
+	}
+	flush()
+	return chunks, nil
+}
+
+// overlapTail returns the trailing spans of prev that fit within the
+// overlap size and still leave room for next, and their length less the
+// base.
+func (c *Chunker) overlapTail(prev []span, next span) ([]span, int) {
+	if c.overlap == 0 {
+		return nil, 0
+	}
+	i, n := len(prev), 0
+	for i > 0 {
+		m := prev[i-1].n
+		if i < len(prev) {
+			m += prev[i].gap
+		}
+		if c.base+n+m > c.overlap || c.base+n+m+next.gap+next.n > c.size {
+			break
+		}
+		n += m
+		i--
+	}
+	tail := append([]span(nil), prev[i:]...)
+	if len(tail) > 0 {
+		tail[0].hard = false
+	}
+	return tail, n
+}
+
+// trimSpan shrinks [start, end) past surrounding whitespace and reports
+// whether anything is left.
+func trimSpan(text string, start, end int) (span, bool) {
+	for start < end {
+		r, n := utf8.DecodeRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		start += n
+	}
+	for end > start {
+		r, n := utf8.DecodeLastRuneInString(text[start:end])
+		if !unicode.IsSpace(r) {
+			break
+		}
+		end -= n
+	}
+	return span{start: start, end: end}, start < end
+}
+
+// lines calls fn with the bounds of each line in text[start:end], excluding
+// the newline.
+func lines(text string, start, end int, fn func(lineStart, lineEnd int)) {
+	for start < end {
+		nl := strings.IndexByte(text[start:end], '\n')
+		if nl < 0 {
+			fn(start, end)
+			return
+		}
+		fn(start, start+nl)
+		start += nl + 1
+	}
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// paragraphSpans splits text[start:end] at blank lines.
+func paragraphSpans(text string, start, end int) []span {
+	var out []span
+	para := -1
+	last := start
+	lines(text, start, end, func(ls, le int) {
+		if isBlank(text[ls:le]) {
+			if para >= 0 {
+				if s, ok := trimSpan(text, para, last); ok {
+					out = append(out, s)
+				}
+				para = -1
+			}
+			return
+		}
+		if para < 0 {
+			para = ls
+		}
+		last = le
+	})
+	if para >= 0 {
+		if s, ok := trimSpan(text, para, last); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// lineSpans splits text[start:end] into non-blank lines.
+func lineSpans(text string, start, end int) []span {
+	var out []span
+	lines(text, start, end, func(ls, le int) {
+		if s, ok := trimSpan(text, ls, le); ok {
+			out = append(out, s)
+		}
+	})
+	return out
+}
+
+// wordSpans splits text[start:end] at whitespace.
+func wordSpans(text string, start, end int) []span {
+	var out []span
+	word := -1
+	for i := start; i < end; {
+		r, n := utf8.DecodeRuneInString(text[i:end])
+		if unicode.IsSpace(r) {
+			if word >= 0 {
+				out = append(out, span{start: word, end: i})
+				word = -1
+			}
+		} else if word < 0 {
+			word = i
+		}
+		i += n
+	}
+	if word >= 0 {
+		out = append(out, span{start: word, end: end})
+	}
+	return out
+}
+
+// sentenceSpans splits text[start:end] after sentence-ending punctuation.
+// A period only ends a sentence when the next word does not start with a
+// lowercase letter, so abbreviations such as "e.g." stay in place.
+func sentenceSpans(text string, start, end int) []span {
+	var out []span
+	from := start
+	emit := func(to int) {
+		if s, ok := trimSpan(text, from, to); ok {
+			out = append(out, s)
+		}
+		from = to
+	}
+	for i := start; i < end; {
+		r, n := utf8.DecodeRuneInString(text[i:end])
+		i += n
+		switch r {
+		case '。', '！', '？':
+			i = skipClosers(text, i, end)
+			emit(i)
+		case '.', '!', '?':
+			j := skipClosers(text, i, end)
+			if j == end {
+				i = j
+				continue
+			}
+			next, _ := utf8.DecodeRuneInString(text[j:end])
+			if !unicode.IsSpace(next) {
+				continue
+			}
+			k := j
+			for k < end {
+				r, n := utf8.DecodeRuneInString(text[k:end])
+				if !unicode.IsSpace(r) {
+					break
+				}
+				k += n
+			}
+			if k < end {
+				if r, _ := utf8.DecodeRuneInString(text[k:end]); unicode.IsLower(r) {
+					continue
+				}
+			}
+			i = j
+			emit(i)
+		}
+	}
+	emit(end)
+	return out
+}
+
+// skipClosers advances past closing quotes and brackets that follow
+// sentence-ending punctuation.
+func skipClosers(text string, i, end int) int {
+	for i < end {
+		r, n := utf8.DecodeRuneInString(text[i:end])
+		if !strings.ContainsRune(`"')]”’»`, r) {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// markdownSpans splits Markdown into headings, fenced code blocks and
+// paragraphs. Heading spans are hard and every span carries the text of
+// the nearest preceding heading.
+func markdownSpans(text string) []span {
+	var out []span
+	heading := ""
+	para, last := -1, 0
+	fence, fenceStart := "", 0
+
+	endPara := func() {
+		if para >= 0 {
+			if s, ok := trimSpan(text, para, last); ok {
+				s.heading = heading
+				out = append(out, s)
+			}
+			para = -1
+		}
+	}
+
+	lines(text, 0, len(text), func(ls, le int) {
+		line := text[ls:le]
+		trimmed := strings.TrimSpace(line)
+		if fence != "" {
+			if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+				if s, ok := trimSpan(text, fenceStart, le); ok {
+					s.heading = heading
+					out = append(out, s)
+				}
+				fence = ""
+			}
+			return
+		}
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			endPara()
+			fence = trimmed[:3]
+			fenceStart = ls
+		case isHeading(trimmed):
+			endPara()
+			heading = strings.TrimSpace(strings.TrimLeft(trimmed, "#"))
+			if s, ok := trimSpan(text, ls, le); ok {
+				s.hard = true
+				s.heading = heading
+				out = append(out, s)
+			}
+		case trimmed == "":
+			endPara()
+		default:
+			if para < 0 {
+				para = ls
+			}
+			last = le
+		}
+	})
+	if fence != "" {
+		// an unterminated fence runs to the end of the text
+		if s, ok := trimSpan(text, fenceStart, len(text)); ok {
+			s.heading = heading
+			out = append(out, s)
+		}
+	}
+	endPara()
+	return out
+}
+
+// isHeading reports whether a trimmed line is an ATX heading such as
+// "## Usage".
+func isHeading(line string) bool {
+	n := 0
+	for n < len(line) && line[n] == '#' {
+		n++
+	}
+	return n >= 1 && n <= 6 && (n == len(line) || line[n] == ' ' || line[n] == '\t')
+}
+
+// codeSpans splits source code into blocks that start at an unindented
+// line following a blank line, so functions and type declarations stay
+// together even when they contain blank lines.
+func codeSpans(text string) []span {
+	var out []span
+	block, last := -1, 0
+	blank := false
+	lines(text, 0, len(text), func(ls, le int) {
+		line := text[ls:le]
+		if isBlank(line) {
+			blank = true
+			return
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+		if block >= 0 && blank && !indented && !strings.HasPrefix(line, "}") && !strings.HasPrefix(line, ")") {
+			if s, ok := trimSpan(text, block, last); ok {
+				out = append(out, s)
+			}
+			block = -1
+		}
+		if block < 0 {
+			block = ls
+		}
+		last = le
+		blank = false
+	})
+	if block >= 0 {
+		if s, ok := trimSpan(text, block, last); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,346 @@
+package kjarni
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// checkChunks verifies the invariants every Split result must satisfy.
+func checkChunks(t *testing.T, name string, c *Chunker, text string, chunks []Chunk) {
+	t.Helper()
+	covered := make([]bool, len(text))
+	for i, ch := range chunks {
+		if ch.Index != i {
+			t.Errorf("%s: chunk %d has index %d", name, i, ch.Index)
+		}
+		if ch.Start < 0 || ch.End > len(text) || ch.Start >= ch.End || ch.Text != text[ch.Start:ch.End] {
+			t.Fatalf("%s: chunk %d [%d:%d] %q does not match the text", name, i, ch.Start, ch.End, ch.Text)
+		}
+		if strings.TrimSpace(ch.Text) != ch.Text {
+			t.Errorf("%s: chunk %d %q has surrounding whitespace", name, i, ch.Text)
+		}
+		if n, _ := c.length(ch.Text); n > c.size && utf8.RuneCountInString(ch.Text) > 1 {
+			t.Errorf("%s: chunk %d is %d long, limit %d: %q", name, i, n, c.size, ch.Text)
+		}
+		if i > 0 {
+			prev := chunks[i-1]
+			if ch.Start <= prev.Start || ch.End <= prev.End {
+				t.Errorf("%s: chunk %d [%d:%d] does not follow [%d:%d]", name, i, ch.Start, ch.End, prev.Start, prev.End)
+			}
+			if ch.Start < prev.End {
+				if n, _ := c.length(text[ch.Start:prev.End]); n > c.overlap {
+					t.Errorf("%s: chunk %d overlaps the previous one by %q", name, i, text[ch.Start:prev.End])
+				}
+			}
+		}
+		for j := ch.Start; j < ch.End; j++ {
+			covered[j] = true
+		}
+	}
+	for i, r := range text {
+		if !covered[i] && !unicode.IsSpace(r) {
+			t.Errorf("%s: %q at byte %d is in no chunk", name, r, i)
+			return
+		}
+	}
+}
+
+func newTestChunker(t *testing.T, strategy ChunkStrategy, size, overlap int, opts ...Option) *Chunker {
+	t.Helper()
+	opts = append([]Option{WithChunkSize(size), WithChunkOverlap(overlap)}, opts...)
+	c, err := NewChunker(strategy, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// split splits text and fails the test on an error.
+func split(t *testing.T, c *Chunker, text string) []Chunk {
+	t.Helper()
+	chunks, err := c.Split(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+func chunkTexts(chunks []Chunk) []string {
+	out := make([]string, len(chunks))
+	for i, ch := range chunks {
+		out[i] = ch.Text
+	}
+	return out
+}
+
+func TestNewChunkerConfig(t *testing.T) {
+	tests := []struct {
+		strategy ChunkStrategy
+		opts     []Option
+		ok       bool
+	}{
+		{ChunkSentences, nil, true},
+		{ChunkCode, []Option{WithChunkSize(10), WithChunkOverlap(0)}, true},
+		{ChunkStrategy(-1), nil, false},
+		{ChunkStrategy(4), nil, false},
+		{ChunkSentences, []Option{WithChunkSize(0)}, false},
+		{ChunkSentences, []Option{WithChunkOverlap(-1)}, false},
+		{ChunkSentences, []Option{WithChunkSize(10), WithChunkOverlap(10)}, false},
+	}
+	for i, tt := range tests {
+		_, err := NewChunker(tt.strategy, tt.opts...)
+		if tt.ok != (err == nil) {
+			t.Errorf("case %d: error %v", i, err)
+		}
+		if err != nil && errorCode(err) != ErrInvalidConfig {
+			t.Errorf("case %d: got %v, want ErrInvalidConfig", i, err)
+		}
+	}
+}
+
+func TestChunkerSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy ChunkStrategy
+		size     int
+		overlap  int
+		text     string
+		want     []string
+	}{
+		{
+			name:     "whitespace only",
+			strategy: ChunkSentences,
+			size:     10,
+			text:     " \n\t\n ",
+			want:     []string{},
+		},
+		{
+			name:     "sentences packed",
+			strategy: ChunkSentences,
+			size:     30,
+			text:     "One two. Three four! Five six? Seven eight.",
+			want:     []string{"One two. Three four! Five six?", "Seven eight."},
+		},
+		{
+			name:     "abbreviation stays in its sentence",
+			strategy: ChunkSentences,
+			size:     25,
+			text:     "Use a model, e.g. minilm. It is small.",
+			want:     []string{"Use a model, e.g. minilm.", "It is small."},
+		},
+		{
+			name:     "closing quote stays with its sentence",
+			strategy: ChunkSentences,
+			size:     12,
+			text:     `"Hi there." Bye now.`,
+			want:     []string{`"Hi there."`, "Bye now."},
+		},
+		{
+			name:     "CJK sentences",
+			strategy: ChunkSentences,
+			size:     4,
+			text:     "你好。再见！",
+			want:     []string{"你好。", "再见！"},
+		},
+		{
+			name:     "sentence overlap",
+			strategy: ChunkSentences,
+			size:     20,
+			overlap:  8,
+			text:     "Aaa aa. Bbb. Ccc cc. Ddd dd.",
+			want:     []string{"Aaa aa. Bbb. Ccc cc.", "Ccc cc. Ddd dd."},
+		},
+		{
+			name:     "long word split by character",
+			strategy: ChunkSentences,
+			size:     4,
+			text:     "abcdefghij",
+			want:     []string{"abcd", "efgh", "ij"},
+		},
+		{
+			name:     "paragraphs",
+			strategy: ChunkParagraphs,
+			size:     24,
+			text:     "First para.\n\nSecond para.\n  \nThird.",
+			want:     []string{"First para.", "Second para.\n  \nThird."},
+		},
+		{
+			name:     "long paragraph split by sentence",
+			strategy: ChunkParagraphs,
+			size:     12,
+			text:     "Short one.\n\nA long one. It goes on.",
+			want:     []string{"Short one.", "A long one.", "It goes on."},
+		},
+		{
+			name:     "code blocks",
+			strategy: ChunkCode,
+			size:     40,
+			text:     "func a() {\n\treturn\n\n}\n\nfunc b() {\n}\n\nvar c = 1\n",
+			want:     []string{"func a() {\n\treturn\n\n}\n\nfunc b() {\n}", "var c = 1"},
+		},
+		{
+			name:     "code block split by line",
+			strategy: ChunkCode,
+			size:     12,
+			text:     "func a() {\n\tx := 1\n}",
+			want:     []string{"func a() {", "x := 1\n}"},
+		},
+	}
+	for _, tt := range tests {
+		c := newTestChunker(t, tt.strategy, tt.size, tt.overlap)
+		chunks := split(t, c, tt.text)
+		checkChunks(t, tt.name, c, tt.text, chunks)
+		if got := chunkTexts(chunks); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChunkerMarkdown(t *testing.T) {
+	text := "Intro text.\n\n# Install\n\nRun it.\n\n```sh\ngo get x\n\ngo build\n```\n\n## Usage\nCall Split.\n"
+	c := newTestChunker(t, ChunkMarkdown, 60, 0)
+	chunks := split(t, c, text)
+	checkChunks(t, "markdown", c, text, chunks)
+
+	type hc struct{ Heading, Text string }
+	var got []hc
+	for _, ch := range chunks {
+		got = append(got, hc{ch.Heading, ch.Text})
+	}
+	want := []hc{
+		{"", "Intro text."},
+		{"Install", "# Install\n\nRun it.\n\n```sh\ngo get x\n\ngo build\n```"},
+		{"Usage", "## Usage\nCall Split."},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q\nwant %q", got, want)
+	}
+
+	// a fence too large for one chunk is split by line, keeping its heading
+	c = newTestChunker(t, ChunkMarkdown, 12, 0)
+	chunks = split(t, c, text)
+	checkChunks(t, "small markdown", c, text, chunks)
+	for _, ch := range chunks {
+		if strings.Contains(ch.Text, "go build") && ch.Heading != "Install" {
+			t.Errorf("chunk %q has heading %q", ch.Text, ch.Heading)
+		}
+	}
+
+	// an unterminated fence runs to the end
+	text = "# A\n```\ncode\n\nmore"
+	chunks = split(t, newTestChunker(t, ChunkMarkdown, 100, 0), text)
+	if got := chunkTexts(chunks); !reflect.DeepEqual(got, []string{text}) {
+		t.Errorf("unterminated fence: got %q", got)
+	}
+}
+
+func TestChunkerLengthFunc(t *testing.T) {
+	words := func(s string) (int, error) { return len(strings.Fields(s)), nil }
+	c := newTestChunker(t, ChunkSentences, 3, 0, WithLengthFunc(words))
+	text := "a b c. d e. f g h i."
+	chunks := split(t, c, text)
+	checkChunks(t, "words", c, text, chunks)
+	// the last sentence is too long, so its words fill the previous chunk
+	want := []string{"a b c.", "d e. f", "g h i."}
+	if got := chunkTexts(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// a single character over the limit becomes a chunk of its own
+	big := func(s string) (int, error) { return 10 * utf8.RuneCountInString(s), nil }
+	c = newTestChunker(t, ChunkSentences, 5, 0, WithLengthFunc(big))
+	chunks = split(t, c, "ab")
+	checkChunks(t, "oversized rune", c, "ab", chunks)
+	if got := chunkTexts(chunks); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("oversized rune: got %q", got)
+	}
+}
+
+func TestChunkerSpecialTokens(t *testing.T) {
+	// every text, even an empty one, counts two special tokens
+	tokens := func(s string) (int, error) { return len(strings.Fields(s)) + 2, nil }
+	c := newTestChunker(t, ChunkSentences, 5, 0, WithLengthFunc(tokens))
+	text := "a b c. d e. f."
+	chunks := split(t, c, text)
+	checkChunks(t, "special tokens", c, text, chunks)
+	want := []string{"a b c.", "d e. f."}
+	if got := chunkTexts(chunks); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	if _, err := NewChunker(ChunkSentences, WithChunkSize(2), WithChunkOverlap(0), WithLengthFunc(tokens)); errorCode(err) != ErrInvalidConfig {
+		t.Errorf("size within the special tokens: got %v", err)
+	}
+}
+
+func TestChunkerLengthError(t *testing.T) {
+	errLength := errors.New("tokenizer failed")
+	failOn := func(bad string) func(string) (int, error) {
+		return func(s string) (int, error) {
+			if s == bad {
+				return 0, errLength
+			}
+			return utf8.RuneCountInString(s), nil
+		}
+	}
+	if _, err := NewChunker(ChunkSentences, WithLengthFunc(failOn(""))); !errors.Is(err, errLength) {
+		t.Errorf("NewChunker: got %v", err)
+	}
+
+	// a sentence, a character of a long word and a gap between sentences
+	for _, bad := range []string{"One.", "x", " "} {
+		c := newTestChunker(t, ChunkSentences, 4, 0, WithLengthFunc(failOn(bad)))
+		if chunks, err := c.Split("One. Two. xxxxxx"); !errors.Is(err, errLength) || chunks != nil {
+			t.Errorf("failing on %q: got %q, %v", bad, chunkTexts(chunks), err)
+		}
+	}
+}
+
+func TestChunkerLengthCalls(t *testing.T) {
+	calls := 0
+	count := func(s string) (int, error) {
+		calls++
+		return utf8.RuneCountInString(s), nil
+	}
+	c := newTestChunker(t, ChunkSentences, 40, 10, WithLengthFunc(count))
+	text := strings.Repeat("ab ", 5000) + strings.Repeat("x", 5000)
+	calls = 0
+	split(t, c, text)
+	// the sentence, each word, each character of the long word and the
+	// gap before each of the 5000 short words and 125 pieces of the long
+	// one are measured once
+	if max := 1 + 5001 + 5000 + 5000 + 125; calls > max {
+		t.Errorf("%d calls to the length function, want at most %d", calls, max)
+	}
+}
+
+// TestChunkerInvariants splits generated texts with every strategy and a
+// range of sizes.
+func TestChunkerInvariants(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	pieces := []string{
+		"word", "Sentence.", "e.g.", "end!", "why?", "ünïcödé", "日本語。", "\"quoted.\"",
+		" ", " ", " ", "\n", "\n\n", "\t", "# Heading\n", "\n```\ncode\n```\n", "  indented\n",
+		"averyveryverylongwordwithoutanyspaces", "}\n",
+	}
+	for n := 0; n < 200; n++ {
+		var b strings.Builder
+		for i := rng.Intn(60); i > 0; i-- {
+			b.WriteString(pieces[rng.Intn(len(pieces))])
+		}
+		text := b.String()
+		for _, strategy := range []ChunkStrategy{ChunkSentences, ChunkParagraphs, ChunkMarkdown, ChunkCode} {
+			size := 1 + rng.Intn(80)
+			overlap := rng.Intn(size)
+			c := newTestChunker(t, strategy, size, overlap)
+			name := fmt.Sprintf("strategy %d size %d overlap %d text %q", strategy, size, overlap, text)
+			checkChunks(t, name, c, text, split(t, c, text))
+		}
+	}
+}
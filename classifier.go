@@ -1,11 +1,12 @@
 package kjarni
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math"
-	"strings"
-	"sync"
+	"sort"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -13,10 +14,13 @@ import (
 
 // ClassifyResult holds the output of a classification. Label and Score
 // contain the top prediction. AllScores contains scores for every label.
+// In multi-label mode Labels contains every label at or above the threshold,
+// sorted by descending score.
 type ClassifyResult struct {
 	Label     string
 	Score     float32
 	AllScores []LabelScore
+	Labels    []LabelScore
 }
 
 // LabelScore is a single label with its confidence score.
@@ -30,29 +34,28 @@ func (r *ClassifyResult) String() string {
 	return fmt.Sprintf("%s (%.1f%%)", r.Label, r.Score*100)
 }
 
-// ToJSON returns the result as a JSON string.
+// ToJSON returns the result as indented JSON.
+//
+// Deprecated: Use json.Marshal or json.MarshalIndent, which produce the same
+// field names.
 func (r *ClassifyResult) ToJSON() string {
-	var sb strings.Builder
-	sb.WriteString("{\n")
-	sb.WriteString(fmt.Sprintf("  \"label\": \"%s\",\n", r.Label))
-	sb.WriteString(fmt.Sprintf("  \"score\": %.4f,\n", r.Score))
-	sb.WriteString("  \"predictions\": [\n")
-	for i, s := range r.AllScores {
-		comma := ","
-		if i == len(r.AllScores)-1 {
-			comma = ""
-		}
-		sb.WriteString(fmt.Sprintf("    {\"label\": \"%s\", \"score\": %.4f}%s\n", s.Label, s.Score, comma))
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return "{}"
 	}
-	sb.WriteString("  ]\n}")
-	return sb.String()
+	return string(b)
 }
 
 // Classifier runs text classification using a pre-trained model.
 type Classifier struct {
-	handle uintptr
-	mu     sync.Mutex
-	closed bool
+	handle     uintptr
+	mu         handleLock
+	closed     bool
+	multiLabel bool
+	threshold  float32
+	batchSize  int
+	strict     bool // reject inputs over maxTokens instead of truncating
+	maxTokens  int
 }
 
 // NewClassifier creates a classifier for the given model.
@@ -67,13 +70,30 @@ func NewClassifier(model string, opts ...Option) (*Classifier, error) {
 	}
 
 	o := applyOptions(opts)
+	if err := o.validateClassifier(); err != nil {
+		return nil, err
+	}
+	if !o.truncate && _classifierTokenizeSym == 0 {
+		return nil, unsupported("kjarni_classifier_tokenize")
+	}
 
 	modelStr, keepModel := cString(model)
 	defer keepModel()
+	cacheDirPtr, keepCacheDir := optionalCString(o.cacheDir)
+	defer keepCacheDir()
+	modelPathPtr, keepModelPath := optionalCString(o.modelPath)
+	defer keepModelPath()
+	labelsPtr, keepLabels := cStringArray(o.labels)
+	defer keepLabels()
 
 	var config ffiClassifierConfig
 	config.Device = deviceCode(o.device)
+	config.CacheDir = cacheDirPtr
 	config.ModelName = modelStr
+	config.ModelPath = modelPathPtr
+	config.Labels = labelsPtr
+	config.NumLabels = uintptr(len(o.labels))
+	config.MultiLabel = boolToInt(o.multiLabel)
 	config.Quiet = boolToInt(o.quiet)
 
 	var handle uintptr
@@ -88,18 +108,46 @@ func NewClassifier(model string, opts ...Option) (*Classifier, error) {
 		return nil, lastError(code)
 	}
 
-	return &Classifier{handle: handle}, nil
+	c := &Classifier{
+		handle:     handle,
+		multiLabel: o.multiLabel,
+		threshold:  o.labelThreshold,
+		batchSize:  o.batchSize,
+		strict:     !o.truncate,
+		maxTokens:  maxLengthWith(_classifierMaxLengthSym, handle),
+	}
+	if err := checkMaxTokens(c.strict, c.maxTokens, "kjarni_classifier_max_length"); err != nil {
+		_classifierFree(handle)
+		return nil, err
+	}
+	return c, nil
 }
 
 // Classify runs the model on the given text and returns scored labels.
 func (c *Classifier) Classify(text string) (*ClassifyResult, error) {
-	c.mu.Lock()
+	return c.ClassifyContext(context.Background(), text)
+}
+
+// ClassifyContext is like Classify but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the classifier.
+func (c *Classifier) ClassifyContext(ctx context.Context, text string) (*ClassifyResult, error) {
+	if err := c.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer c.mu.Unlock()
 
 	if c.closed {
 		return nil, errors.New("classifier is closed")
 	}
+	if err := c.checkLength(ctx, 0, text); err != nil {
+		return nil, err
+	}
+
+	return c.classify(text)
+}
 
+// classify makes a single engine call. The caller must hold c.mu.
+func (c *Classifier) classify(text string) (*ClassifyResult, error) {
 	textPtr, keepText := cString(text)
 	defer keepText()
 
@@ -117,7 +165,57 @@ func (c *Classifier) Classify(text string) (*ClassifyResult, error) {
 	}
 
 	defer freeClassResults(results)
-	return parseClassResults(results), nil
+	return c.finishResult(parseClassResults(results)), nil
+}
+
+// ClassifyBatch classifies multiple texts and returns one result per text,
+// in input order. Each text is a separate engine call.
+func (c *Classifier) ClassifyBatch(texts []string) ([]*ClassifyResult, error) {
+	return c.ClassifyBatchContext(context.Background(), texts)
+}
+
+// ClassifyBatchContext is like ClassifyBatch but checks ctx between batches
+// of the size set with WithBatchSize, returning ErrCancelled or ErrTimeout without partial results.
+func (c *Classifier) ClassifyBatchContext(ctx context.Context, texts []string) ([]*ClassifyResult, error) {
+	if err := c.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errors.New("classifier is closed")
+	}
+
+	out := make([]*ClassifyResult, 0, len(texts))
+	err := forEachBatch(ctx, len(texts), c.batchSize, func(start, end int) error {
+		if err := c.checkLength(ctx, start, texts[start:end]...); err != nil {
+			return err
+		}
+		batch, err := c.classifyBatch(texts[start:end])
+		if err != nil {
+			return err
+		}
+		out = append(out, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// classifyBatch classifies texts one engine call at a time. The caller must
+// hold c.mu.
+func (c *Classifier) classifyBatch(texts []string) ([]*ClassifyResult, error) {
+	out := make([]*ClassifyResult, len(texts))
+	for i, t := range texts {
+		r, err := c.classify(t)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = r
+	}
+	return out, nil
 }
 
 // NumLabels returns the number of labels the model supports.
@@ -127,6 +225,62 @@ func (c *Classifier) NumLabels() int {
 	return int(_classifierNumLabels(c.handle))
 }
 
+// Tokenize returns the tokens the model sees for text, including special
+// tokens, with byte offsets into text. It does not truncate.
+func (c *Classifier) Tokenize(text string) ([]Token, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil, errors.New("classifier is closed")
+	}
+	return tokenizeWith(_classifierTokenizeSym, "kjarni_classifier_tokenize", c.handle, text)
+}
+
+// CountTokens returns the number of tokens in text, including special
+// tokens. Inputs with more than MaxTokens tokens are truncated by Classify.
+func (c *Classifier) CountTokens(text string) (int, error) {
+	tokens, err := c.Tokenize(text)
+	return len(tokens), err
+}
+
+// Truncate returns the longest prefix of text, ending at a token boundary,
+// that fits in maxTokens tokens including special tokens. A maxTokens of
+// zero uses MaxTokens. It fails with ErrInvalidConfig if maxTokens is less
+// than the number of special tokens.
+func (c *Classifier) Truncate(text string, maxTokens int) (string, error) {
+	if maxTokens <= 0 {
+		maxTokens = c.maxTokens
+	}
+	if maxTokens <= 0 {
+		return "", invalidConfig("model maximum length is unknown, pass maxTokens")
+	}
+	tokens, err := c.Tokenize(text)
+	if err != nil {
+		return "", err
+	}
+	return truncateTokens(text, tokens, maxTokens)
+}
+
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (c *Classifier) MaxTokens() int {
+	return c.maxTokens
+}
+
+// checkLength returns an *InputTooLongError for the first text over the
+// limit when truncation is off, numbering texts from offset. It checks ctx
+// before each text. The caller must hold c.mu.
+func (c *Classifier) checkLength(ctx context.Context, offset int, texts ...string) error {
+	if !c.strict {
+		return nil
+	}
+	return firstTooLong(ctx, offset, len(texts), c.maxTokens, func(i int) (int, error) {
+		tokens, err := tokenizeWith(_classifierTokenizeSym, "kjarni_classifier_tokenize", c.handle, texts[i])
+		return len(tokens), err
+	})
+}
+
 // Close releases the classifier resources. Safe to call multiple times.
 func (c *Classifier) Close() error {
 	c.mu.Lock()
@@ -141,6 +295,23 @@ func (c *Classifier) Close() error {
 	return nil
 }
 
+// finishResult fills in Labels when the classifier is in multi-label mode.
+func (c *Classifier) finishResult(r *ClassifyResult) *ClassifyResult {
+	if !c.multiLabel {
+		return r
+	}
+	r.Labels = []LabelScore{}
+	for _, s := range r.AllScores {
+		if s.Score >= c.threshold {
+			r.Labels = append(r.Labels, s)
+		}
+	}
+	sort.SliceStable(r.Labels, func(i, j int) bool {
+		return r.Labels[i].Score > r.Labels[j].Score
+	})
+	return r
+}
+
 func parseClassResults(results ffiClassResults) *ClassifyResult {
 	count := int(results.Len)
 	if count == 0 {
@@ -0,0 +1,39 @@
+package kjarni
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFinishResult(t *testing.T) {
+	scores := []LabelScore{
+		{"toxic", 0.7},
+		{"insult", 0.2},
+		{"obscene", 0.9},
+		{"threat", 0.5},
+		{"hate", 0.7},
+	}
+	tests := []struct {
+		name       string
+		multiLabel bool
+		threshold  float32
+		want       []LabelScore
+	}{
+		{"single label", false, 0.5, nil},
+		// ties keep the model's label order
+		{"threshold inclusive", true, 0.5, []LabelScore{{"obscene", 0.9}, {"toxic", 0.7}, {"hate", 0.7}, {"threat", 0.5}}},
+		{"none above threshold", true, 0.95, []LabelScore{}},
+		{"zero threshold", true, 0, []LabelScore{{"obscene", 0.9}, {"toxic", 0.7}, {"hate", 0.7}, {"threat", 0.5}, {"insult", 0.2}}},
+	}
+	for _, tt := range tests {
+		c := &Classifier{multiLabel: tt.multiLabel, threshold: tt.threshold}
+		all := append([]LabelScore(nil), scores...)
+		r := c.finishResult(&ClassifyResult{Label: "obscene", Score: 0.9, AllScores: all})
+		if !reflect.DeepEqual(r.Labels, tt.want) {
+			t.Errorf("%s: labels %v, want %v", tt.name, r.Labels, tt.want)
+		}
+		if !reflect.DeepEqual(r.AllScores, scores) || r.Label != "obscene" || r.Score != 0.9 {
+			t.Errorf("%s: result changed: %+v", tt.name, r)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Command kjarni-server serves kjarni models over HTTP.
+//
+//	kjarni-server -addr :8080 \
+//	    -classifier roberta-sentiment \
+//	    -embedder minilm-l6-v2 -alias text-embedding-3-small=minilm-l6-v2 \
+//	    -reranker default \
+//	    -searcher minilm-l6-v2 -index docs=/var/lib/kjarni/docs \
+//	    -workers 4
+//
+// Each model flag may be repeated. With -workers N each model is loaded N
+// times so that N requests per model run in parallel. Single-text embedding
+// requests arriving within -batch-delay of each other are encoded in one
+// engine call. See package server for the endpoints.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+	"github.com/olafurjohannsson/kjarni-go/server"
+)
+
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+func main() {
+	var classifiers, embedders, aliases, rerankers, indexes stringList
+	addr := flag.String("addr", ":8080", "listen address")
+	flag.Var(&classifiers, "classifier", "classifier `model` to serve (repeatable)")
+	flag.Var(&embedders, "embedder", "embedder `model` to serve (repeatable)")
+	flag.Var(&aliases, "alias", "serve an embedder under another name as `alias=model`, e.g. text-embedding-3-small=minilm-l6-v2 (repeatable)")
+	flag.Var(&rerankers, "reranker", "reranker `model` to serve, or \"default\" (repeatable)")
+	searcher := flag.String("searcher", "", "embedding `model` used to query indexes")
+	searchReranker := flag.String("search-reranker", "", "cross-encoder `model` used to rerank search results")
+	flag.Var(&indexes, "index", "index to serve as `name=path` (repeatable)")
+	device := flag.String("device", "cpu", "compute device: cpu or gpu")
+	cacheDir := flag.String("cache-dir", "", "model cache `directory` (default $KJARNI_CACHE_DIR)")
+	maxBatch := flag.Int("max-batch", 256, "maximum inputs per request")
+	workers := flag.Int("workers", 1, "handles per model; requests to the same model run in parallel up to this many")
+	exactUsage := flag.Bool("exact-usage", false, "count /v1/embeddings usage with the model tokenizer instead of estimating it; costs one engine call per input")
+	batchDelay := flag.Duration("batch-delay", 2*time.Millisecond, "how long a single-text embedding request waits for others to share its engine call; 0 disables batching")
+	flag.Parse()
+
+	opts := []kjarni.Option{kjarni.WithQuiet(true), kjarni.WithDevice(*device)}
+	if *cacheDir != "" {
+		opts = append(opts, kjarni.WithCacheDir(*cacheDir))
+	}
+
+	cfg := server.Config{
+		Classifiers:  map[string]server.Classifier{},
+		Embedders:    map[string]server.Embedder{},
+		Rerankers:    map[string]server.Reranker{},
+		Indexes:      map[string]string{},
+		MaxBatchSize: *maxBatch,
+		BatchDelay:   *batchDelay,
+		ExactUsage:   *exactUsage,
+	}
+	// on a load failure, release whatever was already loaded
+	fail := func(format string, args ...any) {
+		closeAll(cfg)
+		log.Fatalf(format, args...)
+	}
+
+	for _, m := range classifiers {
+		c, err := newClassifier(m, *workers, opts)
+		if err != nil {
+			fail("loading classifier %s: %v", m, err)
+		}
+		cfg.Classifiers[m] = c
+	}
+	for _, m := range embedders {
+		e, err := newEmbedder(m, *workers, opts)
+		if err != nil {
+			fail("loading embedder %s: %v", m, err)
+		}
+		cfg.Embedders[m] = e
+	}
+	for _, spec := range aliases {
+		alias, model, ok := strings.Cut(spec, "=")
+		e, loaded := cfg.Embedders[model]
+		if !ok || alias == "" || !loaded {
+			fail("invalid -alias %q, expected alias=model for a loaded -embedder", spec)
+		}
+		cfg.Embedders[alias] = e
+	}
+	for _, m := range rerankers {
+		model := m
+		if m == "default" {
+			model = ""
+		}
+		r, err := newReranker(model, *workers, opts)
+		if err != nil {
+			fail("loading reranker %s: %v", m, err)
+		}
+		cfg.Rerankers[m] = r
+	}
+	for _, spec := range indexes {
+		name, path, ok := strings.Cut(spec, "=")
+		if !ok || name == "" || path == "" {
+			fail("invalid -index %q, expected name=path", spec)
+		}
+		cfg.Indexes[name] = path
+	}
+	if *searcher != "" {
+		s, err := newSearcher(*searcher, *searchReranker, *workers, opts)
+		if err != nil {
+			fail("loading searcher %s: %v", *searcher, err)
+		}
+		cfg.Searcher = s
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		closeAll(cfg)
+		fmt.Fprintf(os.Stderr, "error: %v\n\n", err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("listening on %s", *addr)
+	if err := srv.ListenAndServe(ctx, *addr); err != nil {
+		log.Fatal(err)
+	}
+	log.Print("shut down")
+}
+
+// The constructors below load a single handle for one worker and a pool
+// otherwise, so the default setup carries no pool overhead.
+
+func newClassifier(model string, workers int, opts []kjarni.Option) (server.Classifier, error) {
+	if workers == 1 {
+		return kjarni.NewClassifier(model, opts...)
+	}
+	return kjarni.NewClassifierPool(model, workers, opts...)
+}
+
+func newEmbedder(model string, workers int, opts []kjarni.Option) (server.Embedder, error) {
+	if workers == 1 {
+		return kjarni.NewEmbedder(model, opts...)
+	}
+	return kjarni.NewEmbedderPool(model, workers, opts...)
+}
+
+func newReranker(model string, workers int, opts []kjarni.Option) (server.Reranker, error) {
+	if workers == 1 {
+		return kjarni.NewRerankerModel(model, opts...)
+	}
+	return kjarni.NewRerankerPool(model, workers, opts...)
+}
+
+func newSearcher(model, reranker string, workers int, opts []kjarni.Option) (server.Searcher, error) {
+	if workers == 1 {
+		return kjarni.NewSearcher(model, reranker, opts...)
+	}
+	return kjarni.NewSearcherPool(model, reranker, workers, opts...)
+}
+
+func closeAll(cfg server.Config) {
+	for _, c := range cfg.Classifiers {
+		c.Close()
+	}
+	for _, e := range cfg.Embedders {
+		e.Close()
+	}
+	for _, r := range cfg.Rerankers {
+		r.Close()
+	}
+	if cfg.Searcher != nil {
+		cfg.Searcher.Close()
+	}
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+type classifyRecord struct {
+	Text        string              `json:"text"`
+	Label       string              `json:"label"`
+	Score       float32             `json:"score"`
+	Predictions []kjarni.LabelScore `json:"predictions"`
+	Labels      []kjarni.LabelScore `json:"labels,omitempty"`
+}
+
+func runClassify(args []string) error {
+	c := newCommon("classify", "distilbert-sentiment")
+	multiLabel := c.fs.Bool("multi-label", false, "score labels independently and report all above -threshold")
+	threshold := c.fs.Float64("threshold", 0.5, "minimum score for a label in multi-label mode")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	opts := append(c.options(), kjarni.WithMultiLabel(*multiLabel), kjarni.WithLabelThreshold(float32(*threshold)))
+	cl, err := kjarni.NewClassifier(*c.model, opts...)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	p := c.printer()
+	err = c.batches(func(_ []int, texts []string) error {
+		results, err := cl.ClassifyBatch(texts)
+		if err != nil {
+			return err
+		}
+		for i, r := range results {
+			record := classifyRecord{
+				Text:        texts[i],
+				Label:       r.Label,
+				Score:       r.Score,
+				Predictions: r.AllScores,
+				Labels:      r.Labels,
+			}
+			err := p.print(record, func(w io.Writer) {
+				if *multiLabel {
+					labels := make([]string, len(r.Labels))
+					for j, l := range r.Labels {
+						labels[j] = fmt.Sprintf("%s (%.1f%%)", l.Label, l.Score*100)
+					}
+					fmt.Fprintf(w, "%s\t%s\n", strings.Join(labels, ", "), texts[i])
+					return
+				}
+				fmt.Fprintf(w, "%s\t%s\n", r, texts[i])
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return p.flush()
+	})
+	return p.finish(err)
+}
+
+type embedRecord struct {
+	Text      string    `json:"text"`
+	Embedding []float32 `json:"embedding"`
+}
+
+func runEmbed(args []string) error {
+	c := newCommon("embed", "minilm-l6-v2")
+	normalize := c.fs.Bool("normalize", true, "scale vectors to unit length")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	e, err := kjarni.NewEmbedder(*c.model, append(c.options(), kjarni.WithNormalize(*normalize))...)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	p := c.printer()
+	err = c.batches(func(_ []int, texts []string) error {
+		vecs, err := e.EncodeBatch(texts)
+		if err != nil {
+			return err
+		}
+		for i, v := range vecs {
+			err := p.print(embedRecord{Text: texts[i], Embedding: v}, func(w io.Writer) {
+				for j, x := range v {
+					if j > 0 {
+						io.WriteString(w, " ")
+					}
+					fmt.Fprintf(w, "%g", x)
+				}
+				io.WriteString(w, "\n")
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return p.flush()
+	})
+	return p.finish(err)
+}
+
+type similarityRecord struct {
+	A          string  `json:"a"`
+	B          string  `json:"b"`
+	Similarity float32 `json:"similarity"`
+}
+
+// runSimilarity compares two argument texts, or each tab-separated pair of
+// texts read line by line.
+func runSimilarity(args []string) error {
+	c := newCommon("similarity", "minilm-l6-v2")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+
+	if c.fs.NArg() > 0 && c.fs.NArg() != 2 {
+		return errors.New("expected exactly two texts")
+	}
+
+	e, err := kjarni.NewEmbedder(*c.model, c.options()...)
+	if err != nil {
+		return err
+	}
+	defer e.Close()
+
+	p := c.printer()
+	compare := func(a, b string) error {
+		score, err := e.Similarity(a, b)
+		if err != nil {
+			return err
+		}
+		return p.print(similarityRecord{A: a, B: b, Similarity: score}, func(w io.Writer) {
+			fmt.Fprintf(w, "%.4f\t%s\t%s\n", score, a, b)
+		})
+	}
+
+	if c.fs.NArg() == 2 {
+		err = compare(c.fs.Arg(0), c.fs.Arg(1))
+	} else {
+		err = c.batches(func(lineNums []int, lines []string) error {
+			for i, line := range lines {
+				a, b, ok := strings.Cut(line, "\t")
+				if !ok {
+					return fmt.Errorf("line %d: expected two tab-separated texts", lineNums[i])
+				}
+				if err := compare(a, b); err != nil {
+					return err
+				}
+			}
+			return p.flush()
+		})
+	}
+	return p.finish(err)
+}
+
+func runRerank(args []string) error {
+	c := newCommon("rerank", "")
+	query := c.fs.String("query", "", "query to rank documents against (required)")
+	topK := c.fs.Int("top-k", 0, "only return the `k` best documents")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if *query == "" {
+		return errors.New("-query is required")
+	}
+	docs, err := c.texts()
+	if err != nil {
+		return err
+	}
+
+	r, err := kjarni.NewRerankerModel(*c.model, c.options()...)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var results []kjarni.RerankResult
+	if *topK > 0 {
+		results, err = r.RerankTopK(*query, docs, *topK)
+	} else {
+		results, err = r.Rerank(*query, docs)
+	}
+	if err != nil {
+		return err
+	}
+
+	p := c.printer()
+	for _, res := range results {
+		err := p.print(res, func(w io.Writer) {
+			fmt.Fprintf(w, "[%d] %7.2f  %s\n", res.Index, res.Score, res.Document)
+		})
+		if err != nil {
+			return p.finish(err)
+		}
+	}
+	return p.close()
+}
+
+// runIndex builds an index from input paths given as arguments or lines.
+func runIndex(args []string) error {
+	c := newCommon("index", "minilm-l6-v2")
+	indexPath := c.fs.String("index", "", "index `directory` (required)")
+	update := c.fs.Bool("update", false, "add new and changed files to an existing index")
+	remove := c.fs.Bool("remove", false, "remove the given source paths from an existing index")
+	force := c.fs.Bool("force", false, "rebuild the index if it already exists")
+	appendFiles := c.fs.Bool("append", false, "add the inputs to the index, creating it if it does not exist")
+	chunkSize := c.fs.Int("chunk-size", 512, "maximum chunk size in characters")
+	chunkOverlap := c.fs.Int("chunk-overlap", 50, "characters shared between consecutive chunks")
+	exts := c.fs.String("ext", "", "comma-separated file `extensions` to include")
+	exclude := c.fs.String("exclude", "", "comma-separated glob `patterns` to skip")
+	hidden := c.fs.Bool("hidden", false, "include hidden files")
+	maxSize := c.fs.Int64("max-file-size", 0, "skip files larger than `bytes` (0 for no limit)")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if *indexPath == "" {
+		return errors.New("-index is required")
+	}
+	if *update && *remove {
+		return errors.New("-update and -remove are mutually exclusive")
+	}
+	if (*update || *remove) && (*force || *appendFiles) {
+		return errors.New("-force and -append only apply when creating an index")
+	}
+	inputs, err := c.texts()
+	if err != nil {
+		return err
+	}
+
+	opts := append(c.options(),
+		kjarni.WithChunkSize(*chunkSize),
+		kjarni.WithChunkOverlap(*chunkOverlap),
+		kjarni.WithHidden(*hidden),
+		kjarni.WithMaxFileSize(*maxSize),
+	)
+	if *exts != "" {
+		opts = append(opts, kjarni.WithExtensions(strings.Split(*exts, ",")...))
+	}
+	if *exclude != "" {
+		opts = append(opts, kjarni.WithExcludePatterns(strings.Split(*exclude, ",")...))
+	}
+
+	idx, err := kjarni.NewIndexer(*c.model, opts...)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	var stats *kjarni.IndexStats
+	switch {
+	case *update:
+		stats, err = idx.Add(*indexPath, inputs)
+	case *remove:
+		stats, err = idx.Remove(*indexPath, inputs)
+	default:
+		stats, err = idx.CreateWithOptions(*indexPath, inputs, kjarni.CreateOptions{Force: *force, Append: *appendFiles})
+	}
+	if err != nil {
+		return err
+	}
+
+	p := c.printer()
+	err = p.print(stats, func(w io.Writer) {
+		fmt.Fprintf(w, "%d files processed, %d skipped, %d documents, %d chunks (%dd), %d bytes in %dms\n",
+			stats.FilesProcessed, stats.FilesSkipped, stats.DocumentsIndexed,
+			stats.ChunksCreated, stats.Dimension, stats.SizeBytes, stats.ElapsedMs)
+	})
+	return p.finish(err)
+}
+
+type searchRecord struct {
+	Query      string         `json:"query"`
+	Rank       int            `json:"rank"`
+	Score      float32        `json:"score"`
+	Text       string         `json:"text"`
+	DocumentID string         `json:"document_id,omitempty"`
+	Source     string         `json:"source,omitempty"`
+	Metadata   map[string]any `json:"metadata,omitempty"`
+}
+
+// runSearch runs each query given as an argument or line against an index.
+func runSearch(args []string) error {
+	c := newCommon("search", "minilm-l6-v2")
+	indexPath := c.fs.String("index", "", "index `directory` (required)")
+	reranker := c.fs.String("reranker", "", "cross-encoder `model` used to rerank results")
+	mode := c.fs.String("mode", "hybrid", "search mode: keyword, semantic or hybrid")
+	topK := c.fs.Int("top-k", 10, "maximum results per query")
+	threshold := c.fs.Float64("threshold", 0, "drop results scoring below this value")
+	source := c.fs.String("source", "", "only return results whose source matches this glob")
+	filter := c.fs.String("filter", "", "only return results whose metadata matches `key=value`")
+	if err := c.parse(args); err != nil {
+		return err
+	}
+	if *indexPath == "" {
+		return errors.New("-index is required")
+	}
+	var m kjarni.SearchMode
+	if err := m.UnmarshalText([]byte(*mode)); err != nil {
+		return err
+	}
+	opts := kjarni.SearchOptions{
+		Mode:          m,
+		TopK:          *topK,
+		Threshold:     float32(*threshold),
+		SourcePattern: *source,
+	}
+	if *filter != "" {
+		key, value, ok := strings.Cut(*filter, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid -filter %q, expected key=value", *filter)
+		}
+		opts.FilterKey, opts.FilterValue = key, value
+	}
+	s, err := kjarni.NewSearcher(*c.model, *reranker, c.options()...)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	// head each query's results unless there is a single query argument
+	headings := c.fs.NArg() != 1
+	p := c.printer()
+	err = c.batches(func(_ []int, queries []string) error {
+		for _, q := range queries {
+			results, err := s.SearchWithOptions(*indexPath, q, opts)
+			if err != nil {
+				return err
+			}
+			for i, r := range results {
+				record := searchRecord{
+					Query:      q,
+					Rank:       i + 1,
+					Score:      r.Score,
+					Text:       r.Text,
+					DocumentID: r.DocumentID,
+					Source:     r.Source(),
+					Metadata:   r.Metadata,
+				}
+				err := p.print(record, func(w io.Writer) {
+					if headings && record.Rank == 1 {
+						fmt.Fprintf(w, "# %s\n", q)
+					}
+					text := strings.Join(strings.Fields(record.Text), " ")
+					if record.Source != "" {
+						fmt.Fprintf(w, "%.4f  %s  %s\n", record.Score, record.Source, text)
+						return
+					}
+					fmt.Fprintf(w, "%.4f  %s\n", record.Score, text)
+				})
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return p.flush()
+	})
+	return p.finish(err)
+}
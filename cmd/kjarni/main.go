@@ -0,0 +1,271 @@
+// Command kjarni runs classification, embeddings, reranking, indexing and
+// search from the command line.
+//
+//	kjarni classify -model roberta-sentiment "I love this" "I hate this"
+//	kjarni embed -model minilm-l6-v2 -jsonl < titles.txt
+//	kjarni similarity "doctor" "physician"
+//	kjarni rerank -query "what is ml?" -file docs.txt -top-k 3
+//	kjarni index -index ./idx -ext md,go -exclude "vendor/**" ./repo
+//	kjarni search -index ./idx -mode semantic "how do returns work?"
+//
+// Texts are taken from the arguments, from -file, or from standard input,
+// one per line. Input is read and processed in batches of -batch lines, and
+// each batch's results are printed before the next is read, so long inputs
+// stream. Results are printed as text, or as JSON with -json (one array) or
+// -jsonl (one object per line).
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"classify":   {"classify text", runClassify},
+	"embed":      {"encode text into embedding vectors", runEmbed},
+	"similarity": {"cosine similarity between two texts", runSimilarity},
+	"rerank":     {"rank documents by relevance to a query", runRerank},
+	"index":      {"build or update a search index", runIndex},
+	"search":     {"query a search index", runSearch},
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "help" {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "kjarni: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "kjarni %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: kjarni <command> [flags] [text ...]\n\ncommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-11s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun 'kjarni <command> -h' for command flags\n")
+}
+
+// common holds the flags shared by every command.
+type common struct {
+	stdin    io.Reader
+	stdout   io.Writer
+	fs       *flag.FlagSet
+	model    *string
+	device   *string
+	cacheDir *string
+	verbose  *bool
+	file     *string
+	json     *bool
+	jsonl    *bool
+	batch    *int
+}
+
+func newCommon(name, defaultModel string) *common {
+	fs := flag.NewFlagSet("kjarni "+name, flag.ContinueOnError)
+	return &common{
+		stdin:    os.Stdin,
+		stdout:   os.Stdout,
+		fs:       fs,
+		model:    fs.String("model", defaultModel, "model `name`"),
+		device:   fs.String("device", "cpu", "compute device: cpu or gpu"),
+		cacheDir: fs.String("cache-dir", "", "model cache `directory` (default $KJARNI_CACHE_DIR)"),
+		verbose:  fs.Bool("v", false, "show engine log output"),
+		file:     fs.String("file", "", "read texts from `path`, one per line (- for stdin)"),
+		json:     fs.Bool("json", false, "print results as a JSON array"),
+		jsonl:    fs.Bool("jsonl", false, "print results as JSON lines"),
+		batch:    fs.Int("batch", 32, "process and print input `lines` in batches of this size"),
+	}
+}
+
+func (c *common) parse(args []string) error {
+	if err := c.fs.Parse(args); err != nil {
+		return err
+	}
+	if *c.json && *c.jsonl {
+		return fmt.Errorf("-json and -jsonl are mutually exclusive")
+	}
+	if *c.file != "" && c.fs.NArg() > 0 {
+		return fmt.Errorf("pass texts as arguments or with -file, not both")
+	}
+	if *c.batch < 1 {
+		return fmt.Errorf("-batch must be positive, got %d", *c.batch)
+	}
+	return nil
+}
+
+func (c *common) options() []kjarni.Option {
+	opts := []kjarni.Option{kjarni.WithQuiet(!*c.verbose), kjarni.WithDevice(*c.device)}
+	if *c.cacheDir != "" {
+		opts = append(opts, kjarni.WithCacheDir(*c.cacheDir))
+	}
+	return opts
+}
+
+// batches calls fn with successive batches of at most -batch texts: the
+// positional arguments, or the non-blank lines of -file or stdin as they are
+// read. lines holds the 1-based argument position or input line number of
+// each text, counting blank lines.
+func (c *common) batches(fn func(lines []int, texts []string) error) error {
+	if c.fs.NArg() > 0 {
+		args := c.fs.Args()
+		for start := 0; start < len(args); start += *c.batch {
+			end := min(start+*c.batch, len(args))
+			lines := make([]int, end-start)
+			for i := range lines {
+				lines[i] = start + i + 1
+			}
+			if err := fn(lines, args[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	r := c.stdin
+	if *c.file != "" && *c.file != "-" {
+		f, err := os.Open(*c.file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	n, texts := 0, 0
+	lines := make([]int, 0, *c.batch)
+	batch := make([]string, 0, *c.batch)
+	for sc.Scan() {
+		n++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		lines = append(lines, n)
+		batch = append(batch, line)
+		if len(batch) == *c.batch {
+			if err := fn(lines, batch); err != nil {
+				return err
+			}
+			texts += len(batch)
+			lines, batch = lines[:0], batch[:0]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if len(batch) > 0 {
+		if err := fn(lines, batch); err != nil {
+			return err
+		}
+		texts += len(batch)
+	}
+	if texts == 0 {
+		return fmt.Errorf("no input text")
+	}
+	return nil
+}
+
+// texts returns every input text, for commands that need them all at once.
+func (c *common) texts() ([]string, error) {
+	var all []string
+	err := c.batches(func(_ []int, texts []string) error {
+		all = append(all, texts...)
+		return nil
+	})
+	return all, err
+}
+
+// printer writes records as plain text, a JSON array or JSON lines,
+// flushing after each batch so output streams as input is read.
+type printer struct {
+	c *common
+	w *bufio.Writer
+	n int
+}
+
+func (c *common) printer() *printer {
+	return &printer{c: c, w: bufio.NewWriter(c.stdout)}
+}
+
+// print writes one record, calling text to format it in plain text mode.
+func (p *printer) print(record any, text func(w io.Writer)) error {
+	defer func() { p.n++ }()
+	switch {
+	case *p.c.json:
+		b, err := json.MarshalIndent(record, "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if p.n == 0 {
+			p.w.WriteString("[\n  ")
+		} else {
+			p.w.WriteString(",\n  ")
+		}
+		_, err = p.w.Write(b)
+		return err
+	case *p.c.jsonl:
+		return json.NewEncoder(p.w).Encode(record)
+	default:
+		text(p.w)
+		return nil
+	}
+}
+
+// flush writes out everything printed so far.
+func (p *printer) flush() error {
+	return p.w.Flush()
+}
+
+// finish closes the printer after a command ends with err, so that -json
+// output stays a complete array even when the command fails. It returns
+// err, or the error from closing if err is nil.
+func (p *printer) finish(err error) error {
+	if cerr := p.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// close ends the JSON array, if any, and flushes.
+func (p *printer) close() error {
+	if *p.c.json {
+		if p.n == 0 {
+			p.w.WriteString("[]\n")
+		} else {
+			p.w.WriteString("\n]\n")
+		}
+	}
+	return p.w.Flush()
+}
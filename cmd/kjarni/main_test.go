@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCommon(t *testing.T, stdin string, args ...string) (*common, *strings.Builder) {
+	t.Helper()
+	c := newCommon("test", "")
+	out := &strings.Builder{}
+	c.stdin = strings.NewReader(stdin)
+	c.stdout = out
+	if err := c.parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return c, out
+}
+
+func TestBatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		stdin     string
+		args      []string
+		want      [][]string
+		wantLines [][]int
+		err       bool
+	}{
+		{"args", "", []string{"-batch", "2", "a", "b", "c"}, [][]string{{"a", "b"}, {"c"}}, [][]int{{1, 2}, {3}}, false},
+		// blank lines are skipped but still counted
+		{"lines", "a\nb\r\n\nc\nd\n", []string{"-batch", "2"}, [][]string{{"a", "b"}, {"c", "d"}}, [][]int{{1, 2}, {4, 5}}, false},
+		{"leading blank lines", "\n\na\n\nb", []string{"-batch", "1"}, [][]string{{"a"}, {"b"}}, [][]int{{3}, {5}}, false},
+		{"partial last batch", "a\nb\nc", []string{"-batch", "2"}, [][]string{{"a", "b"}, {"c"}}, [][]int{{1, 2}, {3}}, false},
+		{"default batch", "a\nb\n", nil, [][]string{{"a", "b"}}, [][]int{{1, 2}}, false},
+		{"empty input", "\n\n", nil, nil, nil, true},
+	}
+	for _, tt := range tests {
+		c, _ := testCommon(t, tt.stdin, tt.args...)
+		var got [][]string
+		var gotLines [][]int
+		err := c.batches(func(lines []int, texts []string) error {
+			if len(lines) != len(texts) {
+				t.Errorf("%s: %d line numbers for %d texts", tt.name, len(lines), len(texts))
+			}
+			got = append(got, append([]string(nil), texts...))
+			gotLines = append(gotLines, append([]int(nil), lines...))
+			return nil
+		})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if !reflect.DeepEqual(gotLines, tt.wantLines) {
+			t.Errorf("%s: lines %v, want %v", tt.name, gotLines, tt.wantLines)
+		}
+	}
+}
+
+// TestBatchesStream checks that a batch is handed over before the rest of
+// the input has been read.
+func TestBatchesStream(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+	c := newCommon("test", "")
+	c.stdin = r
+	c.parse([]string{"-batch", "2"})
+
+	first := make(chan []string, 1)
+	go c.batches(func(lines []int, texts []string) error {
+		if lines[0] == 1 {
+			first <- append([]string(nil), texts...)
+		}
+		return nil
+	})
+	fmt.Fprint(w, "a\nb\n")
+	select {
+	case got := <-first:
+		if !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Errorf("first batch %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first batch not delivered while input is still open")
+	}
+}
+
+func TestPrinter(t *testing.T) {
+	type rec struct {
+		N int `json:"n"`
+	}
+	tests := []struct {
+		args []string
+		n    int
+		want string
+	}{
+		{nil, 2, "0\n1\n"},
+		{[]string{"-jsonl"}, 2, "{\"n\":0}\n{\"n\":1}\n"},
+		{[]string{"-json"}, 2, "[\n  {\n    \"n\": 0\n  },\n  {\n    \"n\": 1\n  }\n]\n"},
+		{[]string{"-json"}, 0, "[]\n"},
+	}
+	for _, tt := range tests {
+		c, out := testCommon(t, "", tt.args...)
+		p := c.printer()
+		for i := 0; i < tt.n; i++ {
+			p.print(rec{i}, func(w io.Writer) { fmt.Fprintln(w, i) })
+		}
+		if err := p.close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.args, out.String(), tt.want)
+		}
+		if strings.Contains(strings.Join(tt.args, ""), "json") && tt.n > 0 {
+			var v any
+			dec := json.NewDecoder(strings.NewReader(out.String()))
+			for dec.More() {
+				if err := dec.Decode(&v); err != nil {
+					t.Errorf("%v: invalid JSON output: %v", tt.args, err)
+				}
+			}
+		}
+	}
+}
+
+func TestPrinterFlushesPerBatch(t *testing.T) {
+	c, out := testCommon(t, "", "-jsonl")
+	p := c.printer()
+	p.print(map[string]int{"n": 1}, nil)
+	if out.Len() != 0 {
+		t.Fatal("output written before flush")
+	}
+	p.flush()
+	if out.String() != "{\"n\":1}\n" {
+		t.Errorf("after flush: %q", out.String())
+	}
+}
+
+func TestPrinterFinish(t *testing.T) {
+	errFailed := errors.New("failed")
+	tests := []struct {
+		n    int
+		err  error
+		want string
+	}{
+		{0, nil, "[]\n"},
+		{0, errFailed, "[]\n"},
+		{1, errFailed, "[\n  {\n    \"n\": 0\n  }\n]\n"},
+	}
+	for _, tt := range tests {
+		c, out := testCommon(t, "", "-json")
+		p := c.printer()
+		for i := 0; i < tt.n; i++ {
+			p.print(map[string]int{"n": i}, nil)
+		}
+		// output printed before a failure is still a complete array
+		if err := p.finish(tt.err); err != tt.err {
+			t.Errorf("%d records, error %v: finish returned %v", tt.n, tt.err, err)
+		}
+		var v []any
+		if out.String() != tt.want || json.Unmarshal([]byte(out.String()), &v) != nil {
+			t.Errorf("%d records, error %v: got %q, want %q", tt.n, tt.err, out.String(), tt.want)
+		}
+	}
+}
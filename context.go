@@ -0,0 +1,95 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// contextBatchSize is the number of items sent to the engine per call by the
+// Context variants of batch methods. Cancellation is checked between calls.
+const contextBatchSize = 32
+
+// handleLock serializes access to an engine handle. Unlike sync.Mutex,
+// waiting for it can be abandoned when a context is done.
+// The zero value is unlocked.
+type handleLock struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func (l *handleLock) sem() chan struct{} {
+	l.once.Do(func() { l.ch = make(chan struct{}, 1) })
+	return l.ch
+}
+
+// Lock blocks until the handle is free.
+func (l *handleLock) Lock() {
+	l.sem() <- struct{}{}
+}
+
+// LockContext blocks until the handle is free or ctx is done.
+func (l *handleLock) LockContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	select {
+	case l.sem() <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return contextError(ctx.Err())
+	}
+}
+
+// Unlock releases the handle.
+func (l *handleLock) Unlock() {
+	<-l.sem()
+}
+
+// checkContext returns a KjarniError if ctx is done.
+func checkContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return contextError(err)
+	}
+	return nil
+}
+
+// contextError converts a context error into a KjarniError with
+// ErrTimeout for expired deadlines and ErrCancelled otherwise.
+func contextError(err error) error {
+	code := ErrCancelled
+	if errors.Is(err, context.DeadlineExceeded) {
+		code = ErrTimeout
+	}
+	return &KjarniError{
+		Code:    code,
+		Message: err.Error(),
+	}
+}
+
+// splitBatches calls fn for consecutive sub-slices of at most size items,
+// checking ctx before each call. A context without a Done channel is never
+// cancelled, so the whole batch is passed in a single call.
+func splitBatches(ctx context.Context, n, size int, fn func(start, end int) error) error {
+	if ctx.Done() == nil {
+		return fn(0, n)
+	}
+	return forEachBatch(ctx, n, size, fn)
+}
+
+// forEachBatch is like splitBatches but always splits, regardless of ctx.
+func forEachBatch(ctx context.Context, n, size int, fn func(start, end int) error) error {
+	for start := 0; start < n; start += size {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		end := start + size
+		if end > n {
+			end = n
+		}
+		if err := fn(start, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func errorCode(err error) ErrorCode {
+	var ke *KjarniError
+	if !errors.As(err, &ke) {
+		return ErrOk
+	}
+	return ke.Code
+}
+
+func TestHandleLockContext(t *testing.T) {
+	var l handleLock
+	if err := l.LockContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// waiting for a held lock ends with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.LockContext(ctx); errorCode(err) != ErrTimeout {
+		t.Errorf("timeout: got %v", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if err := l.LockContext(ctx); errorCode(err) != ErrCancelled {
+		t.Errorf("cancel: got %v", err)
+	}
+
+	// a waiter gets the lock once it is released
+	done := make(chan error, 1)
+	go func() { done <- l.LockContext(context.Background()) }()
+	time.Sleep(10 * time.Millisecond)
+	l.Unlock()
+	if err := <-done; err != nil {
+		t.Errorf("after Unlock: %v", err)
+	}
+	l.Unlock()
+
+	// a done context fails even when the lock is free
+	if err := l.LockContext(ctx); errorCode(err) != ErrCancelled {
+		t.Errorf("done context on a free lock: got %v", err)
+	}
+	l.Lock()
+	l.Unlock()
+}
+
+func TestSplitBatches(t *testing.T) {
+	cancellable, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	tests := []struct {
+		name string
+		ctx  context.Context
+		n    int
+		size int
+		want [][2]int
+	}{
+		{"background is one call", context.Background(), 70, 32, [][2]int{{0, 70}}},
+		{"cancellable splits", cancellable, 70, 32, [][2]int{{0, 32}, {32, 64}, {64, 70}}},
+		{"exact multiple", cancellable, 64, 32, [][2]int{{0, 32}, {32, 64}}},
+		{"smaller than size", cancellable, 3, 32, [][2]int{{0, 3}}},
+		{"empty", cancellable, 0, 32, nil},
+	}
+	for _, tt := range tests {
+		var got [][2]int
+		err := splitBatches(tt.ctx, tt.n, tt.size, func(start, end int) error {
+			got = append(got, [2]int{start, end})
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestSplitBatchesStops(t *testing.T) {
+	// cancelling during a batch stops before the next one
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := splitBatches(ctx, 100, 10, func(start, end int) error {
+		calls++
+		if calls == 2 {
+			cancel()
+		}
+		return nil
+	})
+	if errorCode(err) != ErrCancelled || calls != 2 {
+		t.Errorf("got %v after %d calls", err, calls)
+	}
+
+	// an error from fn is returned as is
+	errBatch := errors.New("batch failed")
+	err = splitBatches(context.Background(), 10, 5, func(start, end int) error { return errBatch })
+	if err != errBatch {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestRerankBatches(t *testing.T) {
+	// document i scores scores[i]; each sub-batch reports indexes from 0
+	scores := make([]float32, 70)
+	for i := range scores {
+		scores[i] = float32((i * 37) % 11)
+	}
+	score := func(start, end int) ([]RerankResult, error) {
+		out := make([]RerankResult, 0, end-start)
+		for i := end - 1; i >= start; i-- {
+			out = append(out, RerankResult{Index: i - start, Score: scores[i]})
+		}
+		return out, nil
+	}
+	want := make([]RerankResult, len(scores))
+	for i, s := range scores {
+		want[i] = RerankResult{Index: i, Score: s}
+	}
+	want = topRerank(want, -1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for _, k := range []int{-1, 0, 5, 70, 100} {
+		got, err := rerankBatches(ctx, len(scores), k, score)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := want
+		if k >= 0 && k < len(w) {
+			w = w[:k]
+		}
+		if !reflect.DeepEqual(got, w) {
+			t.Errorf("k=%d: got %v, want %v", k, got, w)
+		}
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	calls := 0
+	_, err := rerankBatches(ctx, len(scores), -1, func(start, end int) ([]RerankResult, error) {
+		calls++
+		cancel()
+		return score(start, end)
+	})
+	if errorCode(err) != ErrCancelled || calls != 1 {
+		t.Errorf("cancelled: got %v after %d calls", err, calls)
+	}
+}
+
+func TestTopRerank(t *testing.T) {
+	in := []RerankResult{{Index: 2, Score: 0.5}, {Index: 0, Score: 0.9}, {Index: 3, Score: 0.5}, {Index: 1, Score: 0.1}}
+	got := topRerank(in, 3)
+	want := []RerankResult{{Index: 0, Score: 0.9}, {Index: 2, Score: 0.5}, {Index: 3, Score: 0.5}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -1,20 +1,24 @@
 package kjarni
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"math"
-	"sync"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
+	"github.com/olafurjohannsson/kjarni-go/vecmath"
 )
 
 // Embedder encodes text into vector embeddings for similarity and search.
 type Embedder struct {
-	handle uintptr
-	mu     sync.Mutex
-	closed bool
+	handle    uintptr
+	mu        handleLock
+	closed    bool
+	model     string
+	normalize bool
+	strict    bool // reject inputs over maxTokens instead of truncating
+	maxTokens int
 }
 
 // NewEmbedder creates an embedder for the given model.
@@ -28,14 +32,23 @@ func NewEmbedder(model string, opts ...Option) (*Embedder, error) {
 	}
 
 	o := applyOptions(opts)
+	if !o.truncate && _embedderTokenizeSym == 0 {
+		return nil, unsupported("kjarni_embedder_tokenize")
+	}
 
 	modelStr, keepModel := cString(model)
 	defer keepModel()
+	cacheDirPtr, keepCacheDir := optionalCString(o.cacheDir)
+	defer keepCacheDir()
+	modelPathPtr, keepModelPath := optionalCString(o.modelPath)
+	defer keepModelPath()
 
 	var config ffiEmbedderConfig
 	config.Device = deviceCode(o.device)
+	config.CacheDir = cacheDirPtr
 	config.ModelName = modelStr
-	config.Normalize = 1
+	config.ModelPath = modelPathPtr
+	config.Normalize = boolToInt(o.normalize)
 	config.Quiet = boolToInt(o.quiet)
 
 	var handle uintptr
@@ -50,17 +63,39 @@ func NewEmbedder(model string, opts ...Option) (*Embedder, error) {
 		return nil, lastError(code)
 	}
 
-	return &Embedder{handle: handle}, nil
+	e := &Embedder{
+		handle:    handle,
+		model:     model,
+		normalize: o.normalize,
+		strict:    !o.truncate,
+		maxTokens: maxLengthWith(_embedderMaxLengthSym, handle),
+	}
+	if err := checkMaxTokens(e.strict, e.maxTokens, "kjarni_embedder_max_length"); err != nil {
+		_embedderFree(handle)
+		return nil, err
+	}
+	return e, nil
 }
 
 // Encode returns the embedding vector for the given text.
 func (e *Embedder) Encode(text string) ([]float32, error) {
-	e.mu.Lock()
+	return e.EncodeContext(context.Background(), text)
+}
+
+// EncodeContext is like Encode but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the embedder.
+func (e *Embedder) EncodeContext(ctx context.Context, text string) ([]float32, error) {
+	if err := e.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer e.mu.Unlock()
 
 	if e.closed {
 		return nil, errors.New("embedder is closed")
 	}
+	if err := e.checkLength(ctx, 0, text); err != nil {
+		return nil, err
+	}
 
 	textPtr, keepText := cString(text)
 	defer keepText()
@@ -85,7 +120,16 @@ func (e *Embedder) Encode(text string) ([]float32, error) {
 
 // EncodeBatch encodes multiple texts and returns their embedding vectors.
 func (e *Embedder) EncodeBatch(texts []string) ([][]float32, error) {
-	e.mu.Lock()
+	return e.EncodeBatchContext(context.Background(), texts)
+}
+
+// EncodeBatchContext is like EncodeBatch but honors ctx. Cancellable contexts
+// split the texts into sub-batches and check ctx between them, returning
+// ErrCancelled or ErrTimeout without partial results.
+func (e *Embedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if err := e.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer e.mu.Unlock()
 
 	if e.closed {
@@ -96,6 +140,26 @@ func (e *Embedder) EncodeBatch(texts []string) ([][]float32, error) {
 		return [][]float32{}, nil
 	}
 
+	vecs := make([][]float32, 0, len(texts))
+	err := splitBatches(ctx, len(texts), contextBatchSize, func(start, end int) error {
+		if err := e.checkLength(ctx, start, texts[start:end]...); err != nil {
+			return err
+		}
+		batch, err := e.encodeBatch(texts[start:end])
+		if err != nil {
+			return err
+		}
+		vecs = append(vecs, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return vecs, nil
+}
+
+// encodeBatch makes a single engine call. The caller must hold e.mu.
+func (e *Embedder) encodeBatch(texts []string) ([][]float32, error) {
 	// array of C string
 	cStrs := make([]uintptr, len(texts))
 	keeps := make([]func(), len(texts))
@@ -129,12 +193,23 @@ func (e *Embedder) EncodeBatch(texts []string) ([][]float32, error) {
 
 // Similarity returns the cosine similarity between two texts, computed by the engine.
 func (e *Embedder) Similarity(a, b string) (float32, error) {
-	e.mu.Lock()
+	return e.SimilarityContext(context.Background(), a, b)
+}
+
+// SimilarityContext is like Similarity but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the embedder.
+func (e *Embedder) SimilarityContext(ctx context.Context, a, b string) (float32, error) {
+	if err := e.mu.LockContext(ctx); err != nil {
+		return 0, err
+	}
 	defer e.mu.Unlock()
 
 	if e.closed {
 		return 0, errors.New("embedder is closed")
 	}
+	if err := e.checkLength(ctx, 0, a, b); err != nil {
+		return 0, err
+	}
 
 	aPtr, keepA := cString(a)
 	defer keepA()
@@ -165,6 +240,73 @@ func (e *Embedder) Dim() int {
 	return int(_embedderDim(e.handle))
 }
 
+// Model returns the model name the embedder was created with.
+func (e *Embedder) Model() string {
+	return e.model
+}
+
+// Normalized reports whether the embedder returns unit-length vectors.
+// See WithNormalize.
+func (e *Embedder) Normalized() bool {
+	return e.normalize
+}
+
+// Tokenize returns the tokens the model sees for text, including special
+// tokens, with byte offsets into text. It does not truncate.
+func (e *Embedder) Tokenize(text string) ([]Token, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return nil, errors.New("embedder is closed")
+	}
+	return tokenizeWith(_embedderTokenizeSym, "kjarni_embedder_tokenize", e.handle, text)
+}
+
+// CountTokens returns the number of tokens in text, including special
+// tokens. Inputs with more than MaxTokens tokens are truncated by Encode.
+func (e *Embedder) CountTokens(text string) (int, error) {
+	tokens, err := e.Tokenize(text)
+	return len(tokens), err
+}
+
+// Truncate returns the longest prefix of text, ending at a token boundary,
+// that fits in maxTokens tokens including special tokens. A maxTokens of
+// zero uses MaxTokens. It fails with ErrInvalidConfig if maxTokens is less
+// than the number of special tokens.
+func (e *Embedder) Truncate(text string, maxTokens int) (string, error) {
+	if maxTokens <= 0 {
+		maxTokens = e.maxTokens
+	}
+	if maxTokens <= 0 {
+		return "", invalidConfig("model maximum length is unknown, pass maxTokens")
+	}
+	tokens, err := e.Tokenize(text)
+	if err != nil {
+		return "", err
+	}
+	return truncateTokens(text, tokens, maxTokens)
+}
+
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (e *Embedder) MaxTokens() int {
+	return e.maxTokens
+}
+
+// checkLength returns an *InputTooLongError for the first text over the
+// limit when truncation is off, numbering texts from offset. It checks ctx
+// before each text. The caller must hold e.mu.
+func (e *Embedder) checkLength(ctx context.Context, offset int, texts ...string) error {
+	if !e.strict {
+		return nil
+	}
+	return firstTooLong(ctx, offset, len(texts), e.maxTokens, func(i int) (int, error) {
+		tokens, err := tokenizeWith(_embedderTokenizeSym, "kjarni_embedder_tokenize", e.handle, texts[i])
+		return len(tokens), err
+	})
+}
+
 // Close releases the embedder resources. Safe to call multiple times.
 func (e *Embedder) Close() error {
 	e.mu.Lock()
@@ -180,21 +322,14 @@ func (e *Embedder) Close() error {
 }
 
 // CosineSimilarity computes cosine similarity between two vectors in Go.
+// It divides by both norms, so it gives the same result for normalized and
+// unnormalized embeddings. It returns 0 if the lengths differ or either
+// vector is all zeros. See package vecmath for batched variants.
 func CosineSimilarity(a, b []float32) float32 {
 	if len(a) != len(b) || len(a) == 0 {
 		return 0
 	}
-	var dot, normA, normB float32
-	for i := range a {
-		dot += a[i] * b[i]
-		normA += a[i] * a[i]
-		normB += b[i] * b[i]
-	}
-	denom := float32(math.Sqrt(float64(normA))) * float32(math.Sqrt(float64(normB)))
-	if denom == 0 {
-		return 0
-	}
-	return dot / denom
+	return vecmath.Cosine(a, b)
 }
 
 func floatArrayToSlice(arr ffiFloatArray) []float32 {
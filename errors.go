@@ -17,6 +17,7 @@ const (
 	ErrCancelled       ErrorCode = 8
 	ErrTimeout         ErrorCode = 9
 	ErrStreamEnded     ErrorCode = 10
+	ErrInputTooLong    ErrorCode = 11
 	ErrUnknown         ErrorCode = 255
 )
 
@@ -28,4 +29,26 @@ type KjarniError struct {
 
 func (e *KjarniError) Error() string {
 	return fmt.Sprintf("kjarni: %s (code %d)", e.Message, e.Code)
-}
\ No newline at end of file
+}
+
+// InputTooLongError is returned when truncation is off and an input has
+// more tokens than the model accepts. Index is the position of the input in
+// the call that failed. It unwraps to a *KjarniError with code
+// ErrInputTooLong, so checks of the code also match it.
+type InputTooLongError struct {
+	Index  int
+	Tokens int
+	Max    int
+}
+
+func (e *InputTooLongError) Error() string {
+	return e.Unwrap().Error()
+}
+
+// Unwrap returns the equivalent *KjarniError.
+func (e *InputTooLongError) Unwrap() error {
+	return &KjarniError{
+		Code:    ErrInputTooLong,
+		Message: fmt.Sprintf("input %d is %d tokens, model maximum is %d", e.Index, e.Tokens, e.Max),
+	}
+}
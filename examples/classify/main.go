@@ -26,12 +26,17 @@ func main() {
 	fmt.Println(result)
 	// positive (98.5%)
 
-	// multi-label models like toxic-bert return scores for all labels
-	t, _ := kjarni.NewClassifier("toxic-bert", kjarni.WithQuiet(true))
+	// multi-label models like toxic-bert score every label independently.
+	// Labels holds each one above the threshold.
+	t, _ := kjarni.NewClassifier("toxic-bert",
+		kjarni.WithQuiet(true),
+		kjarni.WithMultiLabel(true),
+		kjarni.WithLabelThreshold(0.3),
+	)
 	defer t.Close()
 
 	toxicity, _ := t.Classify("You are an idiot")
-	for _, s := range toxicity.AllScores {
+	for _, s := range toxicity.Labels {
 		fmt.Printf("  %s: %.1f%%\n", s.Label, s.Score*100)
 	}
 }
\ No newline at end of file
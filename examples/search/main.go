@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// index a directory of documents and search it with keyword, semantic
+// and hybrid search. pass the directory to index as the first argument.
+func main() {
+	dir := "."
+	if len(os.Args) > 1 {
+		dir = os.Args[1]
+	}
+	indexPath := os.TempDir() + "/kjarni-example-index"
+
+	idx, err := kjarni.NewIndexer("minilm-l6-v2",
+		kjarni.WithQuiet(true),
+		kjarni.WithExtensions("md", "txt", "go"),
+	)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	defer idx.Close()
+
+	stats, err := idx.CreateWithOptions(indexPath, []string{dir}, kjarni.CreateOptions{Force: true})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("indexed %d files, %d chunks\n\n", stats.FilesProcessed, stats.ChunksCreated)
+
+	s, err := kjarni.NewSearcher("minilm-l6-v2", "", kjarni.WithQuiet(true))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		os.Exit(1)
+	}
+	defer s.Close()
+
+	query := "how do I create an embedder?"
+	for _, mode := range []kjarni.SearchMode{kjarni.Keyword, kjarni.Semantic, kjarni.Hybrid} {
+		results, _ := s.SearchWithOptions(indexPath, query, kjarni.SearchOptions{Mode: mode, TopK: 3})
+		fmt.Printf("%s:\n", mode)
+		for _, r := range results {
+			fmt.Printf("  %.4f  %s\n", r.Score, r.Source())
+		}
+	}
+}
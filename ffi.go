@@ -74,6 +74,13 @@ type ffiRerankResults struct {
 	Len     uintptr
 }
 
+type ffiTokens struct {
+	Ids     *uint32  // one per token
+	Offsets *uintptr // size_t start and end byte offset per token
+	Special *uint8   // one per token, 1 for tokens the model adds
+	Len     uintptr
+}
+
 var (
 	ffiOnce sync.Once
 
@@ -86,6 +93,8 @@ var (
 	_classifierFree    func(handle uintptr)
 	_classifierClassifySym uintptr
 	_classifierNumLabels func(handle uintptr) uintptr
+	_classifierTokenizeSym  uintptr // optional
+	_classifierMaxLengthSym uintptr // optional
 	_classResultsFree  func(results uintptr, len uintptr)
 
 	// Embedder
@@ -95,6 +104,8 @@ var (
 	_embedderEncodeBatchSym uintptr
 	_embedderSimilaritySym uintptr
 	_embedderDim       func(handle uintptr) uintptr
+	_embedderTokenizeSym  uintptr // optional
+	_embedderMaxLengthSym uintptr // optional
 	_floatArrayFree    func(data uintptr, len uintptr)
 	_float2DArrayFree  func(data uintptr, rows uintptr, cols uintptr)
 
@@ -105,11 +116,17 @@ var (
 	_rerankerRerankSym uintptr
 	_rerankerRerankTopKSym uintptr
 	_rerankResultsFree func(results uintptr, len uintptr)
+	_rerankerTokenizeSym        uintptr // optional
+	_rerankerMaxLengthSym       uintptr // optional
+	_rerankerCountPairTokensSym uintptr // optional
 
 	// Indexer
 	_indexerNewSym    uintptr
 	_indexerFree      func(handle uintptr)
 	_indexerCreateSym uintptr
+	_indexerAddSym    uintptr // optional
+	_indexerRemoveSym uintptr // optional
+	_indexerIndexDocumentsSym uintptr // optional
 
 	// Searcher
 	_searcherNewSym              uintptr
@@ -243,6 +260,16 @@ func initFFI() error {
 		return err
 	}
 
+	// tokenizer access is only available in newer engines
+	_classifierTokenizeSym, _ = findSymbol(handle, "kjarni_classifier_tokenize")
+	_classifierMaxLengthSym, _ = findSymbol(handle, "kjarni_classifier_max_length")
+	_embedderTokenizeSym, _ = findSymbol(handle, "kjarni_embedder_tokenize")
+	_embedderMaxLengthSym, _ = findSymbol(handle, "kjarni_embedder_max_length")
+	_rerankerTokenizeSym, _ = findSymbol(handle, "kjarni_reranker_tokenize")
+	_rerankerMaxLengthSym, _ = findSymbol(handle, "kjarni_reranker_max_length")
+	_rerankerCountPairTokensSym, _ = findSymbol(handle, "kjarni_reranker_count_pair_tokens")
+	_tokensFreeSym, _ = findSymbol(handle, "kjarni_tokens_free")
+
 	// Indexer
 	_indexerNewSym, err = findSymbol(handle, "kjarni_indexer_new")
 	if err != nil {
@@ -260,6 +287,11 @@ func initFFI() error {
 		return err
 	}
 
+	// incremental updates are only available in newer engines
+	_indexerAddSym, _ = findSymbol(handle, "kjarni_indexer_add")
+	_indexerRemoveSym, _ = findSymbol(handle, "kjarni_indexer_remove")
+	_indexerIndexDocumentsSym, _ = findSymbol(handle, "kjarni_indexer_index_documents")
+
 	// Searcher
 	_searcherNewSym, err = findSymbol(handle, "kjarni_searcher_new")
 	if err != nil {
@@ -291,6 +323,7 @@ var (
 	_float2DArrayFreeSym       uintptr
 	_rerankResultsFreeSym      uintptr
 	_searchResultsFreeSym      uintptr
+	_tokensFreeSym             uintptr
 )
 
 // convert Go string to null-terminated C string, returns pointer and cleanup func
@@ -303,6 +336,41 @@ func cString(s string) (uintptr, func()) {
 	}
 }
 
+// like cString, but returns a NULL pointer for the empty string
+func optionalCString(s string) (uintptr, func()) {
+	if s == "" {
+		return 0, func() {}
+	}
+	return cString(s)
+}
+
+// convert Go strings to a NULL-terminated array of C strings, returns pointer
+// to the first element (0 for an empty slice) and cleanup func
+func cStringArray(ss []string) (uintptr, func()) {
+	if len(ss) == 0 {
+		return 0, func() {}
+	}
+	ptrs := make([]uintptr, len(ss)+1)
+	keeps := make([]func(), len(ss))
+	for i, s := range ss {
+		ptrs[i], keeps[i] = cString(s)
+	}
+	return uintptr(unsafe.Pointer(&ptrs[0])), func() {
+		for _, k := range keeps {
+			k()
+		}
+		_ = ptrs
+	}
+}
+
+// error for an optional entry point missing from the loaded engine
+func unsupported(symbol string) error {
+	return &KjarniError{
+		Code:    ErrUnknown,
+		Message: symbol + " is not supported by this engine build",
+	}
+}
+
 // get last error message from FFI
 func lastError(code int32) error {
 	ptr := _lastErrorMessage()
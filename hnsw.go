@@ -0,0 +1,420 @@
+package kjarni
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/olafurjohannsson/kjarni-go/vecmath"
+)
+
+// HNSWConfig configures an HNSWIndex. Zero fields take their defaults.
+type HNSWConfig struct {
+	// Metric selects how vectors are compared. Defaults to MetricCosine.
+	Metric Metric
+	// M is the number of neighbours each node keeps per layer (twice that on
+	// the bottom layer). Higher values improve recall at the cost of memory
+	// and insert time. Defaults to 16.
+	M int
+	// EfConstruction is the candidate list size used while inserting.
+	// Defaults to 200.
+	EfConstruction int
+	// EfSearch is the candidate list size used while searching. Higher values
+	// improve recall at the cost of latency. Defaults to 50.
+	EfSearch int
+	// Seed seeds the random level generator. Defaults to 1.
+	Seed int64
+}
+
+// HNSWIndex is an in-memory approximate nearest-neighbour index using a
+// hierarchical navigable small world graph. It answers top-k queries over
+// millions of vectors far faster than the exact VectorStore, at the cost of
+// occasionally missing a true neighbour. An HNSWIndex is safe for concurrent
+// use; searches run in parallel with each other but not with inserts.
+//
+// Deleted vectors are tombstoned: they stop appearing in results but remain
+// in the graph to keep it connected. Call Compact to rebuild the graph
+// without them once many have accumulated.
+type HNSWIndex struct {
+	mu        sync.RWMutex
+	cfg       HNSWConfig
+	dim       int
+	levelMult float64
+	rng       *rand.Rand
+
+	nodes    []*hnswNode
+	pos      map[string]int32
+	entry    int32
+	maxLevel int
+	deleted  int
+}
+
+type hnswNode struct {
+	id        string
+	vec       []float32
+	metadata  map[string]any
+	neighbors [][]int32 // per layer, 0 is the bottom
+	deleted   bool
+}
+
+// NewHNSWIndex creates an empty index for vectors of the given dimension.
+func NewHNSWIndex(dim int, cfg HNSWConfig) (*HNSWIndex, error) {
+	if dim <= 0 {
+		return nil, invalidConfig("dimension must be positive, got %d", dim)
+	}
+	if cfg.M == 0 {
+		cfg.M = 16
+	}
+	if cfg.EfConstruction == 0 {
+		cfg.EfConstruction = 200
+	}
+	if cfg.EfSearch == 0 {
+		cfg.EfSearch = 50
+	}
+	if cfg.Seed == 0 {
+		cfg.Seed = 1
+	}
+	switch {
+	case !cfg.Metric.valid():
+		return nil, invalidConfig("unknown metric %d", cfg.Metric)
+	case cfg.M < 2:
+		return nil, invalidConfig("M must be at least 2, got %d", cfg.M)
+	case cfg.EfConstruction < cfg.M:
+		return nil, invalidConfig("EfConstruction (%d) must be at least M (%d)", cfg.EfConstruction, cfg.M)
+	case cfg.EfSearch < 1:
+		return nil, invalidConfig("EfSearch must be positive, got %d", cfg.EfSearch)
+	}
+
+	return &HNSWIndex{
+		cfg:       cfg,
+		dim:       dim,
+		levelMult: 1 / math.Log(float64(cfg.M)),
+		rng:       rand.New(rand.NewSource(cfg.Seed)),
+		pos:       make(map[string]int32),
+		entry:     -1,
+	}, nil
+}
+
+// Len returns the number of live (not deleted) vectors in the index.
+func (h *HNSWIndex) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.pos)
+}
+
+// Dim returns the dimension of vectors in the index.
+func (h *HNSWIndex) Dim() int {
+	return h.dim
+}
+
+// SetEfSearch changes the candidate list size used by subsequent searches.
+func (h *HNSWIndex) SetEfSearch(ef int) {
+	if ef < 1 {
+		ef = 1
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.cfg.EfSearch = ef
+}
+
+// Add inserts vec under id, replacing any vector already stored under id.
+// The vector is copied.
+func (h *HNSWIndex) Add(id string, vec []float32, metadata map[string]any) error {
+	if len(vec) != h.dim {
+		return fmt.Errorf("vector has %d dimensions, index expects %d", len(vec), h.dim)
+	}
+
+	stored := make([]float32, len(vec))
+	copy(stored, vec)
+	if h.cfg.Metric == MetricCosine {
+		vecmath.Normalize(stored)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if old, ok := h.pos[id]; ok {
+		h.nodes[old].deleted = true
+		h.deleted++
+	}
+	h.insert(&hnswNode{id: id, vec: stored, metadata: metadata})
+	return nil
+}
+
+// Delete tombstones the vector stored under id and reports whether it was present.
+func (h *HNSWIndex) Delete(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i, ok := h.pos[id]
+	if !ok {
+		return false
+	}
+	h.nodes[i].deleted = true
+	h.deleted++
+	delete(h.pos, id)
+	return true
+}
+
+// Deleted returns the number of tombstoned vectors still held in the graph.
+func (h *HNSWIndex) Deleted() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.deleted
+}
+
+// Compact rebuilds the graph from the live vectors, releasing the memory
+// held by tombstones.
+func (h *HNSWIndex) Compact() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	old := h.nodes
+	h.nodes = nil
+	h.pos = make(map[string]int32, len(old)-h.deleted)
+	h.entry = -1
+	h.maxLevel = 0
+	h.deleted = 0
+	for _, n := range old {
+		if !n.deleted {
+			h.insert(&hnswNode{id: n.id, vec: n.vec, metadata: n.metadata})
+		}
+	}
+}
+
+// Search returns approximately the k vectors scoring highest against query,
+// sorted by descending score. Scores follow the same convention as
+// VectorStore: higher is always better.
+func (h *HNSWIndex) Search(query []float32, k int) ([]VectorMatch, error) {
+	if len(query) != h.dim {
+		return nil, fmt.Errorf("query has %d dimensions, index expects %d", len(query), h.dim)
+	}
+
+	q := query
+	if h.cfg.Metric == MetricCosine {
+		q = make([]float32, len(query))
+		copy(q, query)
+		vecmath.Normalize(q)
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if k <= 0 || h.entry < 0 {
+		return []VectorMatch{}, nil
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.searchLayer(q, ep, 1, l, false)[0].node
+	}
+	ef := h.cfg.EfSearch
+	if ef < k {
+		ef = k
+	}
+	cands := h.searchLayer(q, ep, ef, 0, true)
+
+	out := make([]VectorMatch, 0, k)
+	for _, c := range cands {
+		n := h.nodes[c.node]
+		out = append(out, VectorMatch{
+			ID:       n.id,
+			Score:    h.score(c.dist),
+			Metadata: n.metadata,
+		})
+		if len(out) == k {
+			break
+		}
+	}
+	return out, nil
+}
+
+// insert adds n to the graph. The caller must hold h.mu for writing.
+func (h *HNSWIndex) insert(n *hnswNode) {
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMult)
+	n.neighbors = make([][]int32, level+1)
+
+	idx := int32(len(h.nodes))
+	h.nodes = append(h.nodes, n)
+	h.pos[n.id] = idx
+
+	if h.entry < 0 {
+		h.entry = idx
+		h.maxLevel = level
+		return
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.searchLayer(n.vec, ep, 1, l, false)[0].node
+	}
+
+	top := level
+	if top > h.maxLevel {
+		top = h.maxLevel
+	}
+	for l := top; l >= 0; l-- {
+		cands := h.searchLayer(n.vec, ep, h.cfg.EfConstruction, l, false)
+		n.neighbors[l] = h.selectNeighbors(cands, h.cfg.M)
+
+		maxConn := h.maxConnections(l)
+		for _, nb := range n.neighbors[l] {
+			other := h.nodes[nb]
+			other.neighbors[l] = append(other.neighbors[l], idx)
+			if len(other.neighbors[l]) > maxConn {
+				other.neighbors[l] = h.prune(other, l, maxConn)
+			}
+		}
+		ep = cands[0].node
+	}
+
+	if level > h.maxLevel {
+		h.entry = idx
+		h.maxLevel = level
+	}
+}
+
+func (h *HNSWIndex) maxConnections(level int) int {
+	if level == 0 {
+		return 2 * h.cfg.M
+	}
+	return h.cfg.M
+}
+
+// prune shrinks the neighbour list of n at the given level to at most max entries.
+func (h *HNSWIndex) prune(n *hnswNode, level, max int) []int32 {
+	cands := make([]hnswCandidate, len(n.neighbors[level]))
+	for i, nb := range n.neighbors[level] {
+		cands[i] = hnswCandidate{node: nb, dist: h.distance(n.vec, h.nodes[nb].vec)}
+	}
+	sort.Slice(cands, func(i, j int) bool { return cands[i].dist < cands[j].dist })
+	return h.selectNeighbors(cands, max)
+}
+
+// selectNeighbors picks up to m neighbours from cands, which must be sorted
+// by ascending distance, using the diversity heuristic from the HNSW paper:
+// a candidate is kept only if it is closer to the new node than to any
+// neighbour already kept. Remaining slots are filled with the closest
+// rejected candidates.
+func (h *HNSWIndex) selectNeighbors(cands []hnswCandidate, m int) []int32 {
+	selected := make([]int32, 0, m)
+	var rejected []int32
+	for _, c := range cands {
+		if len(selected) == m {
+			break
+		}
+		keep := true
+		for _, s := range selected {
+			if h.distance(h.nodes[c.node].vec, h.nodes[s].vec) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c.node)
+		} else {
+			rejected = append(rejected, c.node)
+		}
+	}
+	for _, r := range rejected {
+		if len(selected) == m {
+			break
+		}
+		selected = append(selected, r)
+	}
+	return selected
+}
+
+// searchLayer returns up to ef nodes closest to q on the given level,
+// sorted by ascending distance, starting from ep. With live set, deleted
+// nodes are still traversed but never returned, so they do not take up
+// any of the ef slots.
+func (h *HNSWIndex) searchLayer(q []float32, ep int32, ef, level int, live bool) []hnswCandidate {
+	visited := make([]uint64, (len(h.nodes)+63)/64)
+	visited[ep/64] |= 1 << (ep % 64)
+
+	start := hnswCandidate{node: ep, dist: h.distance(q, h.nodes[ep].vec)}
+	cands := &hnswMinHeap{start}
+	results := &hnswMaxHeap{}
+	if !live || !h.nodes[ep].deleted {
+		*results = append(*results, start)
+	}
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(hnswCandidate)
+		if results.Len() >= ef && c.dist > (*results)[0].dist {
+			break
+		}
+		for _, nb := range h.nodes[c.node].neighbors[level] {
+			if visited[nb/64]&(1<<(nb%64)) != 0 {
+				continue
+			}
+			visited[nb/64] |= 1 << (nb % 64)
+
+			d := h.distance(q, h.nodes[nb].vec)
+			if results.Len() < ef || d < (*results)[0].dist {
+				heap.Push(cands, hnswCandidate{node: nb, dist: d})
+				if live && h.nodes[nb].deleted {
+					continue
+				}
+				heap.Push(results, hnswCandidate{node: nb, dist: d})
+				if results.Len() > ef {
+					heap.Pop(results)
+				}
+			}
+		}
+	}
+
+	out := make([]hnswCandidate, results.Len())
+	for i := len(out) - 1; i >= 0; i-- {
+		out[i] = heap.Pop(results).(hnswCandidate)
+	}
+	return out
+}
+
+// distance returns a value where lower means closer. Cosine vectors are
+// normalized on insert, so cosine distance reduces to 1 - dot.
+func (h *HNSWIndex) distance(a, b []float32) float32 {
+	switch h.cfg.Metric {
+	case MetricDot:
+		return -vecmath.Dot(a, b)
+	case MetricL2:
+		return vecmath.L2(a, b)
+	default:
+		return 1 - vecmath.Dot(a, b)
+	}
+}
+
+// score converts a distance back to a higher-is-better score.
+func (h *HNSWIndex) score(dist float32) float32 {
+	switch h.cfg.Metric {
+	case MetricDot, MetricL2:
+		return -dist
+	default:
+		return 1 - dist
+	}
+}
+
+type hnswCandidate struct {
+	node int32
+	dist float32
+}
+
+type hnswMinHeap []hnswCandidate
+
+func (h hnswMinHeap) Len() int           { return len(h) }
+func (h hnswMinHeap) Less(i, j int) bool { return h[i].dist < h[j].dist }
+func (h hnswMinHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hnswMinHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *hnswMinHeap) Pop() any          { old := *h; x := old[len(old)-1]; *h = old[:len(old)-1]; return x }
+
+type hnswMaxHeap []hnswCandidate
+
+func (h hnswMaxHeap) Len() int           { return len(h) }
+func (h hnswMaxHeap) Less(i, j int) bool { return h[i].dist > h[j].dist }
+func (h hnswMaxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *hnswMaxHeap) Push(x any)        { *h = append(*h, x.(hnswCandidate)) }
+func (h *hnswMaxHeap) Pop() any          { old := *h; x := old[len(old)-1]; *h = old[:len(old)-1]; return x }
@@ -0,0 +1,340 @@
+package kjarni
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+func randomVectors(rng *rand.Rand, n, dim int) [][]float32 {
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		v := make([]float32, dim)
+		for j := range v {
+			v[j] = float32(rng.NormFloat64())
+		}
+		vecs[i] = v
+	}
+	return vecs
+}
+
+// clusteredVectors returns n vectors scattered around a few random centres,
+// which resembles the structure of real embeddings more closely than
+// uniform noise does.
+func clusteredVectors(rng *rand.Rand, n, dim int) [][]float32 {
+	centres := randomVectors(rng, 50, dim)
+	vecs := randomVectors(rng, n, dim)
+	for _, v := range vecs {
+		c := centres[rng.Intn(len(centres))]
+		for j := range v {
+			v[j] = c[j] + 0.5*v[j]
+		}
+	}
+	return vecs
+}
+
+// buildHNSW adds vecs to a new index and an exact store under the same ids.
+func buildHNSW(tb testing.TB, metric Metric, vecs [][]float32) (*HNSWIndex, *VectorStore) {
+	tb.Helper()
+	dim := len(vecs[0])
+	index, err := NewHNSWIndex(dim, HNSWConfig{Metric: metric})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	exact, err := NewVectorStore(metric, nil)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i, v := range vecs {
+		id := fmt.Sprint(i)
+		if err := index.Add(id, v, nil); err != nil {
+			tb.Fatal(err)
+		}
+		if err := exact.Add(id, v, nil); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return index, exact
+}
+
+// recall returns the fraction of the exact top-k found by the index.
+func recall(tb testing.TB, index interface {
+	Search(query []float32, k int) ([]VectorMatch, error)
+}, exact *VectorStore, queries [][]float32, k int) float64 {
+	tb.Helper()
+	var hits, total int
+	for _, q := range queries {
+		want, err := exact.Search(q, k)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		got, err := index.Search(q, k)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		truth := make(map[string]bool, len(want))
+		for _, m := range want {
+			truth[m.ID] = true
+		}
+		for _, m := range got {
+			if truth[m.ID] {
+				hits++
+			}
+		}
+		total += len(want)
+	}
+	return float64(hits) / float64(total)
+}
+
+func TestHNSWRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vecs := randomVectors(rng, 3000, 32)
+	queries := randomVectors(rng, 100, 32)
+
+	tests := []struct {
+		metric Metric
+		ef     int
+		min    float64
+	}{
+		{MetricCosine, 64, 0.9},
+		{MetricCosine, 200, 0.97},
+		{MetricDot, 200, 0.9},
+		{MetricL2, 200, 0.97},
+	}
+	for _, tt := range tests {
+		index, exact := buildHNSW(t, tt.metric, vecs)
+		index.SetEfSearch(tt.ef)
+		if r := recall(t, index, exact, queries, 10); r < tt.min {
+			t.Errorf("metric %d ef=%d: recall@10 %.3f, want at least %.2f", tt.metric, tt.ef, r, tt.min)
+		}
+	}
+}
+
+func TestHNSWScoresMatchExact(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vecs := randomVectors(rng, 200, 8)
+	for _, metric := range []Metric{MetricCosine, MetricDot, MetricL2} {
+		index, exact := buildHNSW(t, metric, vecs)
+		q := randomVectors(rng, 1, 8)[0]
+		got, _ := index.Search(q, 5)
+		for _, m := range got {
+			vec, _, _ := exact.Get(m.ID)
+			want := exact.score(q, vec)
+			if d := m.Score - want; d > 1e-4 || d < -1e-4 {
+				t.Errorf("metric %d: score for %s = %v, exact %v", metric, m.ID, m.Score, want)
+			}
+		}
+	}
+}
+
+func TestHNSWDeleted(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	const dim = 16
+
+	tests := []struct {
+		name  string
+		setup func(h *HNSWIndex)
+		live  int
+	}{
+		{"delete most", func(h *HNSWIndex) {
+			for i, v := range randomVectors(rng, 2000, dim) {
+				h.Add(fmt.Sprint(i), v, nil)
+			}
+			for i := 0; i < 1900; i++ {
+				h.Delete(fmt.Sprint(i))
+			}
+		}, 100},
+		{"re-add", func(h *HNSWIndex) {
+			for round := 0; round < 20; round++ {
+				for i, v := range randomVectors(rng, 100, dim) {
+					h.Add(fmt.Sprint(i), v, nil)
+				}
+			}
+		}, 100},
+		{"delete all", func(h *HNSWIndex) {
+			for i, v := range randomVectors(rng, 50, dim) {
+				h.Add(fmt.Sprint(i), v, nil)
+			}
+			for i := 0; i < 50; i++ {
+				h.Delete(fmt.Sprint(i))
+			}
+		}, 0},
+	}
+	for _, tt := range tests {
+		h, err := NewHNSWIndex(dim, HNSWConfig{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tt.setup(h)
+		if h.Len() != tt.live {
+			t.Fatalf("%s: Len() = %d, want %d", tt.name, h.Len(), tt.live)
+		}
+
+		want := 10
+		if tt.live < want {
+			want = tt.live
+		}
+		for _, q := range randomVectors(rng, 20, dim) {
+			matches, err := h.Search(q, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(matches) != want {
+				t.Fatalf("%s: got %d results, want %d", tt.name, len(matches), want)
+			}
+			seen := make(map[string]bool)
+			for _, m := range matches {
+				if _, ok := h.pos[m.ID]; !ok || seen[m.ID] {
+					t.Fatalf("%s: result %q is deleted or repeated", tt.name, m.ID)
+				}
+				seen[m.ID] = true
+			}
+		}
+
+		h.Compact()
+		if h.Deleted() != 0 || h.Len() != tt.live {
+			t.Errorf("%s: after Compact Len()=%d Deleted()=%d", tt.name, h.Len(), h.Deleted())
+		}
+	}
+}
+
+func TestHNSWConfig(t *testing.T) {
+	tests := []struct {
+		dim int
+		cfg HNSWConfig
+		ok  bool
+	}{
+		{8, HNSWConfig{}, true},
+		{0, HNSWConfig{}, false},
+		{8, HNSWConfig{M: 1}, false},
+		{8, HNSWConfig{M: 32, EfConstruction: 16}, false},
+		{8, HNSWConfig{EfSearch: -1}, false},
+		{8, HNSWConfig{Metric: MetricL2}, true},
+		{8, HNSWConfig{Metric: Metric(-1)}, false},
+		{8, HNSWConfig{Metric: MetricL2 + 1}, false},
+	}
+	for _, tt := range tests {
+		_, err := NewHNSWIndex(tt.dim, tt.cfg)
+		if (err == nil) != tt.ok {
+			t.Errorf("dim %d %+v: error %v", tt.dim, tt.cfg, err)
+		}
+		if err != nil && errorCode(err) != ErrInvalidConfig {
+			t.Errorf("dim %d %+v: got %v, want ErrInvalidConfig", tt.dim, tt.cfg, err)
+		}
+	}
+}
+
+// TestHNSWConcurrent mixes writers and readers; run it with -race.
+func TestHNSWConcurrent(t *testing.T) {
+	const dim = 16
+	h, err := NewHNSWIndex(dim, HNSWConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vecs := randomVectors(rand.New(rand.NewSource(3)), 400, dim)
+	for i, v := range vecs[:100] {
+		if err := h.Add(fmt.Sprint(i), v, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 100 + w; i < len(vecs); i += 4 {
+				if err := h.Add(fmt.Sprint(i), vecs[i], nil); err != nil {
+					t.Error(err)
+					return
+				}
+				h.Delete(fmt.Sprint(i - 100))
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < len(vecs); i += 4 {
+				matches, err := h.Search(vecs[i], 5)
+				if err != nil || len(matches) > 5 {
+					t.Errorf("search: %d matches, %v", len(matches), err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if h.Len() != 100 {
+		t.Errorf("Len() = %d, want 100", h.Len())
+	}
+	h.Compact()
+	matches, err := h.Search(vecs[len(vecs)-1], 1)
+	if err != nil || len(matches) != 1 || matches[0].ID != fmt.Sprint(len(vecs)-1) {
+		t.Errorf("after Compact: got %v, %v", matches, err)
+	}
+}
+
+const (
+	benchVectors = 10000
+	benchDim     = 384
+	benchQueries = 200
+)
+
+var benchIndex struct {
+	index   *HNSWIndex
+	exact   *VectorStore
+	queries [][]float32
+}
+
+// benchSetup builds the shared benchmark data once. Clustered random vectors
+// stand in for embeddings so the benchmarks run without a model.
+func benchSetup(b *testing.B) {
+	b.Helper()
+	if benchIndex.index != nil {
+		return
+	}
+	rng := rand.New(rand.NewSource(42))
+	vecs := clusteredVectors(rng, benchVectors+benchQueries, benchDim)
+	benchIndex.index, benchIndex.exact = buildHNSW(b, MetricCosine, vecs[:benchVectors])
+	benchIndex.queries = vecs[benchVectors:]
+	b.ResetTimer()
+}
+
+func BenchmarkExactSearch(b *testing.B) {
+	benchSetup(b)
+	for i := 0; i < b.N; i++ {
+		benchIndex.exact.Search(benchIndex.queries[i%benchQueries], 10)
+	}
+}
+
+// BenchmarkHNSWSearch reports latency and recall@10 against exact search
+// for a range of EfSearch values.
+func BenchmarkHNSWSearch(b *testing.B) {
+	benchSetup(b)
+	for _, ef := range []int{16, 32, 64, 128, 256} {
+		b.Run(fmt.Sprintf("ef=%d", ef), func(b *testing.B) {
+			index := benchIndex.index
+			index.SetEfSearch(ef)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				index.Search(benchIndex.queries[i%benchQueries], 10)
+			}
+			b.StopTimer()
+			b.ReportMetric(recall(b, index, benchIndex.exact, benchIndex.queries, 10), "recall@10")
+		})
+	}
+}
+
+func BenchmarkHNSWAdd(b *testing.B) {
+	rng := rand.New(rand.NewSource(42))
+	vecs := randomVectors(rng, 1000, benchDim)
+	index, err := NewHNSWIndex(benchDim, HNSWConfig{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		index.Add(fmt.Sprint(i), vecs[i%len(vecs)], nil)
+	}
+}
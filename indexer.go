@@ -1,9 +1,12 @@
 package kjarni
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"sync"
+	"io/fs"
+	"os"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -20,6 +23,22 @@ type IndexStats struct {
 	ElapsedMs        uint64
 }
 
+// Document is an in-memory document to index with Indexer.IndexDocuments.
+// Metadata values must be encodable as JSON.
+type Document struct {
+	ID       string
+	Text     string
+	Metadata map[string]any
+}
+
+// ffiDocument is the document layout IndexDocuments passes to
+// kjarni_indexer_index_documents.
+type ffiDocument struct {
+	Id           uintptr
+	Text         uintptr
+	MetadataJson uintptr
+}
+
 type ffiIndexerConfig struct {
 	Device          int32
 	_               int32 // padding
@@ -47,15 +66,18 @@ type ffiIndexStats struct {
 	ElapsedMs        uint64
 }
 
-// Indexer creates search indexes from files in a directory.
+// Indexer creates search indexes from files in a directory or from
+// in-memory documents.
 type Indexer struct {
 	handle uintptr
-	mu     sync.Mutex
+	mu     handleLock
 	closed bool
 }
 
 // NewIndexer creates an indexer using the given embedding model.
 // The model is used to generate vectors for each text chunk during indexing.
+// Chunking and file selection are configured with options such as
+// WithChunkSize, WithExtensions and WithExcludePatterns.
 func NewIndexer(model string, opts ...Option) (*Indexer, error) {
 	var initErr error
 	ffiOnce.Do(func() { initErr = initFFI() })
@@ -64,17 +86,35 @@ func NewIndexer(model string, opts ...Option) (*Indexer, error) {
 	}
 
 	o := applyOptions(opts)
+	if err := o.validateIndexer(); err != nil {
+		return nil, err
+	}
+	exts, err := normalizeExtensions(o.extensions)
+	if err != nil {
+		return nil, err
+	}
 
 	modelStr, keepModel := cString(model)
 	defer keepModel()
+	cacheDirPtr, keepCacheDir := optionalCString(o.cacheDir)
+	defer keepCacheDir()
+	extsPtr, keepExts := cStringArray(exts)
+	defer keepExts()
+	excludePtr, keepExclude := cStringArray(o.excludePatterns)
+	defer keepExclude()
 
 	var config ffiIndexerConfig
 	config.Device = deviceCode(o.device)
+	config.CacheDir = cacheDirPtr
 	config.ModelName = modelStr
-	config.ChunkSize = 512
-	config.ChunkOverlap = 50
-	config.BatchSize = 32
-	config.Recursive = 1
+	config.ChunkSize = uintptr(o.chunkSize)
+	config.ChunkOverlap = uintptr(o.chunkOverlap)
+	config.BatchSize = uintptr(o.batchSize)
+	config.Extensions = extsPtr
+	config.ExcludePatterns = excludePtr
+	config.Recursive = boolToInt(o.recursive)
+	config.IncludeHidden = boolToInt(o.includeHidden)
+	config.MaxFileSize = uintptr(o.maxFileSize)
 	config.Quiet = boolToInt(o.quiet)
 
 	var handle uintptr
@@ -92,16 +132,71 @@ func NewIndexer(model string, opts ...Option) (*Indexer, error) {
 	return &Indexer{handle: handle}, nil
 }
 
+// CreateOptions controls how Indexer.CreateWithOptions treats an existing
+// index at the target path.
+type CreateOptions struct {
+	// Force rebuilds any existing index at the path from scratch.
+	Force bool
+	// Append adds the inputs to an existing index at the path, as
+	// Indexer.Add does. If nothing exists at the path, a new index is built.
+	Append bool
+}
+
 // Create builds a new search index at indexPath from the given input directories.
 // Files are chunked, embedded, and stored for later retrieval with a Searcher.
 func (idx *Indexer) Create(indexPath string, inputs []string) (*IndexStats, error) {
-	idx.mu.Lock()
+	return idx.CreateWithOptionsContext(context.Background(), indexPath, inputs, CreateOptions{})
+}
+
+// CreateContext is like Create but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the indexer.
+func (idx *Indexer) CreateContext(ctx context.Context, indexPath string, inputs []string) (*IndexStats, error) {
+	return idx.CreateWithOptionsContext(ctx, indexPath, inputs, CreateOptions{})
+}
+
+// CreateWithOptions is like Create but can rebuild or append to an
+// existing index. Force and Append are mutually exclusive.
+func (idx *Indexer) CreateWithOptions(indexPath string, inputs []string, opts CreateOptions) (*IndexStats, error) {
+	return idx.CreateWithOptionsContext(context.Background(), indexPath, inputs, opts)
+}
+
+// CreateWithOptionsContext is like CreateWithOptions but returns early with
+// ErrCancelled or ErrTimeout if ctx is done while waiting for the indexer.
+func (idx *Indexer) CreateWithOptionsContext(ctx context.Context, indexPath string, inputs []string, opts CreateOptions) (*IndexStats, error) {
+	switch {
+	case opts.Force && opts.Append:
+		return nil, invalidConfig("Force and Append are mutually exclusive")
+	case len(inputs) == 0:
+		return nil, invalidConfig("no input paths given")
+	}
+
+	if err := idx.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer idx.mu.Unlock()
 
 	if idx.closed {
 		return nil, errors.New("indexer is closed")
 	}
 
+	if opts.Append {
+		_, err := os.Stat(indexPath)
+		switch {
+		case err == nil:
+			if _indexerAddSym == 0 {
+				return nil, unsupported("kjarni_indexer_add")
+			}
+			return idx.updatePaths(_indexerAddSym, indexPath, inputs)
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+	return idx.create(indexPath, inputs, opts.Force)
+}
+
+// create builds an index with a single engine call. The caller must hold
+// idx.mu.
+func (idx *Indexer) create(indexPath string, inputs []string, force bool) (*IndexStats, error) {
 	pathPtr, keepPath := cString(indexPath)
 	defer keepPath()
 
@@ -123,7 +218,7 @@ func (idx *Indexer) Create(indexPath string, inputs []string) (*IndexStats, erro
 		pathPtr,
 		uintptr(unsafe.Pointer(&cStrs[0])),
 		uintptr(len(inputs)),
-		0, // force = false
+		uintptr(boolToInt(force)),
 		uintptr(unsafe.Pointer(&stats)),
 	)
 
@@ -132,15 +227,166 @@ func (idx *Indexer) Create(indexPath string, inputs []string) (*IndexStats, erro
 		return nil, lastError(code)
 	}
 
-	return &IndexStats{
-		DocumentsIndexed: int(stats.DocumentsIndexed),
-		ChunksCreated:    int(stats.ChunksCreated),
-		Dimension:        int(stats.Dimension),
-		SizeBytes:        stats.SizeBytes,
-		FilesProcessed:   int(stats.FilesProcessed),
-		FilesSkipped:     int(stats.FilesSkipped),
-		ElapsedMs:        stats.ElapsedMs,
-	}, nil
+	return stats.toIndexStats(), nil
+}
+
+// Add updates the existing index at indexPath with files from the given
+// inputs and returns stats for the files the engine indexed. It needs an
+// engine that exports kjarni_indexer_add and returns ErrUnknown otherwise.
+func (idx *Indexer) Add(indexPath string, inputs []string) (*IndexStats, error) {
+	return idx.AddContext(context.Background(), indexPath, inputs)
+}
+
+// AddContext is like Add but returns early with ErrCancelled or ErrTimeout
+// if ctx is done while waiting for the indexer.
+func (idx *Indexer) AddContext(ctx context.Context, indexPath string, inputs []string) (*IndexStats, error) {
+	return idx.update(ctx, _indexerAddSym, "kjarni_indexer_add", indexPath, inputs)
+}
+
+// Remove deletes every chunk whose source path is one of sourcePaths from the
+// index at indexPath. In the returned stats, FilesProcessed counts the
+// sources that were removed. It needs an engine that exports
+// kjarni_indexer_remove and returns ErrUnknown otherwise.
+func (idx *Indexer) Remove(indexPath string, sourcePaths []string) (*IndexStats, error) {
+	return idx.RemoveContext(context.Background(), indexPath, sourcePaths)
+}
+
+// RemoveContext is like Remove but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the indexer.
+func (idx *Indexer) RemoveContext(ctx context.Context, indexPath string, sourcePaths []string) (*IndexStats, error) {
+	return idx.update(ctx, _indexerRemoveSym, "kjarni_indexer_remove", indexPath, sourcePaths)
+}
+
+// IndexDocuments passes the given documents to the engine to be indexed at
+// indexPath.
+//
+// IndexDocuments is experimental. It needs an engine that exports
+// kjarni_indexer_index_documents, which current engines do not, and returns
+// ErrUnknown otherwise. Its signature and behaviour may change.
+func (idx *Indexer) IndexDocuments(indexPath string, docs []Document) (*IndexStats, error) {
+	metas := make([]string, len(docs))
+	seen := make(map[string]bool, len(docs))
+	for i, d := range docs {
+		if d.ID == "" {
+			return nil, invalidConfig("document %d has an empty ID", i)
+		}
+		if seen[d.ID] {
+			return nil, invalidConfig("duplicate document ID %q", d.ID)
+		}
+		seen[d.ID] = true
+		if len(d.Metadata) > 0 {
+			b, err := json.Marshal(d.Metadata)
+			if err != nil {
+				return nil, fmt.Errorf("encoding metadata for document %q: %w", d.ID, err)
+			}
+			metas[i] = string(b)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.closed {
+		return nil, errors.New("indexer is closed")
+	}
+	if _indexerIndexDocumentsSym == 0 {
+		return nil, unsupported("kjarni_indexer_index_documents")
+	}
+	if len(docs) == 0 {
+		return &IndexStats{}, nil
+	}
+
+	pathPtr, keepPath := cString(indexPath)
+	defer keepPath()
+
+	cDocs := make([]ffiDocument, len(docs))
+	keeps := make([]func(), 0, 3*len(docs))
+	defer func() {
+		for _, k := range keeps {
+			k()
+		}
+	}()
+	for i, d := range docs {
+		var keep func()
+		cDocs[i].Id, keep = cString(d.ID)
+		keeps = append(keeps, keep)
+		cDocs[i].Text, keep = cString(d.Text)
+		keeps = append(keeps, keep)
+		cDocs[i].MetadataJson, keep = optionalCString(metas[i])
+		keeps = append(keeps, keep)
+	}
+
+	var stats ffiIndexStats
+	r1, _, _ := purego.SyscallN(
+		_indexerIndexDocumentsSym,
+		idx.handle,
+		pathPtr,
+		uintptr(unsafe.Pointer(&cDocs[0])),
+		uintptr(len(docs)),
+		uintptr(unsafe.Pointer(&stats)),
+	)
+
+	code := int32(r1)
+	if code != 0 {
+		return nil, lastError(code)
+	}
+
+	return stats.toIndexStats(), nil
+}
+
+// update calls an incremental indexing entry point that takes an index path
+// and a list of paths.
+func (idx *Indexer) update(ctx context.Context, sym uintptr, name string, indexPath string, paths []string) (*IndexStats, error) {
+	if err := idx.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
+	defer idx.mu.Unlock()
+
+	if idx.closed {
+		return nil, errors.New("indexer is closed")
+	}
+	if sym == 0 {
+		return nil, unsupported(name)
+	}
+	if len(paths) == 0 {
+		return &IndexStats{}, nil
+	}
+	return idx.updatePaths(sym, indexPath, paths)
+}
+
+// updatePaths makes a single call to an incremental indexing entry point.
+// The caller must hold idx.mu.
+func (idx *Indexer) updatePaths(sym uintptr, indexPath string, paths []string) (*IndexStats, error) {
+	pathPtr, keepPath := cString(indexPath)
+	defer keepPath()
+
+	cStrs := make([]uintptr, len(paths))
+	keeps := make([]func(), len(paths))
+	for i, s := range paths {
+		cStrs[i], keeps[i] = cString(s)
+	}
+	defer func() {
+		for _, k := range keeps {
+			k()
+		}
+	}()
+
+	var stats ffiIndexStats
+	r1, _, _ := purego.SyscallN(
+		sym,
+		idx.handle,
+		pathPtr,
+		uintptr(unsafe.Pointer(&cStrs[0])),
+		uintptr(len(paths)),
+		uintptr(unsafe.Pointer(&stats)),
+	)
+
+	code := int32(r1)
+	if code != 0 {
+		return nil, lastError(code)
+	}
+
+	return stats.toIndexStats(), nil
 }
 
 // Close releases the indexer resources. Safe to call multiple times.
@@ -155,4 +401,16 @@ func (idx *Indexer) Close() error {
 	_indexerFree(idx.handle)
 	idx.handle = 0
 	return nil
-}
\ No newline at end of file
+}
+
+func (stats ffiIndexStats) toIndexStats() *IndexStats {
+	return &IndexStats{
+		DocumentsIndexed: int(stats.DocumentsIndexed),
+		ChunksCreated:    int(stats.ChunksCreated),
+		Dimension:        int(stats.Dimension),
+		SizeBytes:        stats.SizeBytes,
+		FilesProcessed:   int(stats.FilesProcessed),
+		FilesSkipped:     int(stats.FilesSkipped),
+		ElapsedMs:        stats.ElapsedMs,
+	}
+}
@@ -0,0 +1,89 @@
+package kjarni
+
+import (
+	"context"
+	"testing"
+)
+
+func TestIndexerClosed(t *testing.T) {
+	idx := &Indexer{closed: true}
+	ctx := context.Background()
+	calls := []struct {
+		name string
+		call func() (*IndexStats, error)
+	}{
+		{"create", func() (*IndexStats, error) { return idx.Create("idx", []string{"docs"}) }},
+		{"append", func() (*IndexStats, error) {
+			return idx.CreateWithOptions("idx", []string{"docs"}, CreateOptions{Append: true})
+		}},
+		// a closed indexer is reported before missing symbols and empty inputs
+		{"add", func() (*IndexStats, error) { return idx.update(ctx, 0, "kjarni_indexer_add", "idx", nil) }},
+		{"remove", func() (*IndexStats, error) { return idx.update(ctx, 1, "kjarni_indexer_remove", "idx", nil) }},
+	}
+	for _, c := range calls {
+		if stats, err := c.call(); err == nil || err.Error() != "indexer is closed" {
+			t.Errorf("%s: got %v, %v", c.name, stats, err)
+		}
+	}
+}
+
+func TestIndexerCreateOptions(t *testing.T) {
+	idx := &Indexer{closed: true}
+	tests := []struct {
+		name   string
+		inputs []string
+		opts   CreateOptions
+	}{
+		{"force and append", []string{"docs"}, CreateOptions{Force: true, Append: true}},
+		{"no inputs", nil, CreateOptions{}},
+		{"no inputs to append", nil, CreateOptions{Append: true}},
+	}
+	for _, tt := range tests {
+		if _, err := idx.CreateWithOptions("idx", tt.inputs, tt.opts); errorCode(err) != ErrInvalidConfig {
+			t.Errorf("%s: got %v, want ErrInvalidConfig", tt.name, err)
+		}
+	}
+}
+
+func TestIndexerContext(t *testing.T) {
+	idx := &Indexer{}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := map[string]func() (*IndexStats, error){
+		"create": func() (*IndexStats, error) { return idx.CreateContext(ctx, "idx", []string{"docs"}) },
+		"add":    func() (*IndexStats, error) { return idx.AddContext(ctx, "idx", []string{"docs"}) },
+		"remove": func() (*IndexStats, error) { return idx.RemoveContext(ctx, "idx", []string{"docs"}) },
+	}
+	for name, call := range calls {
+		if _, err := call(); errorCode(err) != ErrCancelled {
+			t.Errorf("%s: got %v, want ErrCancelled", name, err)
+		}
+	}
+}
+
+func TestIndexDocumentsChecks(t *testing.T) {
+	tests := []struct {
+		name string
+		docs []Document
+		code ErrorCode
+	}{
+		{"empty ID", []Document{{ID: "a"}, {Text: "b"}}, ErrInvalidConfig},
+		{"duplicate ID", []Document{{ID: "a"}, {ID: "a"}}, ErrInvalidConfig},
+		{"bad metadata", []Document{{ID: "a", Metadata: map[string]any{"f": func() {}}}}, ErrOk},
+	}
+	idx := &Indexer{}
+	for _, tt := range tests {
+		_, err := idx.IndexDocuments("idx", tt.docs)
+		if err == nil || errorCode(err) != tt.code {
+			t.Errorf("%s: got %v, want code %d", tt.name, err, tt.code)
+		}
+	}
+
+	idx.closed = true
+	if _, err := idx.IndexDocuments("idx", nil); err == nil || err.Error() != "indexer is closed" {
+		t.Errorf("closed: got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+package kjarni
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// The JSON forms of the result types use snake_case field names that stay
+// stable even if the Go field names change. Each wire struct mirrors its
+// result type field for field so the two convert directly.
+
+type classifyResultJSON struct {
+	Label     string       `json:"label"`
+	Score     float32      `json:"score"`
+	AllScores []LabelScore `json:"predictions"`
+	Labels    []LabelScore `json:"labels,omitempty"`
+}
+
+// MarshalJSON encodes the result as
+// {"label", "score", "predictions", "labels"}, omitting labels outside
+// multi-label mode.
+func (r ClassifyResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(classifyResultJSON(r))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (r *ClassifyResult) UnmarshalJSON(data []byte) error {
+	var v classifyResultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = ClassifyResult(v)
+	return nil
+}
+
+type labelScoreJSON struct {
+	Label string  `json:"label"`
+	Score float32 `json:"score"`
+}
+
+// MarshalJSON encodes the score as {"label", "score"}.
+func (s LabelScore) MarshalJSON() ([]byte, error) {
+	return json.Marshal(labelScoreJSON(s))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (s *LabelScore) UnmarshalJSON(data []byte) error {
+	var v labelScoreJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = LabelScore(v)
+	return nil
+}
+
+type searchResultJSON struct {
+	Score       float32        `json:"score"`
+	Text        string         `json:"text"`
+	DocumentID  string         `json:"document_id,omitempty"`
+	Metadata    map[string]any `json:"metadata,omitempty"`
+	RawMetadata string         `json:"raw_metadata,omitempty"`
+}
+
+// MarshalJSON encodes the result as
+// {"score", "text", "document_id", "metadata"}, omitting empty fields.
+// RawMetadata is included as "raw_metadata" only when it could not be
+// parsed into Metadata.
+func (r SearchResult) MarshalJSON() ([]byte, error) {
+	v := searchResultJSON(r)
+	if v.Metadata != nil {
+		v.RawMetadata = ""
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON. Numeric metadata
+// values decode as float64, as they do from the engine.
+func (r *SearchResult) UnmarshalJSON(data []byte) error {
+	var v searchResultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = SearchResult(v)
+	return nil
+}
+
+type rerankResultJSON struct {
+	Index    int     `json:"index"`
+	Score    float32 `json:"score"`
+	Document string  `json:"document"`
+}
+
+// MarshalJSON encodes the result as {"index", "score", "document"}.
+func (r RerankResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rerankResultJSON(r))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (r *RerankResult) UnmarshalJSON(data []byte) error {
+	var v rerankResultJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*r = RerankResult(v)
+	return nil
+}
+
+type indexStatsJSON struct {
+	DocumentsIndexed int    `json:"documents_indexed"`
+	ChunksCreated    int    `json:"chunks_created"`
+	Dimension        int    `json:"dimension"`
+	SizeBytes        uint64 `json:"size_bytes"`
+	FilesProcessed   int    `json:"files_processed"`
+	FilesSkipped     int    `json:"files_skipped"`
+	ElapsedMs        uint64 `json:"elapsed_ms"`
+}
+
+// MarshalJSON encodes the stats with snake_case field names, such as
+// "documents_indexed" and "elapsed_ms".
+func (s IndexStats) MarshalJSON() ([]byte, error) {
+	return json.Marshal(indexStatsJSON(s))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (s *IndexStats) UnmarshalJSON(data []byte) error {
+	var v indexStatsJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = IndexStats(v)
+	return nil
+}
+
+type vectorMatchJSON struct {
+	ID       string         `json:"id"`
+	Score    float32        `json:"score"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// MarshalJSON encodes the match as {"id", "score", "metadata"}, omitting
+// empty metadata.
+func (m VectorMatch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(vectorMatchJSON(m))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (m *VectorMatch) UnmarshalJSON(data []byte) error {
+	var v vectorMatchJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*m = VectorMatch(v)
+	return nil
+}
+
+type chunkJSON struct {
+	Index   int    `json:"index"`
+	Text    string `json:"text"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Heading string `json:"heading,omitempty"`
+}
+
+// MarshalJSON encodes the chunk as {"index", "text", "start", "end",
+// "heading"}, omitting an empty heading.
+func (c Chunk) MarshalJSON() ([]byte, error) {
+	return json.Marshal(chunkJSON(c))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (c *Chunk) UnmarshalJSON(data []byte) error {
+	var v chunkJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*c = Chunk(v)
+	return nil
+}
+
+type tokenJSON struct {
+	ID      uint32 `json:"id"`
+	Start   int    `json:"start"`
+	End     int    `json:"end"`
+	Special bool   `json:"special"`
+}
+
+// MarshalJSON encodes the token as {"id", "start", "end", "special"}.
+func (t Token) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tokenJSON(t))
+}
+
+// UnmarshalJSON decodes the form written by MarshalJSON.
+func (t *Token) UnmarshalJSON(data []byte) error {
+	var v tokenJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*t = Token(v)
+	return nil
+}
+
+var searchModeNames = [...]string{
+	Keyword:  "keyword",
+	Semantic: "semantic",
+	Hybrid:   "hybrid",
+}
+
+// String returns "keyword", "semantic" or "hybrid".
+func (m SearchMode) String() string {
+	if m >= 0 && int(m) < len(searchModeNames) {
+		return searchModeNames[m]
+	}
+	return fmt.Sprintf("SearchMode(%d)", int(m))
+}
+
+// MarshalText encodes the mode as its name, so it appears in JSON as
+// "keyword", "semantic" or "hybrid".
+func (m SearchMode) MarshalText() ([]byte, error) {
+	if m < 0 || int(m) >= len(searchModeNames) {
+		return nil, fmt.Errorf("invalid search mode %d", int(m))
+	}
+	return []byte(searchModeNames[m]), nil
+}
+
+// UnmarshalText parses a mode name written by MarshalText.
+func (m *SearchMode) UnmarshalText(text []byte) error {
+	for i, name := range searchModeNames {
+		if string(text) == name {
+			*m = SearchMode(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown search mode %q, expected keyword, semantic or hybrid", text)
+}
@@ -0,0 +1,171 @@
+package kjarni
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestResultJSON(t *testing.T) {
+	const label = `say "hi" \ now`
+	tests := []struct {
+		name string
+		in   any
+		out  any // decoded value, which must equal want
+		want any
+		json string
+	}{
+		{
+			name: "classify result",
+			in:   &ClassifyResult{Label: label, Score: 0.5, AllScores: []LabelScore{{label, 0.5}, {"b", 0.25}}},
+			out:  &ClassifyResult{},
+			json: `{"label":"say \"hi\" \\ now","score":0.5,"predictions":[{"label":"say \"hi\" \\ now","score":0.5},{"label":"b","score":0.25}]}`,
+		},
+		{
+			name: "multi-label result",
+			in:   &ClassifyResult{Label: "a", Score: 1, AllScores: []LabelScore{{"a", 1}}, Labels: []LabelScore{{"a", 1}}},
+			out:  &ClassifyResult{},
+			json: `{"label":"a","score":1,"predictions":[{"label":"a","score":1}],"labels":[{"label":"a","score":1}]}`,
+		},
+		{
+			name: "label score",
+			in:   &LabelScore{Label: "\\\"\n", Score: 0.125},
+			out:  &LabelScore{},
+			json: `{"label":"\\\"\n","score":0.125}`,
+		},
+		{
+			name: "search result",
+			in: &SearchResult{Score: 0.75, Text: "t", DocumentID: "d",
+				Metadata: map[string]any{"page": float64(3), "tags": []any{"a"}}, RawMetadata: `{"page":3,"tags":["a"]}`},
+			out:  &SearchResult{},
+			want: &SearchResult{Score: 0.75, Text: "t", DocumentID: "d", Metadata: map[string]any{"page": float64(3), "tags": []any{"a"}}},
+			json: `{"score":0.75,"text":"t","document_id":"d","metadata":{"page":3,"tags":["a"]}}`,
+		},
+		{
+			name: "search result with unparsed metadata",
+			in:   &SearchResult{Score: 1, Text: "t", RawMetadata: "{bad"},
+			out:  &SearchResult{},
+			json: `{"score":1,"text":"t","raw_metadata":"{bad"}`,
+		},
+		{
+			name: "rerank result",
+			in:   &RerankResult{Index: 2, Score: -1.5, Document: label},
+			out:  &RerankResult{},
+			json: `{"index":2,"score":-1.5,"document":"say \"hi\" \\ now"}`,
+		},
+		{
+			name: "index stats",
+			in:   &IndexStats{DocumentsIndexed: 1, ChunksCreated: 2, Dimension: 384, SizeBytes: 1 << 40, FilesProcessed: 3, FilesSkipped: 4, ElapsedMs: 5},
+			out:  &IndexStats{},
+			json: `{"documents_indexed":1,"chunks_created":2,"dimension":384,"size_bytes":1099511627776,"files_processed":3,"files_skipped":4,"elapsed_ms":5}`,
+		},
+		{
+			name: "vector match",
+			in:   &VectorMatch{ID: "a", Score: 0.5, Metadata: map[string]any{"lang": "en"}},
+			out:  &VectorMatch{},
+			json: `{"id":"a","score":0.5,"metadata":{"lang":"en"}}`,
+		},
+		{
+			name: "vector match without metadata",
+			in:   &VectorMatch{ID: "a", Score: 0.5},
+			out:  &VectorMatch{},
+			json: `{"id":"a","score":0.5}`,
+		},
+		{
+			name: "chunk",
+			in:   &Chunk{Index: 1, Text: label, Start: 4, End: 18, Heading: "Usage"},
+			out:  &Chunk{},
+			json: `{"index":1,"text":"say \"hi\" \\ now","start":4,"end":18,"heading":"Usage"}`,
+		},
+		{
+			name: "chunk without heading",
+			in:   &Chunk{Text: "t", End: 1},
+			out:  &Chunk{},
+			json: `{"index":0,"text":"t","start":0,"end":1}`,
+		},
+		{
+			name: "token",
+			in:   &Token{ID: 2023, Start: 6, End: 9},
+			out:  &Token{},
+			json: `{"id":2023,"start":6,"end":9,"special":false}`,
+		},
+		{
+			name: "special token",
+			in:   &Token{ID: 101, Special: true},
+			out:  &Token{},
+			json: `{"id":101,"start":0,"end":0,"special":true}`,
+		},
+	}
+	for _, tt := range tests {
+		// both the value and a pointer to it encode the same way
+		for _, v := range []any{tt.in, reflect.ValueOf(tt.in).Elem().Interface()} {
+			b, err := json.Marshal(v)
+			if err != nil || string(b) != tt.json {
+				t.Errorf("%s: got %s, %v\nwant %s", tt.name, b, err, tt.json)
+			}
+		}
+		if err := json.Unmarshal([]byte(tt.json), tt.out); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := tt.want
+		if want == nil {
+			want = tt.in
+		}
+		if !reflect.DeepEqual(tt.out, want) {
+			t.Errorf("%s: decoded %+v, want %+v", tt.name, tt.out, want)
+		}
+	}
+}
+
+func TestSearchResultJSONNumbers(t *testing.T) {
+	var r SearchResult
+	data := `{"score":1,"text":"t","metadata":{"chunk_index":3,"size":12345678901,"ratio":0.5}}`
+	if err := json.Unmarshal([]byte(data), &r); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{"chunk_index": float64(3), "size": float64(12345678901), "ratio": 0.5}
+	if !reflect.DeepEqual(r.Metadata, want) {
+		t.Errorf("metadata %#v, want %#v", r.Metadata, want)
+	}
+	// the accessors accept the decoded numbers
+	if i, ok := r.ChunkIndex(); !ok || i != 3 {
+		t.Errorf("ChunkIndex() = %d, %v", i, ok)
+	}
+}
+
+func TestSearchModeText(t *testing.T) {
+	for _, m := range []SearchMode{Keyword, Semantic, Hybrid} {
+		b, err := json.Marshal(map[string]SearchMode{"mode": m})
+		if err != nil || string(b) != `{"mode":"`+m.String()+`"}` {
+			t.Errorf("%v: got %s, %v", m, b, err)
+		}
+		var got map[string]SearchMode
+		if err := json.Unmarshal(b, &got); err != nil || got["mode"] != m {
+			t.Errorf("%v: decoded %v, %v", m, got, err)
+		}
+	}
+
+	for _, m := range []SearchMode{-1, Hybrid + 1} {
+		if _, err := m.MarshalText(); err == nil {
+			t.Errorf("%d: MarshalText succeeded", int(m))
+		}
+		if _, err := json.Marshal(m); err == nil {
+			t.Errorf("%d: json.Marshal succeeded", int(m))
+		}
+	}
+	if got := SearchMode(7).String(); got != "SearchMode(7)" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, text := range []string{"", "Hybrid", "fuzzy", `hybrid"`} {
+		m := Semantic
+		if err := m.UnmarshalText([]byte(text)); err == nil || m != Semantic {
+			t.Errorf("%q: got %v, %v", text, m, err)
+		}
+	}
+	var m SearchMode
+	if err := json.Unmarshal([]byte(`3`), &m); err == nil {
+		t.Errorf("number decoded as %v", m)
+	}
+}
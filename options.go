@@ -1,8 +1,41 @@
 package kjarni
 
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// cacheDirEnv names the environment variable used as the default cache directory.
+const cacheDirEnv = "KJARNI_CACHE_DIR"
+
 type options struct {
-	quiet  bool
-	device string // "cpu" || "gpu"
+	quiet     bool
+	device    string // "cpu" || "gpu"
+	cacheDir  string
+	modelPath string
+	truncate  bool
+
+	// embedder
+	normalize bool
+
+	// classifier
+	labels         []string
+	multiLabel     bool
+	labelThreshold float32
+
+	// indexer
+	chunkSize       int
+	chunkOverlap    int
+	batchSize       int
+	extensions      []string
+	excludePatterns []string
+	recursive       bool
+	includeHidden   bool
+	maxFileSize     int64
+
+	// chunker
+	lengthFunc func(string) (int, error)
 }
 
 // Option configures a classifier, embedder, or other kjarni component.
@@ -22,9 +55,161 @@ func WithDevice(device string) Option {
 	}
 }
 
+// WithCacheDir sets the directory models are downloaded to and loaded from.
+// Defaults to the KJARNI_CACHE_DIR environment variable, or the engine's
+// default cache location if that is unset.
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithModelPath loads the model from a local directory instead of the cache,
+// so no network access is needed. Applies to classifiers, embedders and
+// rerankers; NewIndexer and NewSearcher reject it because the engine has no
+// local path for their models.
+func WithModelPath(path string) Option {
+	return func(o *options) {
+		o.modelPath = path
+	}
+}
+
+// WithTruncation controls what happens to inputs longer than the model's
+// maximum sequence length. By default the engine silently truncates them.
+// With truncation off, Embedder, Classifier and Reranker calls reject them
+// with an *InputTooLongError, which has code ErrInputTooLong, instead; this
+// needs an engine that exposes its tokenizer. Inputs are checked one
+// sub-batch at a time just before they are encoded, and the check stops
+// early if the call's context is done.
+func WithTruncation(truncate bool) Option {
+	return func(o *options) {
+		o.truncate = truncate
+	}
+}
+
+// WithNormalize controls whether an Embedder scales vectors to unit length.
+// Defaults to true. With normalization off, vectors keep their raw magnitudes,
+// so a dot product no longer equals cosine similarity. CosineSimilarity is
+// unaffected because it divides by both norms.
+func WithNormalize(normalize bool) Option {
+	return func(o *options) {
+		o.normalize = normalize
+	}
+}
+
+// WithLabels overrides the label names reported by a Classifier. The number
+// of labels must match the model's output size. The slice is copied.
+func WithLabels(labels []string) Option {
+	labels = append([]string(nil), labels...)
+	return func(o *options) {
+		o.labels = labels
+	}
+}
+
+// WithMultiLabel treats classifier outputs as independent sigmoid scores
+// instead of a softmax distribution, as used by models such as toxic-bert.
+// ClassifyResult.Labels then holds every label scoring at or above the
+// threshold set with WithLabelThreshold.
+func WithMultiLabel(multiLabel bool) Option {
+	return func(o *options) {
+		o.multiLabel = multiLabel
+	}
+}
+
+// WithLabelThreshold sets the minimum score for a label to be reported in
+// multi-label mode. Must be between 0 and 1. Defaults to 0.5.
+func WithLabelThreshold(threshold float32) Option {
+	return func(o *options) {
+		o.labelThreshold = threshold
+	}
+}
+
+// WithChunkSize sets the maximum chunk size in characters used by an Indexer
+// or Chunker. Defaults to 512.
+func WithChunkSize(size int) Option {
+	return func(o *options) {
+		o.chunkSize = size
+	}
+}
+
+// WithChunkOverlap sets the number of characters shared between consecutive
+// chunks produced by an Indexer or Chunker. Must be smaller than the chunk
+// size. Defaults to 50.
+func WithChunkOverlap(overlap int) Option {
+	return func(o *options) {
+		o.chunkOverlap = overlap
+	}
+}
+
+// WithBatchSize sets how many chunks an Indexer embeds per model call, and
+// how many texts Classifier.ClassifyBatchContext classifies between checks
+// of its context. Defaults to 32.
+func WithBatchSize(size int) Option {
+	return func(o *options) {
+		o.batchSize = size
+	}
+}
+
+// WithExtensions restricts an Indexer to files with the given extensions,
+// e.g. "md", ".go" or "*.txt". By default all supported files are indexed.
+func WithExtensions(exts ...string) Option {
+	return func(o *options) {
+		o.extensions = append(o.extensions, exts...)
+	}
+}
+
+// WithExcludePatterns skips files and directories matching the given glob
+// patterns during indexing, e.g. "vendor/**" or "node_modules".
+func WithExcludePatterns(patterns ...string) Option {
+	return func(o *options) {
+		o.excludePatterns = append(o.excludePatterns, patterns...)
+	}
+}
+
+// WithRecursive controls whether an Indexer descends into subdirectories.
+// Defaults to true.
+func WithRecursive(recursive bool) Option {
+	return func(o *options) {
+		o.recursive = recursive
+	}
+}
+
+// WithHidden controls whether an Indexer includes hidden files and directories.
+// Defaults to false.
+func WithHidden(include bool) Option {
+	return func(o *options) {
+		o.includeHidden = include
+	}
+}
+
+// WithMaxFileSize skips files larger than the given number of bytes during
+// indexing. Zero means no limit.
+func WithMaxFileSize(bytes int64) Option {
+	return func(o *options) {
+		o.maxFileSize = bytes
+	}
+}
+
+// WithLengthFunc sets how a Chunker measures text against the chunk size
+// and overlap, e.g. a model's token count such as Embedder.CountTokens.
+// Defaults to counting characters. An error from length fails the Split.
+func WithLengthFunc(length func(string) (int, error)) Option {
+	return func(o *options) {
+		o.lengthFunc = length
+	}
+}
+
 func applyOptions(opts []Option) options {
 	o := options{
-		device: "cpu",
+		device:         "cpu",
+		truncate:       true,
+		cacheDir:       os.Getenv(cacheDirEnv),
+		normalize:      true,
+		labelThreshold: 0.5,
+		chunkSize:      512,
+		chunkOverlap:   50,
+		batchSize:      32,
+		recursive:      true,
 	}
 	for _, opt := range opts {
 		opt(&o)
@@ -32,6 +217,81 @@ func applyOptions(opts []Option) options {
 	return o
 }
 
+func (o *options) validateClassifier() error {
+	if o.batchSize <= 0 {
+		return invalidConfig("batch size must be positive, got %d", o.batchSize)
+	}
+	if !(o.labelThreshold >= 0 && o.labelThreshold <= 1) {
+		return invalidConfig("label threshold must be between 0 and 1, got %g", o.labelThreshold)
+	}
+	for i, l := range o.labels {
+		if l == "" {
+			return invalidConfig("label %d must not be empty", i)
+		}
+	}
+	return nil
+}
+
+func (o *options) validateChunker() error {
+	switch {
+	case o.chunkSize <= 0:
+		return invalidConfig("chunk size must be positive, got %d", o.chunkSize)
+	case o.chunkOverlap < 0:
+		return invalidConfig("chunk overlap must not be negative, got %d", o.chunkOverlap)
+	case o.chunkOverlap >= o.chunkSize:
+		return invalidConfig("chunk overlap (%d) must be smaller than chunk size (%d)", o.chunkOverlap, o.chunkSize)
+	}
+	return nil
+}
+
+func (o *options) validateIndexer() error {
+	if err := o.validateChunker(); err != nil {
+		return err
+	}
+	switch {
+	case o.batchSize <= 0:
+		return invalidConfig("batch size must be positive, got %d", o.batchSize)
+	case o.maxFileSize < 0:
+		return invalidConfig("max file size must not be negative, got %d", o.maxFileSize)
+	case o.modelPath != "":
+		return invalidConfig("the indexer does not support WithModelPath")
+	}
+	for _, p := range o.excludePatterns {
+		if p == "" {
+			return invalidConfig("exclude pattern must not be empty")
+		}
+	}
+	return nil
+}
+
+func (o *options) validateSearcher() error {
+	if o.modelPath != "" {
+		return invalidConfig("the searcher does not support WithModelPath")
+	}
+	return nil
+}
+
+// normalizeExtensions strips glob and dot prefixes so "*.md", ".md" and "md"
+// are all passed to the engine as "md".
+func normalizeExtensions(exts []string) ([]string, error) {
+	out := make([]string, 0, len(exts))
+	for _, e := range exts {
+		e = strings.TrimPrefix(strings.TrimPrefix(e, "*"), ".")
+		if e == "" {
+			return nil, invalidConfig("extension must not be empty")
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func invalidConfig(format string, args ...any) error {
+	return &KjarniError{
+		Code:    ErrInvalidConfig,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
 func deviceCode(device string) int32 {
 	if device == "gpu" {
 		return 1
@@ -44,4 +304,4 @@ func boolToInt(b bool) int32 {
 		return 1
 	}
 	return 0
-}
\ No newline at end of file
+}
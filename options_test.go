@@ -0,0 +1,62 @@
+package kjarni
+
+import (
+	"math"
+	"testing"
+)
+
+func TestModelPathUnsupported(t *testing.T) {
+	tests := []struct {
+		name     string
+		validate func(*options) error
+	}{
+		{"indexer", (*options).validateIndexer},
+		{"searcher", (*options).validateSearcher},
+	}
+	for _, tt := range tests {
+		o := applyOptions(nil)
+		if err := tt.validate(&o); err != nil {
+			t.Errorf("%s: defaults rejected: %v", tt.name, err)
+		}
+		o = applyOptions([]Option{WithModelPath("/models/minilm")})
+		if err := tt.validate(&o); errorCode(err) != ErrInvalidConfig {
+			t.Errorf("%s: WithModelPath: got %v, want ErrInvalidConfig", tt.name, err)
+		}
+	}
+}
+
+func TestValidateClassifier(t *testing.T) {
+	nan := float32(math.NaN())
+	tests := []struct {
+		opts []Option
+		ok   bool
+	}{
+		{nil, true},
+		{[]Option{WithLabelThreshold(0)}, true},
+		{[]Option{WithLabelThreshold(1)}, true},
+		{[]Option{WithLabelThreshold(-0.1)}, false},
+		{[]Option{WithLabelThreshold(1.1)}, false},
+		{[]Option{WithLabelThreshold(nan)}, false},
+		{[]Option{WithLabels([]string{"pos", "neg"})}, true},
+		{[]Option{WithLabels([]string{"pos", ""})}, false},
+	}
+	for i, tt := range tests {
+		o := applyOptions(tt.opts)
+		err := o.validateClassifier()
+		if tt.ok != (err == nil) {
+			t.Errorf("case %d: error %v", i, err)
+		}
+		if err != nil && errorCode(err) != ErrInvalidConfig {
+			t.Errorf("case %d: got %v, want ErrInvalidConfig", i, err)
+		}
+	}
+}
+
+func TestWithLabelsCopies(t *testing.T) {
+	labels := []string{"pos", "neg"}
+	opt := WithLabels(labels)
+	labels[0] = "changed"
+	if o := applyOptions([]Option{opt}); o.labels[0] != "pos" {
+		t.Errorf("labels follow the caller's slice: %q", o.labels)
+	}
+}
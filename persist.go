@@ -0,0 +1,279 @@
+package kjarni
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// ErrModelMismatch is returned by LoadVectorStore when the saved vectors were
+// produced by a different model, dimension or normalization setting than the
+// given embedder.
+var ErrModelMismatch = errors.New("kjarni: embedder does not match saved vectors")
+
+// ErrCorrupt is returned by LoadVectorStore when the input is not a valid
+// vector store file or its checksum does not match.
+var ErrCorrupt = errors.New("kjarni: corrupt vector store file")
+
+const (
+	storeMagic   = "KJVS"
+	storeVersion = 1
+
+	maxStoreDim      = 1 << 16
+	maxStoreIDLen    = 1 << 20
+	maxStoreMetaLen  = 1 << 26
+	maxStoreModelLen = 1 << 10
+)
+
+var storeCRC = crc32.MakeTable(crc32.Castagnoli)
+
+// Save writes the store to w in the vector store file format. All integers
+// are little-endian:
+//
+//	magic       [4]byte  "KJVS"
+//	version     uint16   currently 1
+//	metric      uint8    Metric
+//	normalized  uint8    1 if vectors came from a normalizing embedder
+//	dim         uint32
+//	count       uint64
+//	modelLen    uint16
+//	model       [modelLen]byte
+//	count times:
+//	  idLen     uint32
+//	  id        [idLen]byte
+//	  vector    [dim]float32
+//	  metaLen   uint32   0 if there is no metadata
+//	  metadata  [metaLen]byte, a JSON object
+//	checksum    uint32   CRC-32C of every preceding byte
+//
+// Metadata values must be encodable as JSON and come back from Load as the
+// types encoding/json produces (float64 for numbers, and so on). Save fails
+// before writing anything if an ID is over 1 MiB or an entry's metadata
+// encodes to more than 64 MiB, since LoadVectorStore would reject the file.
+func (s *VectorStore) Save(w io.Writer) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.model) > maxStoreModelLen {
+		return fmt.Errorf("model name too long: %d bytes", len(s.model))
+	}
+	// check everything LoadVectorStore will before writing, so a store is
+	// never saved in a form that cannot be read back
+	metas := make([][]byte, len(s.ids))
+	for i, id := range s.ids {
+		if len(id) > maxStoreIDLen {
+			return fmt.Errorf("id %d is %d bytes, the limit is %d", i, len(id), maxStoreIDLen)
+		}
+		if len(s.metadata[i]) == 0 {
+			continue
+		}
+		b, err := json.Marshal(s.metadata[i])
+		if err != nil {
+			return fmt.Errorf("encoding metadata for %q: %w", id, err)
+		}
+		if len(b) > maxStoreMetaLen {
+			return fmt.Errorf("metadata for %q is %d bytes, the limit is %d", id, len(b), maxStoreMetaLen)
+		}
+		metas[i] = b
+	}
+
+	bw := bufio.NewWriter(w)
+	crc := crc32.New(storeCRC)
+	sw := &storeWriter{w: io.MultiWriter(bw, crc)}
+
+	sw.bytes([]byte(storeMagic))
+	sw.u16(storeVersion)
+	sw.u8(uint8(s.metric))
+	sw.u8(uint8(boolToInt(s.normed)))
+	sw.u32(uint32(s.dim))
+	sw.u64(uint64(len(s.ids)))
+	sw.u16(uint16(len(s.model)))
+	sw.bytes([]byte(s.model))
+
+	for i, id := range s.ids {
+		sw.u32(uint32(len(id)))
+		sw.bytes([]byte(id))
+		for _, x := range s.vectors[i] {
+			sw.u32(math.Float32bits(x))
+		}
+		sw.u32(uint32(len(metas[i])))
+		sw.bytes(metas[i])
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+
+	if err := binary.Write(bw, binary.LittleEndian, crc.Sum32()); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// LoadVectorStore reads a store written by VectorStore.Save. If embedder is
+// non-nil it is attached to the returned store, and loading fails with
+// ErrModelMismatch unless its model name, dimension and normalization match
+// those recorded in the file. Pass a nil embedder to load vectors for use
+// with Search only.
+//
+// Only the model name is compared, not the weights: a different model loaded
+// under the same name with WithModelPath is not detected.
+func LoadVectorStore(r io.Reader, embedder *Embedder) (*VectorStore, error) {
+	crc := crc32.New(storeCRC)
+	br := bufio.NewReader(r)
+	sr := &storeReader{r: io.TeeReader(br, crc)}
+
+	if string(sr.bytes(4)) != storeMagic {
+		return nil, fmt.Errorf("%w: bad magic", ErrCorrupt)
+	}
+	if v := sr.u16(); sr.err == nil && v != storeVersion {
+		return nil, fmt.Errorf("unsupported vector store version %d", v)
+	}
+	metric := Metric(sr.u8())
+	normed := sr.u8() != 0
+	dim := int(sr.u32())
+	count := sr.u64()
+	model := string(sr.bytes(int(sr.u16())))
+	if sr.err != nil {
+		return nil, sr.fail()
+	}
+	if dim > maxStoreDim {
+		return nil, fmt.Errorf("%w: dimension %d", ErrCorrupt, dim)
+	}
+	if !metric.valid() {
+		return nil, fmt.Errorf("%w: unknown metric %d", ErrCorrupt, int(metric))
+	}
+
+	if embedder != nil {
+		if embedder.Model() != model || embedder.Dim() != dim || embedder.Normalized() != normed {
+			return nil, fmt.Errorf("%w: file has model %q (%dd, normalized=%t), embedder has %q (%dd, normalized=%t)",
+				ErrModelMismatch, model, dim, normed, embedder.Model(), embedder.Dim(), embedder.Normalized())
+		}
+	}
+
+	s := &VectorStore{
+		metric:   metric,
+		embedder: embedder,
+		model:    model,
+		normed:   normed,
+		dim:      dim,
+		pos:      make(map[string]int),
+	}
+	for i := uint64(0); i < count; i++ {
+		idLen := sr.u32()
+		if idLen > maxStoreIDLen {
+			return nil, fmt.Errorf("%w: id length %d", ErrCorrupt, idLen)
+		}
+		id := string(sr.bytes(int(idLen)))
+		vec := make([]float32, dim)
+		for j := range vec {
+			vec[j] = math.Float32frombits(sr.u32())
+		}
+		metaLen := sr.u32()
+		if metaLen > maxStoreMetaLen {
+			return nil, fmt.Errorf("%w: metadata length %d", ErrCorrupt, metaLen)
+		}
+		raw := sr.bytes(int(metaLen))
+		if sr.err != nil {
+			return nil, sr.fail()
+		}
+
+		var meta map[string]any
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &meta); err != nil {
+				return nil, fmt.Errorf("%w: metadata for %q: %v", ErrCorrupt, id, err)
+			}
+		}
+		if _, dup := s.pos[id]; dup {
+			return nil, fmt.Errorf("%w: duplicate id %q", ErrCorrupt, id)
+		}
+		s.pos[id] = len(s.ids)
+		s.ids = append(s.ids, id)
+		s.vectors = append(s.vectors, vec)
+		s.metadata = append(s.metadata, meta)
+	}
+
+	want := crc.Sum32()
+	var got uint32
+	if err := binary.Read(br, binary.LittleEndian, &got); err != nil {
+		return nil, fmt.Errorf("%w: missing checksum", ErrCorrupt)
+	}
+	if got != want {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return s, nil
+}
+
+// storeWriter writes little-endian values, remembering the first error.
+type storeWriter struct {
+	w   io.Writer
+	err error
+	buf [8]byte
+}
+
+func (w *storeWriter) bytes(b []byte) {
+	if w.err == nil {
+		_, w.err = w.w.Write(b)
+	}
+}
+
+func (w *storeWriter) u8(v uint8) {
+	w.buf[0] = v
+	w.bytes(w.buf[:1])
+}
+
+func (w *storeWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[:], v)
+	w.bytes(w.buf[:2])
+}
+
+func (w *storeWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:], v)
+	w.bytes(w.buf[:4])
+}
+
+func (w *storeWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.bytes(w.buf[:8])
+}
+
+// storeReader reads little-endian values, remembering the first error.
+type storeReader struct {
+	r   io.Reader
+	err error
+	buf [8]byte
+}
+
+func (r *storeReader) bytes(n int) []byte {
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	_, r.err = io.ReadFull(r.r, b)
+	return b
+}
+
+func (r *storeReader) fixed(n int) []byte {
+	if r.err != nil {
+		return r.buf[:n]
+	}
+	_, r.err = io.ReadFull(r.r, r.buf[:n])
+	return r.buf[:n]
+}
+
+func (r *storeReader) u8() uint8   { return r.fixed(1)[0] }
+func (r *storeReader) u16() uint16 { return binary.LittleEndian.Uint16(r.fixed(2)) }
+func (r *storeReader) u32() uint32 { return binary.LittleEndian.Uint32(r.fixed(4)) }
+func (r *storeReader) u64() uint64 { return binary.LittleEndian.Uint64(r.fixed(8)) }
+
+// fail wraps a read error, treating truncation as corruption.
+func (r *storeReader) fail() error {
+	if errors.Is(r.err, io.EOF) || errors.Is(r.err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of file", ErrCorrupt)
+	}
+	return r.err
+}
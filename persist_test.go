@@ -0,0 +1,138 @@
+package kjarni
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T, metric Metric) *VectorStore {
+	t.Helper()
+	s, err := NewVectorStore(metric, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.model = "test-model"
+	s.normed = true
+	entries := []struct {
+		id   string
+		vec  []float32
+		meta map[string]any
+	}{
+		{"a", []float32{1, 0, 0}, map[string]any{"lang": "en", "n": 1.5}},
+		{"b", []float32{0, -1, 0.25}, nil},
+		{"ünïcode", []float32{0.5, 0.5, 0.5}, map[string]any{"tags": []any{"x", "y"}}},
+	}
+	for _, e := range entries {
+		if err := s.Add(e.id, e.vec, e.meta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func saved(t *testing.T, s *VectorStore) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVectorStoreRoundTrip(t *testing.T) {
+	for _, metric := range []Metric{MetricCosine, MetricDot, MetricL2} {
+		s := testStore(t, metric)
+		got, err := LoadVectorStore(bytes.NewReader(saved(t, s)), nil)
+		if err != nil {
+			t.Fatalf("metric %d: %v", metric, err)
+		}
+		if got.metric != s.metric || got.model != s.model || got.normed != s.normed || got.dim != s.dim {
+			t.Errorf("metric %d: header mismatch: got %v %q %t %d", metric, got.metric, got.model, got.normed, got.dim)
+		}
+		if !reflect.DeepEqual(got.ids, s.ids) || !reflect.DeepEqual(got.vectors, s.vectors) ||
+			!reflect.DeepEqual(got.metadata, s.metadata) || !reflect.DeepEqual(got.pos, s.pos) {
+			t.Errorf("metric %d: entries differ after round trip", metric)
+		}
+	}
+
+	empty, _ := NewVectorStore(MetricCosine, nil)
+	got, err := LoadVectorStore(bytes.NewReader(saved(t, empty)), nil)
+	if err != nil || got.Len() != 0 {
+		t.Errorf("empty store: Len()=%d, err=%v", got.Len(), err)
+	}
+}
+
+func TestLoadVectorStoreCorrupt(t *testing.T) {
+	data := saved(t, testStore(t, MetricCosine))
+
+	tests := []struct {
+		name   string
+		mutate func([]byte) []byte
+	}{
+		{"bad magic", func(b []byte) []byte { b[0] = 'X'; return b }},
+		{"flipped vector byte", func(b []byte) []byte { b[len(b)/2] ^= 0x40; return b }},
+		{"flipped checksum", func(b []byte) []byte { b[len(b)-1] ^= 1; return b }},
+		{"truncated header", func(b []byte) []byte { return b[:10] }},
+		{"truncated body", func(b []byte) []byte { return b[:len(b)-20] }},
+		{"missing checksum", func(b []byte) []byte { return b[:len(b)-4] }},
+		{"empty", func(b []byte) []byte { return nil }},
+		{"unknown metric", func(b []byte) []byte { b[6] = 9; return b }},
+		{"huge dimension", func(b []byte) []byte { b[8], b[9], b[10] = 0xff, 0xff, 0xff; return b }},
+	}
+	for _, tt := range tests {
+		b := tt.mutate(append([]byte(nil), data...))
+		_, err := LoadVectorStore(bytes.NewReader(b), nil)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("%s: got %v, want ErrCorrupt", tt.name, err)
+		}
+	}
+
+	// every single-bit flip must be detected
+	for i := range data {
+		b := append([]byte(nil), data...)
+		b[i] ^= 1
+		if _, err := LoadVectorStore(bytes.NewReader(b), nil); err == nil {
+			t.Fatalf("flip at byte %d not detected", i)
+		}
+	}
+}
+
+func TestLoadVectorStoreVersion(t *testing.T) {
+	data := saved(t, testStore(t, MetricCosine))
+	data[4] = 2
+	_, err := LoadVectorStore(bytes.NewReader(data), nil)
+	if err == nil || !strings.Contains(err.Error(), "version") {
+		t.Errorf("got %v, want unsupported version", err)
+	}
+}
+
+func TestSaveRejectsUnloadable(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(s *VectorStore)
+	}{
+		{"long id", func(s *VectorStore) {
+			s.put(strings.Repeat("x", maxStoreIDLen+1), []float32{1, 1, 1}, nil)
+		}},
+		{"unencodable metadata", func(s *VectorStore) {
+			s.put("c", []float32{1, 1, 1}, map[string]any{"ch": make(chan int)})
+		}},
+		{"long model name", func(s *VectorStore) {
+			s.model = strings.Repeat("m", maxStoreModelLen+1)
+		}},
+	}
+	for _, tt := range tests {
+		s := testStore(t, MetricCosine)
+		tt.mutate(s)
+		var buf bytes.Buffer
+		if err := s.Save(&buf); err == nil {
+			t.Errorf("%s: Save succeeded", tt.name)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: Save wrote %d bytes before failing", tt.name, buf.Len())
+		}
+	}
+}
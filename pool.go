@@ -0,0 +1,611 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// pool owns a fixed set of members and lends each one to a single caller
+// at a time, so calls on different members run in parallel.
+type pool[T interface{ Close() error }] struct {
+	members []T
+	free    chan T
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newPool creates n members with create. The first member is created alone
+// so that a model download happens once; the rest are created concurrently
+// from the warm cache. On failure every member created so far is closed.
+func newPool[T interface{ Close() error }](n int, create func() (T, error)) (*pool[T], error) {
+	if n < 1 {
+		return nil, invalidConfig("pool size must be at least 1, got %d", n)
+	}
+
+	first, err := create()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]T, n)
+	members[0] = first
+
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 1; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			members[i], errs[i] = create()
+		}(i)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		for i, m := range members {
+			if errs[i] == nil {
+				m.Close()
+			}
+		}
+		return nil, err
+	}
+
+	p := &pool[T]{
+		members: members,
+		free:    make(chan T, n),
+		done:    make(chan struct{}),
+	}
+	for _, m := range members {
+		p.free <- m
+	}
+	return p, nil
+}
+
+// acquire waits for a free member, returning an error if ctx is done or
+// the pool is closed first. The member must be returned with release.
+func (p *pool[T]) acquire(ctx context.Context, name string) (T, error) {
+	var zero T
+	if err := checkContext(ctx); err != nil {
+		return zero, err
+	}
+	select {
+	case <-p.done:
+		return zero, errors.New(name + " pool is closed")
+	default:
+	}
+	select {
+	case m := <-p.free:
+		return m, nil
+	case <-p.done:
+		return zero, errors.New(name + " pool is closed")
+	case <-ctx.Done():
+		return zero, contextError(ctx.Err())
+	}
+}
+
+func (p *pool[T]) release(m T) {
+	p.free <- m
+}
+
+// do runs fn on a free member.
+func (p *pool[T]) do(ctx context.Context, name string, fn func(T) error) error {
+	m, err := p.acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer p.release(m)
+	return fn(m)
+}
+
+// parallel splits n items into at most one contiguous range per member, each
+// at least minSize items long, and runs fn on every range concurrently on
+// its own member. The first error cancels the remaining ranges.
+func (p *pool[T]) parallel(ctx context.Context, name string, n, minSize int, fn func(m T, start, end int) error) error {
+	parts := len(p.members)
+	if limit := (n + minSize - 1) / minSize; parts > limit {
+		parts = limit
+	}
+	if parts <= 1 {
+		return p.do(ctx, name, func(m T) error { return fn(m, 0, n) })
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make([]error, parts)
+	var wg sync.WaitGroup
+	for i := 0; i < parts; i++ {
+		start, end := i*n/parts, (i+1)*n/parts
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := p.acquire(ctx, name)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			// cancel before releasing the member, so that no range still
+			// waiting for a member starts after a failure
+			if errs[i] = fn(m, start, end); errs[i] != nil {
+				cancel()
+			}
+			p.release(m)
+		}(i)
+	}
+	wg.Wait()
+
+	// report the error that caused the cancellation, not the cancellations
+	var first error
+	for _, err := range errs {
+		var ke *KjarniError
+		if err != nil && !(errors.As(err, &ke) && ke.Code == ErrCancelled) {
+			return err
+		}
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// close stops new calls and closes every member. Calls already running
+// finish first, since each member's Close waits for its own lock.
+func (p *pool[T]) close() error {
+	var err error
+	p.once.Do(func() {
+		close(p.done)
+		errs := make([]error, len(p.members))
+		for i, m := range p.members {
+			errs[i] = m.Close()
+		}
+		err = errors.Join(errs...)
+	})
+	return err
+}
+
+// EmbedderPool runs embedding calls on a fixed number of Embedder handles
+// so that concurrent callers, such as HTTP handlers, do not wait for each
+// other. It has the same methods as Embedder and is safe for concurrent use.
+type EmbedderPool struct {
+	p *pool[*Embedder]
+
+	// read once at construction so that accessors do not wait for a handle
+	dim        int
+	model      string
+	normalized bool
+	maxTokens  int
+}
+
+// NewEmbedderPool creates a pool of n embedders for the given model. Each
+// handle holds its own copy of the model in memory; the model itself is
+// downloaded once.
+func NewEmbedderPool(model string, n int, opts ...Option) (*EmbedderPool, error) {
+	p, err := newPool(n, func() (*Embedder, error) { return NewEmbedder(model, opts...) })
+	if err != nil {
+		return nil, err
+	}
+	e := p.members[0]
+	return &EmbedderPool{p: p, dim: e.Dim(), model: e.Model(), normalized: e.Normalized(), maxTokens: e.MaxTokens()}, nil
+}
+
+// Size returns the number of handles in the pool.
+func (ep *EmbedderPool) Size() int {
+	return len(ep.p.members)
+}
+
+// Encode returns the embedding vector for the given text.
+func (ep *EmbedderPool) Encode(text string) ([]float32, error) {
+	return ep.EncodeContext(context.Background(), text)
+}
+
+// EncodeContext is like Encode but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for a free handle.
+func (ep *EmbedderPool) EncodeContext(ctx context.Context, text string) ([]float32, error) {
+	var vec []float32
+	err := ep.p.do(ctx, "embedder", func(e *Embedder) (err error) {
+		vec, err = e.EncodeContext(ctx, text)
+		return err
+	})
+	return vec, err
+}
+
+// EncodeBatch returns embedding vectors for multiple texts, in input order.
+// Large batches are split across free handles and encoded in parallel.
+func (ep *EmbedderPool) EncodeBatch(texts []string) ([][]float32, error) {
+	return ep.EncodeBatchContext(context.Background(), texts)
+}
+
+// EncodeBatchContext is like EncodeBatch but honors ctx in the same way as
+// Embedder.EncodeBatchContext.
+func (ep *EmbedderPool) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	err := ep.p.parallel(ctx, "embedder", len(texts), contextBatchSize, func(e *Embedder, start, end int) error {
+		vecs, err := e.EncodeBatchContext(ctx, texts[start:end])
+		if err != nil {
+			return shiftTooLong(err, start)
+		}
+		copy(out[start:end], vecs)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Similarity returns the cosine similarity between two texts.
+func (ep *EmbedderPool) Similarity(a, b string) (float32, error) {
+	return ep.SimilarityContext(context.Background(), a, b)
+}
+
+// SimilarityContext is like Similarity but honors ctx.
+func (ep *EmbedderPool) SimilarityContext(ctx context.Context, a, b string) (float32, error) {
+	var sim float32
+	err := ep.p.do(ctx, "embedder", func(e *Embedder) (err error) {
+		sim, err = e.SimilarityContext(ctx, a, b)
+		return err
+	})
+	return sim, err
+}
+
+// Dim returns the embedding dimension.
+func (ep *EmbedderPool) Dim() int {
+	return ep.dim
+}
+
+// Model returns the model name the pool was created with.
+func (ep *EmbedderPool) Model() string {
+	return ep.model
+}
+
+// Normalized reports whether vectors are scaled to unit length.
+func (ep *EmbedderPool) Normalized() bool {
+	return ep.normalized
+}
+
+// Tokenize returns the tokens the model sees for text. See Embedder.Tokenize.
+func (ep *EmbedderPool) Tokenize(text string) ([]Token, error) {
+	var tokens []Token
+	err := ep.p.do(context.Background(), "embedder", func(m *Embedder) (err error) {
+		tokens, err = m.Tokenize(text)
+		return err
+	})
+	return tokens, err
+}
+
+// CountTokens returns the number of tokens in text, including special tokens.
+func (ep *EmbedderPool) CountTokens(text string) (int, error) {
+	tokens, err := ep.Tokenize(text)
+	return len(tokens), err
+}
+
+// Truncate returns the longest prefix of text that fits in maxTokens
+// tokens. See Embedder.Truncate.
+func (ep *EmbedderPool) Truncate(text string, maxTokens int) (string, error) {
+	var out string
+	err := ep.p.do(context.Background(), "embedder", func(m *Embedder) (err error) {
+		out, err = m.Truncate(text, maxTokens)
+		return err
+	})
+	return out, err
+}
+
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (ep *EmbedderPool) MaxTokens() int {
+	return ep.maxTokens
+}
+
+// Close releases every handle in the pool, waiting for calls in progress.
+// Safe to call multiple times.
+func (ep *EmbedderPool) Close() error {
+	return ep.p.close()
+}
+
+// ClassifierPool runs classification calls on a fixed number of Classifier
+// handles. It has the same methods as Classifier and is safe for
+// concurrent use.
+type ClassifierPool struct {
+	p *pool[*Classifier]
+
+	// read once at construction so that accessors do not wait for a handle
+	numLabels int
+	maxTokens int
+}
+
+// NewClassifierPool creates a pool of n classifiers for the given model.
+func NewClassifierPool(model string, n int, opts ...Option) (*ClassifierPool, error) {
+	p, err := newPool(n, func() (*Classifier, error) { return NewClassifier(model, opts...) })
+	if err != nil {
+		return nil, err
+	}
+	c := p.members[0]
+	return &ClassifierPool{p: p, numLabels: c.NumLabels(), maxTokens: c.MaxTokens()}, nil
+}
+
+// Size returns the number of handles in the pool.
+func (cp *ClassifierPool) Size() int {
+	return len(cp.p.members)
+}
+
+// Classify runs the model on the given text and returns scored labels.
+func (cp *ClassifierPool) Classify(text string) (*ClassifyResult, error) {
+	return cp.ClassifyContext(context.Background(), text)
+}
+
+// ClassifyContext is like Classify but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for a free handle.
+func (cp *ClassifierPool) ClassifyContext(ctx context.Context, text string) (*ClassifyResult, error) {
+	var res *ClassifyResult
+	err := cp.p.do(ctx, "classifier", func(c *Classifier) (err error) {
+		res, err = c.ClassifyContext(ctx, text)
+		return err
+	})
+	return res, err
+}
+
+// ClassifyBatch classifies multiple texts and returns one result per text,
+// in input order. Large batches are split across free handles.
+func (cp *ClassifierPool) ClassifyBatch(texts []string) ([]*ClassifyResult, error) {
+	return cp.ClassifyBatchContext(context.Background(), texts)
+}
+
+// ClassifyBatchContext is like ClassifyBatch but honors ctx in the same way
+// as Classifier.ClassifyBatchContext.
+func (cp *ClassifierPool) ClassifyBatchContext(ctx context.Context, texts []string) ([]*ClassifyResult, error) {
+	out := make([]*ClassifyResult, len(texts))
+	err := cp.p.parallel(ctx, "classifier", len(texts), contextBatchSize, func(c *Classifier, start, end int) error {
+		res, err := c.ClassifyBatchContext(ctx, texts[start:end])
+		if err != nil {
+			return shiftTooLong(err, start)
+		}
+		copy(out[start:end], res)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// NumLabels returns the number of labels the model supports.
+func (cp *ClassifierPool) NumLabels() int {
+	return cp.numLabels
+}
+
+// Tokenize returns the tokens the model sees for text. See Classifier.Tokenize.
+func (cp *ClassifierPool) Tokenize(text string) ([]Token, error) {
+	var tokens []Token
+	err := cp.p.do(context.Background(), "classifier", func(m *Classifier) (err error) {
+		tokens, err = m.Tokenize(text)
+		return err
+	})
+	return tokens, err
+}
+
+// CountTokens returns the number of tokens in text, including special tokens.
+func (cp *ClassifierPool) CountTokens(text string) (int, error) {
+	tokens, err := cp.Tokenize(text)
+	return len(tokens), err
+}
+
+// Truncate returns the longest prefix of text that fits in maxTokens
+// tokens. See Classifier.Truncate.
+func (cp *ClassifierPool) Truncate(text string, maxTokens int) (string, error) {
+	var out string
+	err := cp.p.do(context.Background(), "classifier", func(m *Classifier) (err error) {
+		out, err = m.Truncate(text, maxTokens)
+		return err
+	})
+	return out, err
+}
+
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (cp *ClassifierPool) MaxTokens() int {
+	return cp.maxTokens
+}
+
+// Close releases every handle in the pool, waiting for calls in progress.
+// Safe to call multiple times.
+func (cp *ClassifierPool) Close() error {
+	return cp.p.close()
+}
+
+// RerankerPool runs reranking calls on a fixed number of Reranker handles.
+// It has the same methods as Reranker and is safe for concurrent use.
+type RerankerPool struct {
+	p *pool[*Reranker]
+
+	// read once at construction so that MaxTokens does not wait for a handle
+	maxTokens int
+}
+
+// NewRerankerPool creates a pool of n rerankers for the given cross-encoder
+// model. An empty model selects the default, as with NewReranker.
+func NewRerankerPool(model string, n int, opts ...Option) (*RerankerPool, error) {
+	p, err := newPool(n, func() (*Reranker, error) { return NewRerankerModel(model, opts...) })
+	if err != nil {
+		return nil, err
+	}
+	return &RerankerPool{p: p, maxTokens: p.members[0].MaxTokens()}, nil
+}
+
+// Size returns the number of handles in the pool.
+func (rp *RerankerPool) Size() int {
+	return len(rp.p.members)
+}
+
+// Score returns the relevance score of a single query-document pair.
+func (rp *RerankerPool) Score(query, document string) (float32, error) {
+	return rp.ScoreContext(context.Background(), query, document)
+}
+
+// ScoreContext is like Score but honors ctx.
+func (rp *RerankerPool) ScoreContext(ctx context.Context, query, document string) (float32, error) {
+	var score float32
+	err := rp.p.do(ctx, "reranker", func(r *Reranker) (err error) {
+		score, err = r.ScoreContext(ctx, query, document)
+		return err
+	})
+	return score, err
+}
+
+// Rerank scores all documents and returns them sorted by relevance to the
+// query. Large document sets are split across free handles.
+func (rp *RerankerPool) Rerank(query string, documents []string) ([]RerankResult, error) {
+	return rp.rerank(context.Background(), query, documents, -1)
+}
+
+// RerankContext is like Rerank but honors ctx.
+func (rp *RerankerPool) RerankContext(ctx context.Context, query string, documents []string) ([]RerankResult, error) {
+	return rp.rerank(ctx, query, documents, -1)
+}
+
+// RerankTopK scores all documents and returns the top k sorted by relevance.
+func (rp *RerankerPool) RerankTopK(query string, documents []string, k int) ([]RerankResult, error) {
+	return rp.rerank(context.Background(), query, documents, k)
+}
+
+// RerankTopKContext is like RerankTopK but honors ctx.
+func (rp *RerankerPool) RerankTopKContext(ctx context.Context, query string, documents []string, k int) ([]RerankResult, error) {
+	return rp.rerank(ctx, query, documents, k)
+}
+
+// rerank returns the top k results, or all results if k is negative.
+func (rp *RerankerPool) rerank(ctx context.Context, query string, documents []string, k int) ([]RerankResult, error) {
+	return rerankParallel(ctx, rp.p, len(documents), k, func(r *Reranker, start, end int) ([]RerankResult, error) {
+		return r.rerankContext(ctx, query, documents[start:end], k)
+	})
+}
+
+// rerankParallel scores n documents in parts spread over the members of p,
+// each part keeping its own top k, and merges the parts into the overall
+// top k. score returns results indexed within its part.
+func rerankParallel[T interface{ Close() error }](ctx context.Context, p *pool[T], n, k int, score func(m T, start, end int) ([]RerankResult, error)) ([]RerankResult, error) {
+	var out []RerankResult
+	var mu sync.Mutex
+	err := p.parallel(ctx, "reranker", n, contextBatchSize, func(m T, start, end int) error {
+		res, err := score(m, start, end)
+		if err != nil {
+			return shiftTooLong(err, start)
+		}
+		for i := range res {
+			res[i].Index += start
+		}
+		mu.Lock()
+		out = append(out, res...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []RerankResult{}
+	}
+	return topRerank(out, k), nil
+}
+
+// Tokenize returns the tokens the model sees for text. See Reranker.Tokenize.
+func (rp *RerankerPool) Tokenize(text string) ([]Token, error) {
+	var tokens []Token
+	err := rp.p.do(context.Background(), "reranker", func(m *Reranker) (err error) {
+		tokens, err = m.Tokenize(text)
+		return err
+	})
+	return tokens, err
+}
+
+// CountTokens returns the number of tokens in text, including special tokens.
+func (rp *RerankerPool) CountTokens(text string) (int, error) {
+	tokens, err := rp.Tokenize(text)
+	return len(tokens), err
+}
+
+// CountPairTokens returns the number of tokens in the combined
+// query-document input the cross-encoder scores.
+func (rp *RerankerPool) CountPairTokens(query, document string) (int, error) {
+	var n int
+	err := rp.p.do(context.Background(), "reranker", func(m *Reranker) (err error) {
+		n, err = m.CountPairTokens(query, document)
+		return err
+	})
+	return n, err
+}
+
+// Truncate returns the longest prefix of text that fits in maxTokens
+// tokens. See Reranker.Truncate.
+func (rp *RerankerPool) Truncate(text string, maxTokens int) (string, error) {
+	var out string
+	err := rp.p.do(context.Background(), "reranker", func(m *Reranker) (err error) {
+		out, err = m.Truncate(text, maxTokens)
+		return err
+	})
+	return out, err
+}
+
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (rp *RerankerPool) MaxTokens() int {
+	return rp.maxTokens
+}
+
+// Close releases every handle in the pool, waiting for calls in progress.
+// Safe to call multiple times.
+func (rp *RerankerPool) Close() error {
+	return rp.p.close()
+}
+
+// SearcherPool runs queries on a fixed number of Searcher handles. It has
+// the same methods as Searcher and is safe for concurrent use.
+type SearcherPool struct {
+	p *pool[*Searcher]
+}
+
+// NewSearcherPool creates a pool of n searchers, each loading the given
+// embedding model and optional reranker model as NewSearcher does.
+func NewSearcherPool(model string, rerankerModel string, n int, opts ...Option) (*SearcherPool, error) {
+	p, err := newPool(n, func() (*Searcher, error) { return NewSearcher(model, rerankerModel, opts...) })
+	if err != nil {
+		return nil, err
+	}
+	return &SearcherPool{p: p}, nil
+}
+
+// Size returns the number of handles in the pool.
+func (sp *SearcherPool) Size() int {
+	return len(sp.p.members)
+}
+
+// Search queries an index using the given search mode.
+func (sp *SearcherPool) Search(indexPath string, query string, mode SearchMode) ([]SearchResult, error) {
+	return sp.SearchWithOptionsContext(context.Background(), indexPath, query, SearchOptions{Mode: mode})
+}
+
+// SearchContext is like Search but honors ctx.
+func (sp *SearcherPool) SearchContext(ctx context.Context, indexPath string, query string, mode SearchMode) ([]SearchResult, error) {
+	return sp.SearchWithOptionsContext(ctx, indexPath, query, SearchOptions{Mode: mode})
+}
+
+// SearchWithOptions queries an index with per-query options.
+func (sp *SearcherPool) SearchWithOptions(indexPath string, query string, opts SearchOptions) ([]SearchResult, error) {
+	return sp.SearchWithOptionsContext(context.Background(), indexPath, query, opts)
+}
+
+// SearchWithOptionsContext is like SearchWithOptions but returns early with
+// ErrCancelled or ErrTimeout if ctx is done while waiting for a free handle.
+func (sp *SearcherPool) SearchWithOptionsContext(ctx context.Context, indexPath string, query string, opts SearchOptions) ([]SearchResult, error) {
+	var results []SearchResult
+	err := sp.p.do(ctx, "searcher", func(s *Searcher) (err error) {
+		results, err = s.SearchWithOptionsContext(ctx, indexPath, query, opts)
+		return err
+	})
+	return results, err
+}
+
+// Close releases every handle in the pool, waiting for calls in progress.
+// Safe to call multiple times.
+func (sp *SearcherPool) Close() error {
+	return sp.p.close()
+}
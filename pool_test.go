@@ -0,0 +1,281 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeMember is a pool member that counts how often it is closed.
+type fakeMember struct {
+	id     int
+	closed atomic.Int32
+}
+
+func (f *fakeMember) Close() error {
+	f.closed.Add(1)
+	return nil
+}
+
+// newFakePool creates a pool of n fake members with ids 0 to n-1.
+func newFakePool(t *testing.T, n int) (*pool[*fakeMember], []*fakeMember) {
+	t.Helper()
+	var mu sync.Mutex
+	var created []*fakeMember
+	p, err := newPool(n, func() (*fakeMember, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		m := &fakeMember{id: len(created)}
+		created = append(created, m)
+		return m, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, created
+}
+
+func TestNewPoolCleanup(t *testing.T) {
+	errCreate := errors.New("create failed")
+	tests := []struct {
+		name      string
+		n         int
+		failAt    int // 1-based create call that fails, 0 for none
+		wantCalls int
+	}{
+		{"first fails", 4, 1, 1},
+		{"later fails", 4, 3, 4},
+		{"last fails", 2, 2, 2},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var created []*fakeMember
+		calls := 0
+		_, err := newPool(tt.n, func() (*fakeMember, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls++
+			if calls == tt.failAt {
+				return nil, errCreate
+			}
+			m := &fakeMember{}
+			created = append(created, m)
+			return m, nil
+		})
+		if !errors.Is(err, errCreate) {
+			t.Errorf("%s: got %v", tt.name, err)
+		}
+		if calls != tt.wantCalls {
+			t.Errorf("%s: %d create calls, want %d", tt.name, calls, tt.wantCalls)
+		}
+		for i, m := range created {
+			if m.closed.Load() != 1 {
+				t.Errorf("%s: member %d closed %d times", tt.name, i, m.closed.Load())
+			}
+		}
+	}
+
+	if _, err := newPool(0, func() (*fakeMember, error) { return &fakeMember{}, nil }); errorCode(err) != ErrInvalidConfig {
+		t.Errorf("size 0: got %v", err)
+	}
+}
+
+func TestPoolClose(t *testing.T) {
+	p, members := newFakePool(t, 2)
+	ctx := context.Background()
+
+	// a caller waiting for a member is released by close
+	a, _ := p.acquire(ctx, "test")
+	b, _ := p.acquire(ctx, "test")
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := p.acquire(ctx, "test")
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := p.close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waiting; err == nil || err.Error() != "test pool is closed" {
+		t.Errorf("waiting acquire: got %v", err)
+	}
+	p.release(a)
+	p.release(b)
+
+	// a closed pool hands out no members, even free ones
+	if _, err := p.acquire(ctx, "test"); err == nil {
+		t.Error("acquire after close succeeded")
+	}
+	if err := p.do(ctx, "test", func(*fakeMember) error { return nil }); err == nil {
+		t.Error("do after close succeeded")
+	}
+	p.close()
+	for _, m := range members {
+		if m.closed.Load() != 1 {
+			t.Errorf("member %d closed %d times", m.id, m.closed.Load())
+		}
+	}
+}
+
+func TestPoolAcquireContext(t *testing.T) {
+	p, _ := newFakePool(t, 1)
+	defer p.close()
+	m, _ := p.acquire(context.Background(), "test")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.acquire(ctx, "test"); errorCode(err) != ErrTimeout {
+		t.Errorf("deadline while waiting: got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := p.acquire(ctx, "test"); errorCode(err) != ErrCancelled {
+		t.Errorf("cancelled while waiting: got %v", err)
+	}
+
+	// a done context fails even when a member is free
+	p.release(m)
+	if _, err := p.acquire(ctx, "test"); errorCode(err) != ErrCancelled {
+		t.Errorf("cancelled before waiting: got %v", err)
+	}
+}
+
+func TestPoolParallelRanges(t *testing.T) {
+	tests := []struct {
+		members, n, minSize int
+		want                [][2]int
+	}{
+		{4, 100, 32, [][2]int{{0, 25}, {25, 50}, {50, 75}, {75, 100}}},
+		{4, 40, 32, [][2]int{{0, 20}, {20, 40}}},
+		{4, 32, 32, [][2]int{{0, 32}}},
+		{4, 0, 32, [][2]int{{0, 0}}},
+		{3, 10, 1, [][2]int{{0, 3}, {3, 6}, {6, 10}}},
+		{1, 100, 1, [][2]int{{0, 100}}},
+	}
+	for _, tt := range tests {
+		p, _ := newFakePool(t, tt.members)
+		var mu sync.Mutex
+		var got [][2]int
+		used := map[int]bool{}
+		err := p.parallel(context.Background(), "test", tt.n, tt.minSize, func(m *fakeMember, start, end int) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if used[m.id] {
+				t.Errorf("member %d used for two ranges", m.id)
+			}
+			used[m.id] = true
+			got = append(got, [2]int{start, end})
+			return nil
+		})
+		p.close()
+		sort.Slice(got, func(i, j int) bool { return got[i][0] < got[j][0] })
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%d members, %d items, min %d: got %v, %v, want %v", tt.members, tt.n, tt.minSize, got, err, tt.want)
+		}
+	}
+}
+
+func TestPoolParallelErrors(t *testing.T) {
+	errBad := errors.New("bad range")
+
+	// the failing range is reported, not the cancellations that follow it
+	p, _ := newFakePool(t, 4)
+	defer p.close()
+	failed := make(chan struct{})
+	err := p.parallel(context.Background(), "test", 4, 1, func(m *fakeMember, start, end int) error {
+		if start == 2 {
+			close(failed)
+			return errBad
+		}
+		<-failed
+		return contextError(context.Canceled)
+	})
+	if !errors.Is(err, errBad) {
+		t.Errorf("got %v, want the failing range's error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.parallel(ctx, "test", 4, 1, func(*fakeMember, int, int) error { return nil }); errorCode(err) != ErrCancelled {
+		t.Errorf("cancelled: got %v", err)
+	}
+}
+
+func TestPoolParallelCancelsWaiting(t *testing.T) {
+	p, _ := newFakePool(t, 2)
+	defer p.close()
+	held, _ := p.acquire(context.Background(), "test")
+
+	// one range runs on the free member and fails; the other, still waiting
+	// for a member, is cancelled instead of running
+	errBad := errors.New("bad range")
+	var ran atomic.Int32
+	err := p.parallel(context.Background(), "test", 2, 1, func(m *fakeMember, start, end int) error {
+		ran.Add(1)
+		time.Sleep(10 * time.Millisecond) // let the other range start waiting
+		return errBad
+	})
+	p.release(held)
+	if !errors.Is(err, errBad) || ran.Load() != 1 {
+		t.Errorf("got %v after %d ranges ran", err, ran.Load())
+	}
+}
+
+func TestRerankParallel(t *testing.T) {
+	// scores repeat so that parts contain ties
+	score := func(i int) float32 { return float32((i * 37) % 11) }
+	for _, members := range []int{1, 3, 4} {
+		for _, n := range []int{0, 5, 70, 130} {
+			for _, k := range []int{-1, 0, 1, 10, 200} {
+				p, _ := newFakePool(t, members)
+				got, err := rerankParallel(context.Background(), p, n, k, func(m *fakeMember, start, end int) ([]RerankResult, error) {
+					// each part returns only its own top k, indexed within the part
+					var part []RerankResult
+					for i := start; i < end; i++ {
+						part = append(part, RerankResult{Index: i - start, Score: score(i), Document: fmt.Sprint(i)})
+					}
+					return topRerank(part, k), nil
+				})
+				p.close()
+
+				var all []RerankResult
+				for i := 0; i < n; i++ {
+					all = append(all, RerankResult{Index: i, Score: score(i), Document: fmt.Sprint(i)})
+				}
+				want := topRerank(all, k)
+				if want == nil {
+					want = []RerankResult{}
+				}
+				if err != nil || !reflect.DeepEqual(got, want) {
+					t.Errorf("%d members, %d documents, k=%d: got %v, %v\nwant %v", members, n, k, got, err, want)
+				}
+			}
+		}
+	}
+}
+
+func TestRerankParallelTooLong(t *testing.T) {
+	p, _ := newFakePool(t, 2)
+	defer p.close()
+	_, err := rerankParallel(context.Background(), p, 64, -1, func(m *fakeMember, start, end int) ([]RerankResult, error) {
+		if start > 0 {
+			return nil, tooLong(1, 600, 512)
+		}
+		return nil, nil
+	})
+	// input 1 of the second part is input 33 of the request
+	var tl *InputTooLongError
+	if !errors.As(err, &tl) || tl.Index != 33 {
+		t.Errorf("got %v", err)
+	}
+}
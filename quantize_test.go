@@ -0,0 +1,278 @@
+package kjarni
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// searchFunc lets recall measure a search method that takes extra arguments.
+type searchFunc func(query []float32, k int) ([]VectorMatch, error)
+
+func (f searchFunc) Search(query []float32, k int) ([]VectorMatch, error) { return f(query, k) }
+
+func TestQuantizeInt8(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, v := range randomVectors(rng, 50, 100) {
+		q := QuantizeInt8(v)
+		var maxAbs int8
+		for _, x := range q.Values {
+			if x == -128 {
+				t.Fatalf("value -128 in %v", q.Values)
+			}
+			if x < 0 {
+				x = -x
+			}
+			if x > maxAbs {
+				maxAbs = x
+			}
+		}
+		if maxAbs != 127 {
+			t.Errorf("largest value %d, want 127", maxAbs)
+		}
+		// rounding to the nearest step is off by at most half a step
+		for i, x := range q.Dequantize() {
+			if err := math.Abs(float64(x - v[i])); err > float64(q.Scale)/2*1.0001 {
+				t.Fatalf("dimension %d: %v dequantized to %v, error %v over half step %v", i, v[i], x, err, q.Scale/2)
+			}
+		}
+	}
+
+	q := QuantizeInt8([]float32{0, 0, 0})
+	if q.Scale != 0 || len(q.Values) != 3 || q.Values[0] != 0 {
+		t.Errorf("zero vector: got %+v", q)
+	}
+	if got := q.Dequantize(); len(got) != 3 || got[0] != 0 {
+		t.Errorf("zero vector dequantized to %v", got)
+	}
+}
+
+func TestSimilarityInt8(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	vecs := randomVectors(rng, 40, 64)
+	for i := 1; i < len(vecs); i++ {
+		a, b := vecs[i-1], vecs[i]
+		qa, qb := QuantizeInt8(a), QuantizeInt8(b)
+		if got, want := CosineSimilarityInt8(qa, qb), CosineSimilarity(a, b); math.Abs(float64(got-want)) > 0.01 {
+			t.Errorf("cosine %v, exact %v", got, want)
+		}
+		// each product is off by at most the rounding error of either factor
+		var dot, bound float64
+		ea, eb := float64(qa.Scale)/2, float64(qb.Scale)/2
+		for j := range a {
+			dot += float64(a[j]) * float64(b[j])
+			bound += math.Abs(float64(a[j]))*eb + math.Abs(float64(b[j]))*ea + ea*eb
+		}
+		if got := DotInt8(qa, qb); math.Abs(float64(got)-dot) > bound*1.0001 {
+			t.Errorf("dot %v, exact %v, bound %v", got, dot, bound)
+		}
+	}
+
+	a := QuantizeInt8([]float32{1, 2})
+	tests := []struct {
+		name string
+		b    Int8Vector
+	}{
+		{"length mismatch", QuantizeInt8([]float32{1, 2, 3})},
+		{"zero vector", QuantizeInt8([]float32{0, 0})},
+	}
+	for _, tt := range tests {
+		if got := CosineSimilarityInt8(a, tt.b); got != 0 {
+			t.Errorf("%s: cosine %v, want 0", tt.name, got)
+		}
+	}
+	if got := DotInt8(a, tests[0].b); got != 0 {
+		t.Errorf("length mismatch: dot %v, want 0", got)
+	}
+	if got := CosineSimilarityInt8(Int8Vector{}, Int8Vector{}); got != 0 {
+		t.Errorf("empty vectors: cosine %v, want 0", got)
+	}
+}
+
+func TestQuantizeBinary(t *testing.T) {
+	v := make([]float32, 70)
+	for i := range v {
+		v[i] = -1
+	}
+	v[0], v[63], v[64], v[69] = 1, 2, 0.5, 3
+	q := QuantizeBinary(v)
+	if q.Dim != 70 || len(q.Bits) != 2 || q.Bits[0] != 1|1<<63 || q.Bits[1] != 1|1<<5 {
+		t.Fatalf("got %+v", q)
+	}
+
+	neg := make([]float32, len(v))
+	for i, x := range v {
+		neg[i] = -x
+	}
+	qn := QuantizeBinary(neg)
+	short := QuantizeBinary(v[:69])
+	tests := []struct {
+		name string
+		a, b BinaryVector
+		dist int
+		sim  float32
+	}{
+		{"same", q, q, 0, 1},
+		{"negated", q, qn, 70, -1},
+		{"one sign differs", q, QuantizeBinary(append(append([]float32(nil), v[:69]...), -1)), 1, 1 - 2.0/70},
+		{"dimension mismatch", q, short, -1, 0},
+		{"empty", BinaryVector{}, BinaryVector{}, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := HammingDistance(tt.a, tt.b); got != tt.dist {
+			t.Errorf("%s: distance %d, want %d", tt.name, got, tt.dist)
+		}
+		if got := BinarySimilarity(tt.a, tt.b); got != tt.sim {
+			t.Errorf("%s: similarity %v, want %v", tt.name, got, tt.sim)
+		}
+	}
+
+	// zero is not positive
+	if q := QuantizeBinary([]float32{0, 0}); q.Bits[0] != 0 {
+		t.Errorf("zeros set bits %b", q.Bits[0])
+	}
+}
+
+func TestNewQuantizedStoreConfig(t *testing.T) {
+	tests := []struct {
+		mode Quantization
+		dim  int
+		ok   bool
+	}{
+		{QuantizeInt8Scalar, 4, true},
+		{QuantizeBinarySign, 4, true},
+		{QuantizeInt8Scalar, 0, false},
+		{Quantization(2), 4, false},
+	}
+	for _, tt := range tests {
+		_, err := NewQuantizedStore(tt.mode, tt.dim)
+		if tt.ok != (err == nil) {
+			t.Errorf("mode %d dim %d: error %v", tt.mode, tt.dim, err)
+		}
+		if err != nil && errorCode(err) != ErrInvalidConfig {
+			t.Errorf("mode %d dim %d: got %v, want ErrInvalidConfig", tt.mode, tt.dim, err)
+		}
+	}
+}
+
+func TestQuantizedStoreMutations(t *testing.T) {
+	vecs := map[string][]float32{
+		"a": {1, 0.1, 0.1},
+		"b": {-0.1, 1, 0.1},
+		"c": {0.1, -0.1, 1},
+		"d": {-1, -0.1, -0.1},
+	}
+	for _, mode := range []Quantization{QuantizeInt8Scalar, QuantizeBinarySign} {
+		s, _ := NewQuantizedStore(mode, 3)
+		for _, id := range []string{"a", "b", "c", "d"} {
+			if err := s.Upsert(id, vecs[id], map[string]any{"id": id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := s.Upsert("", vecs["a"], nil); err == nil {
+			t.Errorf("mode %d: empty id accepted", mode)
+		}
+		if err := s.Upsert("e", []float32{1, 0}, nil); err == nil {
+			t.Errorf("mode %d: wrong dimension accepted", mode)
+		}
+		// replacing keeps one entry per id
+		if err := s.Upsert("d", vecs["d"], map[string]any{"id": "d"}); err != nil || s.Len() != 4 {
+			t.Errorf("mode %d: upsert existing: %v, Len() = %d", mode, err, s.Len())
+		}
+
+		// deleting the first entry moves the last into its place
+		if !s.Delete("a") || s.Delete("a") || s.Len() != 3 {
+			t.Errorf("mode %d: delete: Len() = %d", mode, s.Len())
+		}
+		for _, id := range []string{"b", "c", "d"} {
+			matches, err := s.Search(vecs[id], 1)
+			if err != nil || len(matches) != 1 || matches[0].ID != id || matches[0].Metadata["id"] != id {
+				t.Errorf("mode %d: search for %q after delete: %v, %v", mode, id, matches, err)
+			}
+		}
+		if matches, _ := s.Search(vecs["a"], 10); len(matches) != 3 {
+			t.Errorf("mode %d: %d matches, want 3", mode, len(matches))
+		}
+		if _, err := s.Search([]float32{1}, 1); err == nil {
+			t.Errorf("mode %d: wrong query dimension accepted", mode)
+		}
+	}
+}
+
+func TestQuantizedStoreRecall(t *testing.T) {
+	rng := rand.New(rand.NewSource(4))
+	vecs := clusteredVectors(rng, 2000, 64)
+	queries := clusteredVectors(rng, 50, 64)
+	exact, _ := NewVectorStore(MetricCosine, nil)
+	for i, v := range vecs {
+		if err := exact.Add(fmt.Sprint(i), v, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lookup := func(id string) ([]float32, bool) {
+		vec, _, ok := exact.Get(id)
+		return vec, ok
+	}
+
+	// sign bits of 64 dimensions say little on their own, so binary
+	// search needs far more candidates to rescore
+	tests := []struct {
+		mode       Quantization
+		oversample int
+		min        float64
+		minRescore float64
+	}{
+		{QuantizeInt8Scalar, 4, 0.95, 0.99},
+		{QuantizeBinarySign, 40, 0.1, 0.8},
+	}
+	for _, tt := range tests {
+		s, _ := NewQuantizedStore(tt.mode, 64)
+		for i, v := range vecs {
+			if err := s.Upsert(fmt.Sprint(i), v, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if r := recall(t, s, exact, queries, 10); r < tt.min {
+			t.Errorf("mode %d: recall@10 %.3f, want at least %.2f", tt.mode, r, tt.min)
+		}
+		rescore := searchFunc(func(q []float32, k int) ([]VectorMatch, error) {
+			return s.SearchRescore(q, k, tt.oversample, lookup)
+		})
+		if r := recall(t, rescore, exact, queries, 10); r < tt.minRescore {
+			t.Errorf("mode %d: rescored recall@10 %.3f, want at least %.2f", tt.mode, r, tt.minRescore)
+		}
+	}
+}
+
+func TestSearchRescoreLimits(t *testing.T) {
+	s, _ := NewQuantizedStore(QuantizeInt8Scalar, 2)
+	vecs := map[string][]float32{"a": {1, 0}, "b": {0, 1}, "c": {1, 1}}
+	for id, v := range vecs {
+		s.Upsert(id, v, nil)
+	}
+	lookup := func(id string) ([]float32, bool) {
+		v, ok := vecs[id]
+		return v, ok && id != "c"
+	}
+	tests := []struct {
+		k, oversample int
+		want          int
+	}{
+		{0, 4, 0},
+		{1, 0, 1},
+		{10, 1, 2},
+		// k*oversample would overflow
+		{math.MaxInt, 4, 2},
+		{2, math.MaxInt, 2},
+	}
+	for _, tt := range tests {
+		matches, err := s.SearchRescore([]float32{1, 0.2}, tt.k, tt.oversample, lookup)
+		if err != nil || len(matches) != tt.want {
+			t.Errorf("k=%d oversample=%d: got %v, %v, want %d matches", tt.k, tt.oversample, matches, err, tt.want)
+		}
+		if len(matches) > 0 && matches[0].ID != "a" {
+			t.Errorf("k=%d oversample=%d: best %q, want a", tt.k, tt.oversample, matches[0].ID)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 package kjarni
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"sync"
+	"sort"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -18,14 +19,23 @@ type RerankResult struct {
 
 // Reranker scores query-document relevance using a cross-encoder model.
 type Reranker struct {
-	handle uintptr
-	mu     sync.Mutex
-	closed bool
+	handle    uintptr
+	mu        handleLock
+	closed    bool
+	strict    bool // reject pairs over maxTokens instead of truncating
+	maxTokens int
 }
 
 // NewReranker creates a reranker using the default cross-encoder model.
 // The model downloads automatically on first use and is cached locally.
 func NewReranker(opts ...Option) (*Reranker, error) {
+	return NewRerankerModel("", opts...)
+}
+
+// NewRerankerModel creates a reranker for the given cross-encoder model, such
+// as minilm-l6-v2-cross-encoder. An empty model selects the default. Combine
+// with WithModelPath to load a fine-tuned model from a local directory.
+func NewRerankerModel(model string, opts ...Option) (*Reranker, error) {
 	var initErr error
 	ffiOnce.Do(func() { initErr = initFFI() })
 	if initErr != nil {
@@ -33,9 +43,22 @@ func NewReranker(opts ...Option) (*Reranker, error) {
 	}
 
 	o := applyOptions(opts)
+	if !o.truncate && _rerankerCountPairTokensSym == 0 {
+		return nil, unsupported("kjarni_reranker_count_pair_tokens")
+	}
+
+	modelStr, keepModel := optionalCString(model)
+	defer keepModel()
+	cacheDirPtr, keepCacheDir := optionalCString(o.cacheDir)
+	defer keepCacheDir()
+	modelPathPtr, keepModelPath := optionalCString(o.modelPath)
+	defer keepModelPath()
 
 	var config ffiRerankerConfig
 	config.Device = deviceCode(o.device)
+	config.CacheDir = cacheDirPtr
+	config.ModelName = modelStr
+	config.ModelPath = modelPathPtr
 	config.Quiet = boolToInt(o.quiet)
 
 	var handle uintptr
@@ -50,17 +73,37 @@ func NewReranker(opts ...Option) (*Reranker, error) {
 		return nil, lastError(code)
 	}
 
-	return &Reranker{handle: handle}, nil
+	r := &Reranker{
+		handle:    handle,
+		strict:    !o.truncate,
+		maxTokens: maxLengthWith(_rerankerMaxLengthSym, handle),
+	}
+	if err := checkMaxTokens(r.strict, r.maxTokens, "kjarni_reranker_max_length"); err != nil {
+		_rerankerFree(handle)
+		return nil, err
+	}
+	return r, nil
 }
 
 // Score returns the relevance score for a single query-document pair.
 func (r *Reranker) Score(query, document string) (float32, error) {
-	r.mu.Lock()
+	return r.ScoreContext(context.Background(), query, document)
+}
+
+// ScoreContext is like Score but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the reranker.
+func (r *Reranker) ScoreContext(ctx context.Context, query, document string) (float32, error) {
+	if err := r.mu.LockContext(ctx); err != nil {
+		return 0, err
+	}
 	defer r.mu.Unlock()
 
 	if r.closed {
 		return 0, errors.New("reranker is closed")
 	}
+	if err := r.checkLength(ctx, 0, query, document); err != nil {
+		return 0, err
+	}
 
 	qPtr, keepQ := cString(query)
 	defer keepQ()
@@ -86,7 +129,30 @@ func (r *Reranker) Score(query, document string) (float32, error) {
 
 // Rerank scores all documents and returns them sorted by relevance to the query.
 func (r *Reranker) Rerank(query string, documents []string) ([]RerankResult, error) {
-	r.mu.Lock()
+	return r.rerankContext(context.Background(), query, documents, -1)
+}
+
+// RerankContext is like Rerank but honors ctx. Cancellable contexts score the
+// documents in sub-batches and check ctx between them.
+func (r *Reranker) RerankContext(ctx context.Context, query string, documents []string) ([]RerankResult, error) {
+	return r.rerankContext(ctx, query, documents, -1)
+}
+
+// RerankTopK scores all documents and returns the top k sorted by relevance.
+func (r *Reranker) RerankTopK(query string, documents []string, k int) ([]RerankResult, error) {
+	return r.rerankContext(context.Background(), query, documents, k)
+}
+
+// RerankTopKContext is like RerankTopK but honors ctx in the same way as RerankContext.
+func (r *Reranker) RerankTopKContext(ctx context.Context, query string, documents []string, k int) ([]RerankResult, error) {
+	return r.rerankContext(ctx, query, documents, k)
+}
+
+// rerankContext returns the top k results, or all results if k is negative.
+func (r *Reranker) rerankContext(ctx context.Context, query string, documents []string, k int) ([]RerankResult, error) {
+	if err := r.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer r.mu.Unlock()
 
 	if r.closed {
@@ -96,7 +162,63 @@ func (r *Reranker) Rerank(query string, documents []string) ([]RerankResult, err
 	if len(documents) == 0 {
 		return []RerankResult{}, nil
 	}
+	if ctx.Done() == nil {
+		if err := r.checkLength(ctx, 0, query, documents...); err != nil {
+			return nil, err
+		}
+		return r.rerank(query, documents, k)
+	}
+
+	return rerankBatches(ctx, len(documents), k, func(start, end int) ([]RerankResult, error) {
+		if err := r.checkLength(ctx, start, query, documents[start:end]...); err != nil {
+			return nil, err
+		}
+		return r.rerank(query, documents[start:end], -1)
+	})
+}
+
+// rerankBatches scores n documents in sub-batches, checking ctx between
+// them, and returns the top k results, or all results if k is negative.
+// score returns the results for documents [start, end) with indexes
+// relative to start. Cross-encoder scores are independent per pair, so
+// sub-batches can be scored separately and merged.
+func rerankBatches(ctx context.Context, n, k int, score func(start, end int) ([]RerankResult, error)) ([]RerankResult, error) {
+	out := make([]RerankResult, 0, n)
+	err := splitBatches(ctx, n, contextBatchSize, func(start, end int) error {
+		batch, err := score(start, end)
+		if err != nil {
+			return err
+		}
+		for i := range batch {
+			batch[i].Index += start
+		}
+		out = append(out, batch...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return topRerank(out, k), nil
+}
+
+// topRerank sorts results by descending score, breaking ties by input
+// index, and keeps the first k, or all of them if k is negative.
+func topRerank(results []RerankResult, k int) []RerankResult {
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Index < results[j].Index
+	})
+	if k >= 0 && k < len(results) {
+		results = results[:k]
+	}
+	return results
+}
 
+// rerank makes a single engine call, using the top-k entry point unless k is
+// negative. The caller must hold r.mu.
+func (r *Reranker) rerank(query string, documents []string, k int) ([]RerankResult, error) {
 	qPtr, keepQ := cString(query)
 	defer keepQ()
 
@@ -112,14 +234,27 @@ func (r *Reranker) Rerank(query string, documents []string) ([]RerankResult, err
 	}()
 
 	var results ffiRerankResults
-	r1, _, _ := purego.SyscallN(
-		_rerankerRerankSym,
-		r.handle,
-		qPtr,
-		uintptr(unsafe.Pointer(&cStrs[0])),
-		uintptr(len(documents)),
-		uintptr(unsafe.Pointer(&results)),
-	)
+	var r1 uintptr
+	if k < 0 {
+		r1, _, _ = purego.SyscallN(
+			_rerankerRerankSym,
+			r.handle,
+			qPtr,
+			uintptr(unsafe.Pointer(&cStrs[0])),
+			uintptr(len(documents)),
+			uintptr(unsafe.Pointer(&results)),
+		)
+	} else {
+		r1, _, _ = purego.SyscallN(
+			_rerankerRerankTopKSym,
+			r.handle,
+			qPtr,
+			uintptr(unsafe.Pointer(&cStrs[0])),
+			uintptr(len(documents)),
+			uintptr(k),
+			uintptr(unsafe.Pointer(&results)),
+		)
+	}
 
 	code := int32(r1)
 	if code != 0 {
@@ -130,51 +265,100 @@ func (r *Reranker) Rerank(query string, documents []string) ([]RerankResult, err
 	return parseRerankResults(results, documents), nil
 }
 
-// RerankTopK scores all documents and returns the top k sorted by relevance.
-func (r *Reranker) RerankTopK(query string, documents []string, k int) ([]RerankResult, error) {
+// Tokenize returns the tokens the model sees for a single text, including
+// special tokens, with byte offsets into text. It does not truncate.
+func (r *Reranker) Tokenize(text string) ([]Token, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
 	if r.closed {
 		return nil, errors.New("reranker is closed")
 	}
+	return tokenizeWith(_rerankerTokenizeSym, "kjarni_reranker_tokenize", r.handle, text)
+}
 
-	if len(documents) == 0 {
-		return []RerankResult{}, nil
+// CountTokens returns the number of tokens in a single text, including
+// special tokens. Use CountPairTokens for the length the model scores.
+func (r *Reranker) CountTokens(text string) (int, error) {
+	tokens, err := r.Tokenize(text)
+	return len(tokens), err
+}
+
+// CountPairTokens returns the number of tokens in the combined
+// query-document input the cross-encoder scores. Pairs with more than
+// MaxTokens tokens are truncated by Score and Rerank.
+func (r *Reranker) CountPairTokens(query, document string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return 0, errors.New("reranker is closed")
 	}
+	return r.countPair(query, document)
+}
 
-	qPtr, keepQ := cString(query)
-	defer keepQ()
+// Truncate returns the longest prefix of text, ending at a token boundary,
+// that fits in maxTokens tokens including special tokens. A maxTokens of
+// zero uses MaxTokens. It fails with ErrInvalidConfig if maxTokens is less
+// than the number of special tokens.
+func (r *Reranker) Truncate(text string, maxTokens int) (string, error) {
+	if maxTokens <= 0 {
+		maxTokens = r.maxTokens
+	}
+	if maxTokens <= 0 {
+		return "", invalidConfig("model maximum length is unknown, pass maxTokens")
+	}
+	tokens, err := r.Tokenize(text)
+	if err != nil {
+		return "", err
+	}
+	return truncateTokens(text, tokens, maxTokens)
+}
 
-	cStrs := make([]uintptr, len(documents))
-	keeps := make([]func(), len(documents))
-	for i, d := range documents {
-		cStrs[i], keeps[i] = cString(d)
+// MaxTokens returns the model's maximum input length in tokens, or 0 if
+// the engine does not report it.
+func (r *Reranker) MaxTokens() int {
+	return r.maxTokens
+}
+
+// countPair makes a single engine call. The caller must hold r.mu.
+func (r *Reranker) countPair(query, document string) (int, error) {
+	if _rerankerCountPairTokensSym == 0 {
+		return 0, unsupported("kjarni_reranker_count_pair_tokens")
 	}
-	defer func() {
-		for _, k := range keeps {
-			k()
-		}
-	}()
 
-	var results ffiRerankResults
+	qPtr, keepQ := cString(query)
+	defer keepQ()
+	dPtr, keepD := cString(document)
+	defer keepD()
+
+	var count uintptr
 	r1, _, _ := purego.SyscallN(
-		_rerankerRerankTopKSym,
+		_rerankerCountPairTokensSym,
 		r.handle,
 		qPtr,
-		uintptr(unsafe.Pointer(&cStrs[0])),
-		uintptr(len(documents)),
-		uintptr(k),
-		uintptr(unsafe.Pointer(&results)),
+		dPtr,
+		uintptr(unsafe.Pointer(&count)),
 	)
 
 	code := int32(r1)
 	if code != 0 {
-		return nil, lastError(code)
+		return 0, lastError(code)
 	}
+	return int(count), nil
+}
 
-	defer freeRerankResults(results)
-	return parseRerankResults(results, documents), nil
+// checkLength returns an *InputTooLongError for the first document whose pair
+// with query is over the limit when truncation is off, numbering documents
+// from offset. It checks ctx before each document. The caller must hold
+// r.mu.
+func (r *Reranker) checkLength(ctx context.Context, offset int, query string, documents ...string) error {
+	if !r.strict {
+		return nil
+	}
+	return firstTooLong(ctx, offset, len(documents), r.maxTokens, func(i int) (int, error) {
+		return r.countPair(query, documents[i])
+	})
 }
 
 // Close releases the reranker resources. Safe to call multiple times.
@@ -1,9 +1,10 @@
 package kjarni
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"sync"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -21,10 +22,75 @@ const (
 	Hybrid SearchMode = 2
 )
 
+// SearchOptions controls a single query made with Searcher.SearchWithOptions.
+// The zero value searches in Keyword mode, returns up to 10 results and uses
+// the reranker if the searcher was created with one.
+type SearchOptions struct {
+	// Mode selects keyword, semantic or hybrid search.
+	Mode SearchMode
+	// TopK is the maximum number of results. Zero means 10.
+	TopK int
+	// Threshold drops results scoring below this value. Zero keeps all results.
+	Threshold float32
+	// DisableReranker skips cross-encoder reranking for this query.
+	DisableReranker bool
+	// SourcePattern restricts results to documents whose source path matches
+	// this glob, e.g. "docs/**/*.md".
+	SourcePattern string
+	// FilterKey and FilterValue restrict results to documents whose metadata
+	// has FilterKey set to FilterValue.
+	FilterKey   string
+	FilterValue string
+}
+
 // SearchResult holds a single search result with its relevance score.
+// DocumentID and Metadata identify the chunk the text was taken from.
+// RawMetadata is the metadata JSON as returned by the engine; if it is set
+// but Metadata is nil, the JSON could not be parsed.
 type SearchResult struct {
-	Score float32
-	Text  string
+	Score       float32
+	Text        string
+	DocumentID  string
+	Metadata    map[string]any
+	RawMetadata string
+}
+
+// Metadata keys under which the engine records where a chunk came from. Not
+// every index records all of them, so the accessors below report whether a
+// value was found.
+const (
+	MetaSource     = "source"
+	MetaChunkIndex = "chunk_index"
+	MetaStartByte  = "start_byte"
+	MetaEndByte    = "end_byte"
+)
+
+// Source returns the path of the file the result was taken from, or "" if unknown.
+func (r *SearchResult) Source() string {
+	s, _ := r.Metadata[MetaSource].(string)
+	return s
+}
+
+// ChunkIndex returns the position of the chunk within its source document.
+// The second return value is false if the index is not recorded.
+func (r *SearchResult) ChunkIndex() (int, bool) {
+	return r.metaInt(MetaChunkIndex)
+}
+
+// ByteRange returns the byte offsets of the chunk within its source document.
+// The third return value is false if the offsets are not recorded.
+func (r *SearchResult) ByteRange() (start, end int, ok bool) {
+	start, okStart := r.metaInt(MetaStartByte)
+	end, okEnd := r.metaInt(MetaEndByte)
+	if !okStart || !okEnd {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+func (r *SearchResult) metaInt(key string) (int, bool) {
+	v, ok := r.Metadata[key].(float64)
+	return int(v), ok
 }
 
 type ffiSearcherConfig struct {
@@ -67,7 +133,7 @@ type ffiSearchResults struct {
 // Searcher queries indexes created by an Indexer.
 type Searcher struct {
 	handle uintptr
-	mu     sync.Mutex
+	mu     handleLock
 	closed bool
 }
 
@@ -82,9 +148,14 @@ func NewSearcher(model string, rerankerModel string, opts ...Option) (*Searcher,
 	}
 
 	o := applyOptions(opts)
+	if err := o.validateSearcher(); err != nil {
+		return nil, err
+	}
 
 	modelStr, keepModel := cString(model)
 	defer keepModel()
+	cacheDirPtr, keepCacheDir := optionalCString(o.cacheDir)
+	defer keepCacheDir()
 
 	var rerankPtr uintptr
 	var keepRerank func()
@@ -95,6 +166,7 @@ func NewSearcher(model string, rerankerModel string, opts ...Option) (*Searcher,
 
 	var config ffiSearcherConfig
 	config.Device = deviceCode(o.device)
+	config.CacheDir = cacheDirPtr
 	config.ModelName = modelStr
 	config.RerankModel = rerankPtr
 	config.DefaultMode = int32(Hybrid)
@@ -117,7 +189,33 @@ func NewSearcher(model string, rerankerModel string, opts ...Option) (*Searcher,
 
 // Search queries the index at indexPath and returns results using the given mode.
 func (s *Searcher) Search(indexPath string, query string, mode SearchMode) ([]SearchResult, error) {
-	s.mu.Lock()
+	return s.SearchWithOptionsContext(context.Background(), indexPath, query, SearchOptions{Mode: mode})
+}
+
+// SearchContext is like Search but returns early with ErrCancelled or
+// ErrTimeout if ctx is done while waiting for the searcher.
+func (s *Searcher) SearchContext(ctx context.Context, indexPath string, query string, mode SearchMode) ([]SearchResult, error) {
+	return s.SearchWithOptionsContext(ctx, indexPath, query, SearchOptions{Mode: mode})
+}
+
+// SearchWithOptions queries the index at indexPath using the given options.
+func (s *Searcher) SearchWithOptions(indexPath string, query string, opts SearchOptions) ([]SearchResult, error) {
+	return s.SearchWithOptionsContext(context.Background(), indexPath, query, opts)
+}
+
+// SearchWithOptionsContext is like SearchWithOptions but returns early with
+// ErrCancelled or ErrTimeout if ctx is done while waiting for the searcher.
+func (s *Searcher) SearchWithOptionsContext(ctx context.Context, indexPath string, query string, opts SearchOptions) ([]SearchResult, error) {
+	if opts.TopK < 0 {
+		return nil, invalidConfig("top k must not be negative, got %d", opts.TopK)
+	}
+	if opts.FilterKey == "" && opts.FilterValue != "" {
+		return nil, invalidConfig("filter value %q given without a filter key", opts.FilterValue)
+	}
+
+	if err := s.mu.LockContext(ctx); err != nil {
+		return nil, err
+	}
 	defer s.mu.Unlock()
 
 	if s.closed {
@@ -129,10 +227,30 @@ func (s *Searcher) Search(indexPath string, query string, mode SearchMode) ([]Se
 	queryPtr, keepQuery := cString(query)
 	defer keepQuery()
 
+	topK := opts.TopK
+	if topK == 0 {
+		topK = 10
+	}
+
 	var searchOpts ffiSearchOptions
-	searchOpts.Mode = int32(mode)
-	searchOpts.TopK = 10
-	searchOpts.UseReranker = 1
+	searchOpts.Mode = int32(opts.Mode)
+	searchOpts.TopK = uintptr(topK)
+	searchOpts.UseReranker = boolToInt(!opts.DisableReranker)
+	searchOpts.Threshold = opts.Threshold
+
+	if opts.SourcePattern != "" {
+		ptr, keep := cString(opts.SourcePattern)
+		defer keep()
+		searchOpts.SourcePattern = ptr
+	}
+	if opts.FilterKey != "" {
+		keyPtr, keepKey := cString(opts.FilterKey)
+		defer keepKey()
+		valuePtr, keepValue := cString(opts.FilterValue)
+		defer keepValue()
+		searchOpts.FilterKey = keyPtr
+		searchOpts.FilterValue = valuePtr
+	}
 
 	var results ffiSearchResults
 	r1, _, _ := purego.SyscallN(
@@ -179,15 +297,32 @@ func parseSearchResults(results ffiSearchResults) []SearchResult {
 	for i := 0; i < count; i++ {
 		ptr := results.Results + uintptr(i)*structSize
 		item := (*ffiSearchResult)(unsafe.Pointer(ptr))
+		raw := goString(item.MetadataJson)
 		out[i] = SearchResult{
-			Score: item.Score,
-			Text:  goString(item.Text),
+			Score:       item.Score,
+			Text:        goString(item.Text),
+			DocumentID:  goString(item.DocumentId),
+			Metadata:    parseMetadata(raw),
+			RawMetadata: raw,
 		}
 	}
 
 	return out
 }
 
+// parseMetadata decodes the metadata JSON object attached to a result.
+// Missing or malformed metadata yields nil; the caller keeps the raw string.
+func parseMetadata(raw string) map[string]any {
+	if raw == "" {
+		return nil
+	}
+	var meta map[string]any
+	if err := json.Unmarshal([]byte(raw), &meta); err != nil {
+		return nil
+	}
+	return meta
+}
+
 func freeSearchResults(results ffiSearchResults) {
     if _searchResultsFreeSym != 0 {
         purego.SyscallN(_searchResultsFreeSym, uintptr(unsafe.Pointer(&results)))
@@ -0,0 +1,57 @@
+package kjarni
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want map[string]any
+	}{
+		{"", nil},
+		{"not json", nil},
+		{`["an", "array"]`, nil},
+		{`{"source": "a.md"`, nil},
+		{"null", nil},
+		{"{}", map[string]any{}},
+		{`{"source": "a.md", "chunk_index": 2, "tags": ["x"]}`,
+			map[string]any{"source": "a.md", "chunk_index": float64(2), "tags": []any{"x"}}},
+	}
+	for _, tt := range tests {
+		if got := parseMetadata(tt.raw); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %#v, want %#v", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestSearchResultAccessors(t *testing.T) {
+	tests := []struct {
+		raw        string
+		source     string
+		chunk      int
+		chunkOK    bool
+		start, end int
+		rangeOK    bool
+	}{
+		{"", "", 0, false, 0, 0, false},
+		{`{"source": "docs/a.md", "chunk_index": 3, "start_byte": 10, "end_byte": 42}`, "docs/a.md", 3, true, 10, 42, true},
+		{`{"chunk_index": 0, "start_byte": 5}`, "", 0, true, 0, 0, false},
+		// values of the wrong type count as missing
+		{`{"source": 7, "chunk_index": "3", "start_byte": 1, "end_byte": null}`, "", 0, false, 0, 0, false},
+		{"malformed", "", 0, false, 0, 0, false},
+	}
+	for _, tt := range tests {
+		r := SearchResult{Metadata: parseMetadata(tt.raw), RawMetadata: tt.raw}
+		if got := r.Source(); got != tt.source {
+			t.Errorf("%q: Source %q, want %q", tt.raw, got, tt.source)
+		}
+		if got, ok := r.ChunkIndex(); got != tt.chunk || ok != tt.chunkOK {
+			t.Errorf("%q: ChunkIndex %d, %v, want %d, %v", tt.raw, got, ok, tt.chunk, tt.chunkOK)
+		}
+		if start, end, ok := r.ByteRange(); start != tt.start || end != tt.end || ok != tt.rangeOK {
+			t.Errorf("%q: ByteRange %d, %d, %v, want %d, %d, %v", tt.raw, start, end, ok, tt.start, tt.end, tt.rangeOK)
+		}
+	}
+}
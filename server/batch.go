@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// batchedEmbedder sends single-text requests through a kjarni.Batcher, so
+// concurrent requests for one text each share engine calls. Requests with
+// several inputs are already a batch and go straight to the embedder.
+type batchedEmbedder struct {
+	Embedder
+	batcher *kjarni.Batcher
+}
+
+// batchEmbedders returns a copy of embedders with each embedder wrapped in
+// a batcher. Aliases of one embedder share its batcher.
+func batchEmbedders(embedders map[string]Embedder, delay time.Duration) map[string]Embedder {
+	wrapped := make(map[Embedder]Embedder, len(embedders))
+	out := make(map[string]Embedder, len(embedders))
+	for name, e := range embedders {
+		if _, ok := wrapped[e]; !ok {
+			wrapped[e] = newBatchedEmbedder(e, delay)
+		}
+		out[name] = wrapped[e]
+	}
+	return out
+}
+
+// newBatchedEmbedder wraps e with a batcher that waits up to delay for a
+// batch to fill. Pools encode as many batches at once as they have handles.
+func newBatchedEmbedder(e Embedder, delay time.Duration) *batchedEmbedder {
+	cfg := kjarni.BatcherConfig{MaxDelay: delay}
+	if p, ok := e.(interface{ Size() int }); ok {
+		cfg.MaxConcurrent = p.Size()
+	}
+	// the config is valid for any positive delay
+	b, _ := kjarni.NewBatcher(e, cfg)
+	return &batchedEmbedder{Embedder: e, batcher: b}
+}
+
+func (b *batchedEmbedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) != 1 {
+		return b.Embedder.EncodeBatchContext(ctx, texts)
+	}
+	vec, err := b.batcher.EncodeContext(ctx, texts[0])
+	if err != nil {
+		return nil, err
+	}
+	return [][]float32{vec}, nil
+}
+
+// Close waits for batches in progress, then closes the embedder.
+func (b *batchedEmbedder) Close() error {
+	b.batcher.Close()
+	return b.Embedder.Close()
+}
+
+// CountTokens forwards to the embedder's tokenizer, if it has one.
+func (b *batchedEmbedder) CountTokens(text string) (int, error) {
+	tc, ok := b.Embedder.(tokenCounter)
+	if !ok {
+		return 0, errors.New("embedder has no tokenizer")
+	}
+	return tc.CountTokens(text)
+}
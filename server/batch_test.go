@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchDelay(t *testing.T) {
+	tests := []struct {
+		delay     time.Duration
+		requests  int
+		wantCalls func(int32) bool
+	}{
+		{0, 16, func(n int32) bool { return n == 16 }},
+		{200 * time.Millisecond, 16, func(n int32) bool { return n < 16 }},
+	}
+	for _, tt := range tests {
+		e := &fakeEmbedder{}
+		srv, err := New(Config{
+			Embedders:  map[string]Embedder{"m": e, "alias": e},
+			BatchDelay: tt.delay,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < tt.requests; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				text := strings.Repeat("x", i+1)
+				body := `{"model": "m", "input": "` + text + `"}`
+				rec := httptest.NewRecorder()
+				srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embed", strings.NewReader(body)))
+				var resp embedResponse
+				if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+					t.Errorf("request %d: status %d: %s", i, rec.Code, rec.Body)
+					return
+				}
+				if len(resp.Embeddings) != 1 || resp.Embeddings[0][0] != float32(len(text)) {
+					t.Errorf("request %d: got %v", i, resp.Embeddings)
+				}
+			}(i)
+		}
+		wg.Wait()
+		if n := e.calls.Load(); !tt.wantCalls(n) {
+			t.Errorf("delay %v: %d engine calls for %d requests", tt.delay, n, tt.requests)
+		}
+
+		srv.Close()
+		if e.closed.Load() == 0 {
+			t.Errorf("delay %v: embedder not closed", tt.delay)
+		}
+	}
+}
+
+func TestBatchDelayArrayInput(t *testing.T) {
+	e := &fakeEmbedder{}
+	srv, err := New(Config{Embedders: map[string]Embedder{"m": e}, BatchDelay: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	// an array input must not wait for the batch delay
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embed", strings.NewReader(`{"input": ["a", "bb"]}`)))
+	if rec.Code != http.StatusOK || e.calls.Load() != 1 {
+		t.Fatalf("status %d, %d calls: %s", rec.Code, e.calls.Load(), rec.Body)
+	}
+}
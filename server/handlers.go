@@ -0,0 +1,177 @@
+package server
+
+import (
+	"net/http"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+type classifyRequest struct {
+	Model string `json:"model"`
+	Input Inputs `json:"input"`
+}
+
+type classifyResponse struct {
+	Model   string                   `json:"model"`
+	Results []*kjarni.ClassifyResult `json:"results"`
+}
+
+func (s *Server) handleClassify(w http.ResponseWriter, r *http.Request) {
+	var req classifyRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkInputs("input", req.Input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	c, name, err := pick("classifier", s.cfg.Classifiers, req.Model)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	results, err := c.ClassifyBatchContext(r.Context(), req.Input)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, classifyResponse{Model: name, Results: results})
+}
+
+type embedRequest struct {
+	Model string `json:"model"`
+	Input Inputs `json:"input"`
+}
+
+type embedResponse struct {
+	Model      string      `json:"model"`
+	Dimension  int         `json:"dimension"`
+	Embeddings [][]float32 `json:"embeddings"`
+}
+
+func (s *Server) handleEmbed(w http.ResponseWriter, r *http.Request) {
+	var req embedRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkInputs("input", req.Input); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	e, name, err := pick("embedder", s.cfg.Embedders, req.Model)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	vecs, err := e.EncodeBatchContext(r.Context(), req.Input)
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, embedResponse{Model: name, Dimension: e.Dim(), Embeddings: vecs})
+}
+
+type rerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopK      int      `json:"top_k"`
+}
+
+type rerankResponse struct {
+	Model   string                `json:"model"`
+	Results []kjarni.RerankResult `json:"results"`
+}
+
+func (s *Server) handleRerank(w http.ResponseWriter, r *http.Request) {
+	var req rerankRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := s.checkInputs("documents", req.Documents); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.TopK < 0 {
+		writeError(w, http.StatusBadRequest, "top_k must not be negative")
+		return
+	}
+	rr, name, err := pick("reranker", s.cfg.Rerankers, req.Model)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	var results []kjarni.RerankResult
+	if req.TopK > 0 {
+		results, err = rr.RerankTopKContext(r.Context(), req.Query, req.Documents, req.TopK)
+	} else {
+		results, err = rr.RerankContext(r.Context(), req.Query, req.Documents)
+	}
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, rerankResponse{Model: name, Results: results})
+}
+
+type searchRequest struct {
+	Index           string  `json:"index"`
+	Query           string  `json:"query"`
+	Mode            string  `json:"mode"`
+	TopK            int     `json:"top_k"`
+	Threshold       float32 `json:"threshold"`
+	DisableReranker bool    `json:"disable_reranker"`
+	SourcePattern   string  `json:"source_pattern"`
+	FilterKey       string  `json:"filter_key"`
+	FilterValue     string  `json:"filter_value"`
+}
+
+type searchResponse struct {
+	Index   string                `json:"index"`
+	Results []kjarni.SearchResult `json:"results"`
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var req searchRequest
+	if err := decode(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	mode := kjarni.Hybrid
+	if req.Mode != "" {
+		if err := mode.UnmarshalText([]byte(req.Mode)); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if s.cfg.Searcher == nil {
+		writeError(w, http.StatusNotFound, "no searcher configured")
+		return
+	}
+	path, name, err := pick("index", s.cfg.Indexes, req.Index)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	results, err := s.cfg.Searcher.SearchWithOptionsContext(r.Context(), path, req.Query, kjarni.SearchOptions{
+		Mode:            mode,
+		TopK:            req.TopK,
+		Threshold:       req.Threshold,
+		DisableReranker: req.DisableReranker,
+		SourcePattern:   req.SourcePattern,
+		FilterKey:       req.FilterKey,
+		FilterValue:     req.FilterValue,
+	})
+	if err != nil {
+		writeEngineError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, searchResponse{Index: name, Results: results})
+}
@@ -0,0 +1,223 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"unicode"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// OpenAIEmbeddings serves the OpenAI embeddings API shape, so existing
+// OpenAI clients can use kjarni embedders by pointing their base URL at the
+// server. Server registers it at /v1/embeddings; it can also be mounted on
+// any mux.
+//
+// Requests accept input as a string or an array of strings, and
+// encoding_format "float" (the default) or "base64" (little-endian float32).
+// Token arrays are not supported. Usage counts are estimated from word and
+// punctuation boundaries unless ExactUsage is set.
+type OpenAIEmbeddings struct {
+	// Embedders maps request model names to embedders. Map several names to
+	// the same embedder to serve aliases.
+	Embedders map[string]Embedder
+	// MaxBatchSize caps the number of inputs in one request. Defaults to 256.
+	MaxBatchSize int
+	// ExactUsage counts usage tokens with the model's tokenizer when the
+	// embedder exposes CountTokens and the engine supports it. This costs
+	// one extra engine call per input, made after the embeddings are ready.
+	ExactUsage bool
+}
+
+type openAIEmbeddingsRequest struct {
+	Input          json.RawMessage `json:"input"`
+	Model          string          `json:"model"`
+	EncodingFormat string          `json:"encoding_format"`
+	Dimensions     int             `json:"dimensions"`
+	User           string          `json:"user"`
+}
+
+type openAIEmbeddingsResponse struct {
+	Object string            `json:"object"`
+	Data   []openAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  openAIUsage       `json:"usage"`
+}
+
+type openAIEmbedding struct {
+	Object    string `json:"object"`
+	Embedding any    `json:"embedding"`
+	Index     int    `json:"index"`
+}
+
+type openAIUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+type openAIErrorBody struct {
+	Error openAIError `json:"error"`
+}
+
+type openAIError struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    *string `json:"code"`
+}
+
+// ServeHTTP implements http.Handler.
+func (h *OpenAIEmbeddings) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "method not allowed", "")
+		return
+	}
+
+	var req openAIEmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid request body: "+err.Error(), "")
+		return
+	}
+
+	var input Inputs
+	if err := json.Unmarshal(req.Input, &input); err != nil || len(req.Input) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "input must be a string or an array of strings", "input")
+		return
+	}
+	maxBatch := h.MaxBatchSize
+	if maxBatch == 0 {
+		maxBatch = 256
+	}
+	if len(input) == 0 || len(input) > maxBatch {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("input must have between 1 and %d items", maxBatch), "input")
+		return
+	}
+	for _, in := range input {
+		if in == "" {
+			writeOpenAIError(w, http.StatusBadRequest, "input must not contain empty strings", "input")
+			return
+		}
+	}
+
+	if req.EncodingFormat != "" && req.EncodingFormat != "float" && req.EncodingFormat != "base64" {
+		writeOpenAIError(w, http.StatusBadRequest, "encoding_format must be float or base64", "encoding_format")
+		return
+	}
+
+	e, ok := h.Embedders[req.Model]
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, fmt.Sprintf("the model %q does not exist, available models: %v", req.Model, keys(h.Embedders)), "model")
+		return
+	}
+	if req.Dimensions != 0 && req.Dimensions != e.Dim() {
+		writeOpenAIError(w, http.StatusBadRequest, fmt.Sprintf("model %q only supports %d dimensions", req.Model, e.Dim()), "dimensions")
+		return
+	}
+
+	vecs, err := e.EncodeBatchContext(r.Context(), input)
+	if err != nil {
+		status := http.StatusInternalServerError
+		var kerr *kjarni.KjarniError
+		if errors.As(err, &kerr) {
+			status = engineStatus(kerr)
+		}
+		writeOpenAIError(w, status, err.Error(), "")
+		return
+	}
+
+	resp := openAIEmbeddingsResponse{
+		Object: "list",
+		Data:   make([]openAIEmbedding, len(vecs)),
+		Model:  req.Model,
+	}
+	for i, v := range vecs {
+		var emb any = v
+		if req.EncodingFormat == "base64" {
+			emb = encodeBase64(v)
+		}
+		resp.Data[i] = openAIEmbedding{Object: "embedding", Embedding: emb, Index: i}
+	}
+	resp.Usage.PromptTokens = h.usage(e, input)
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func encodeBase64(v []float32) string {
+	buf := make([]byte, 4*len(v))
+	for i, x := range v {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+type tokenCounter interface {
+	CountTokens(text string) (int, error)
+}
+
+// usage returns the total token count of input. With ExactUsage it uses the
+// embedder's tokenizer, falling back to estimateTokens for the whole request
+// if the tokenizer is missing or fails.
+func (h *OpenAIEmbeddings) usage(e Embedder, input []string) int {
+	if tc, ok := e.(tokenCounter); ok && h.ExactUsage {
+		if n, err := countTokens(tc, input); err == nil {
+			return n
+		}
+	}
+	total := 0
+	for _, s := range input {
+		total += estimateTokens(s)
+	}
+	return total
+}
+
+func countTokens(tc tokenCounter, input []string) (int, error) {
+	total := 0
+	for _, s := range input {
+		n, err := tc.CountTokens(s)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// estimateTokens approximates a subword token count: one token per four
+// characters of each word, plus one per punctuation mark.
+func estimateTokens(s string) int {
+	var tokens, word int
+	flush := func() {
+		tokens += (word + 3) / 4
+		word = 0
+	}
+	for _, r := range s {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word++
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			flush()
+			tokens++
+		}
+	}
+	flush()
+	return tokens
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, msg, param string) {
+	body := openAIErrorBody{Error: openAIError{Message: msg, Type: "invalid_request_error"}}
+	if status >= 500 {
+		body.Error.Type = "server_error"
+	}
+	if param != "" {
+		body.Error.Param = &param
+	}
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// fakeEmbedder returns [len(text)] for each text and counts engine calls.
+type fakeEmbedder struct {
+	calls  atomic.Int32
+	closed atomic.Int32
+}
+
+func (f *fakeEmbedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	f.calls.Add(1)
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = []float32{float32(len(t))}
+	}
+	return out, nil
+}
+
+func (f *fakeEmbedder) Dim() int     { return 1 }
+func (f *fakeEmbedder) Close() error { f.closed.Add(1); return nil }
+
+// countingEmbedder is a fakeEmbedder with a tokenizer that counts one token
+// per byte, or fails with err from the engine call.
+type countingEmbedder struct {
+	fakeEmbedder
+	err    error
+	counts int
+}
+
+func (c *countingEmbedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.fakeEmbedder.EncodeBatchContext(ctx, texts)
+}
+
+func (c *countingEmbedder) CountTokens(text string) (int, error) {
+	c.counts++
+	return len(text), nil
+}
+
+func TestOpenAIUsage(t *testing.T) {
+	tests := []struct {
+		exact      bool
+		wantTokens int
+		wantCounts int
+	}{
+		{false, 5, 0}, // "hello" and "world" estimate to 2 tokens each, "x" to 1
+		{true, 11, 3},
+	}
+	for _, tt := range tests {
+		e := &countingEmbedder{}
+		h := &OpenAIEmbeddings{Embedders: map[string]Embedder{"m": e}, ExactUsage: tt.exact}
+		rec := httptest.NewRecorder()
+		body := `{"model": "m", "input": ["hello", "world", "x"]}`
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+		var resp openAIEmbeddingsResponse
+		json.Unmarshal(rec.Body.Bytes(), &resp)
+		if resp.Usage.PromptTokens != tt.wantTokens || e.counts != tt.wantCounts {
+			t.Errorf("exact=%t: %d tokens from %d tokenizer calls, want %d from %d",
+				tt.exact, resp.Usage.PromptTokens, e.counts, tt.wantTokens, tt.wantCounts)
+		}
+	}
+}
+
+func TestOpenAIEngineErrors(t *testing.T) {
+	tests := []struct {
+		code   kjarni.ErrorCode
+		status int
+	}{
+		{kjarni.ErrInputTooLong, http.StatusBadRequest},
+		{kjarni.ErrTimeout, http.StatusGatewayTimeout},
+		{kjarni.ErrCancelled, http.StatusServiceUnavailable},
+		{kjarni.ErrGpuUnavailable, http.StatusServiceUnavailable},
+		{kjarni.ErrInferenceFailed, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		e := &countingEmbedder{err: &kjarni.KjarniError{Code: tt.code, Message: "failed"}}
+		h := &OpenAIEmbeddings{Embedders: map[string]Embedder{"m": e}}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(`{"model": "m", "input": "hi"}`)))
+		if rec.Code != tt.status {
+			t.Errorf("code %d: status %d, want %d", tt.code, rec.Code, tt.status)
+		}
+	}
+}
+
+// vecEmbedder returns [len(text), -0.5, 1/3] for each text.
+type vecEmbedder struct{ fakeEmbedder }
+
+func (v *vecEmbedder) EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error) {
+	out := make([][]float32, len(texts))
+	for i, t := range texts {
+		out[i] = []float32{float32(len(t)), -0.5, 1.0 / 3}
+	}
+	return out, nil
+}
+
+func (v *vecEmbedder) Dim() int { return 3 }
+
+func TestOpenAIEncodingFormat(t *testing.T) {
+	for _, format := range []string{"", "float", "base64"} {
+		h := &OpenAIEmbeddings{Embedders: map[string]Embedder{"m": &vecEmbedder{}}}
+		body := `{"model": "m", "input": ["a", "bbb"], "encoding_format": "` + format + `"}`
+		if format == "" {
+			body = `{"model": "m", "input": ["a", "bbb"]}`
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(body)))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("format %q: status %d: %s", format, rec.Code, rec.Body)
+		}
+		var resp struct {
+			Object string `json:"object"`
+			Data   []struct {
+				Object    string          `json:"object"`
+				Embedding json.RawMessage `json:"embedding"`
+				Index     int             `json:"index"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || resp.Object != "list" || len(resp.Data) != 2 {
+			t.Fatalf("format %q: %v: %s", format, err, rec.Body)
+		}
+		for i, d := range resp.Data {
+			var got []float32
+			if format == "base64" {
+				var s string
+				if err := json.Unmarshal(d.Embedding, &s); err != nil {
+					t.Fatalf("format %q: embedding is not a string: %s", format, d.Embedding)
+				}
+				raw, err := base64.StdEncoding.DecodeString(s)
+				if err != nil || len(raw) != 12 {
+					t.Fatalf("format %q: %d bytes, %v", format, len(raw), err)
+				}
+				for j := 0; j < len(raw); j += 4 {
+					got = append(got, math.Float32frombits(binary.LittleEndian.Uint32(raw[j:])))
+				}
+			} else if err := json.Unmarshal(d.Embedding, &got); err != nil {
+				t.Fatalf("format %q: embedding is not an array: %s", format, d.Embedding)
+			}
+			want := []float32{[]float32{1, 3}[i], -0.5, 1.0 / 3}
+			if d.Index != i || d.Object != "embedding" || len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+				t.Errorf("format %q: item %d: index %d, embedding %v, want %v", format, i, d.Index, got, want)
+			}
+		}
+	}
+}
+
+func TestOpenAIInputShapes(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		items  int
+	}{
+		{"string", `{"model": "m", "input": "hello"}`, 200, 1},
+		{"array", `{"model": "m", "input": ["a", "b", "c"]}`, 200, 3},
+		{"empty array", `{"model": "m", "input": []}`, 400, 0},
+		{"empty string", `{"model": "m", "input": ["a", ""]}`, 400, 0},
+		{"token array", `{"model": "m", "input": [1, 2, 3]}`, 400, 0},
+		{"token arrays", `{"model": "m", "input": [[1, 2]]}`, 400, 0},
+		{"number", `{"model": "m", "input": 3}`, 400, 0},
+		{"missing", `{"model": "m"}`, 400, 0},
+		{"too many", `{"model": "m", "input": ["a", "b", "c", "d", "e"]}`, 400, 0},
+		{"bad format", `{"model": "m", "input": "a", "encoding_format": "int8"}`, 400, 0},
+		{"unknown model", `{"model": "x", "input": "a"}`, 404, 0},
+		{"wrong dimensions", `{"model": "m", "input": "a", "dimensions": 3}`, 400, 0},
+	}
+	for _, tt := range tests {
+		h := &OpenAIEmbeddings{Embedders: map[string]Embedder{"m": &fakeEmbedder{}}, MaxBatchSize: 4}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/v1/embeddings", strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, tt.status, rec.Body)
+			continue
+		}
+		var resp openAIEmbeddingsResponse
+		var errResp openAIErrorBody
+		if tt.status == http.StatusOK {
+			json.Unmarshal(rec.Body.Bytes(), &resp)
+		} else if json.Unmarshal(rec.Body.Bytes(), &errResp); errResp.Error.Type != "invalid_request_error" || errResp.Error.Message == "" {
+			t.Errorf("%s: error body %s", tt.name, rec.Body)
+		}
+		if len(resp.Data) != tt.items {
+			t.Errorf("%s: %d items, want %d", tt.name, len(resp.Data), tt.items)
+		}
+	}
+}
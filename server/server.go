@@ -0,0 +1,357 @@
+// Package server serves kjarni classifiers, embedders, rerankers and
+// searchers over HTTP with JSON request and response bodies.
+//
+// Endpoints:
+//
+//	POST /v1/classify  {"model": "...", "input": "text" | ["text", ...]}
+//	POST /v1/embed     {"model": "...", "input": "text" | ["text", ...]}
+//	POST /v1/rerank    {"model": "...", "query": "...", "documents": [...], "top_k": 3}
+//	POST /v1/search    {"index": "...", "query": "...", "mode": "hybrid", "top_k": 10}
+//	POST /v1/embeddings  OpenAI-compatible embeddings, see OpenAIEmbeddings
+//	GET  /healthz      200 while the process is running
+//	GET  /readyz       200 while accepting requests, 503 once shutdown begins
+//
+// The model field may be omitted when only one model of that kind is
+// configured. Array inputs are passed to the engine as a single batch. With
+// Config.BatchDelay set, concurrent single-text embedding requests are also
+// collected into batches across requests.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// Classifier is the part of *kjarni.Classifier and *kjarni.ClassifierPool
+// the server uses.
+type Classifier interface {
+	ClassifyBatchContext(ctx context.Context, texts []string) ([]*kjarni.ClassifyResult, error)
+	Close() error
+}
+
+// Embedder is the part of *kjarni.Embedder and *kjarni.EmbedderPool the
+// server uses.
+type Embedder interface {
+	EncodeBatchContext(ctx context.Context, texts []string) ([][]float32, error)
+	Dim() int
+	Close() error
+}
+
+// Reranker is the part of *kjarni.Reranker and *kjarni.RerankerPool the
+// server uses.
+type Reranker interface {
+	RerankContext(ctx context.Context, query string, documents []string) ([]kjarni.RerankResult, error)
+	RerankTopKContext(ctx context.Context, query string, documents []string, k int) ([]kjarni.RerankResult, error)
+	Close() error
+}
+
+// Searcher is the part of *kjarni.Searcher and *kjarni.SearcherPool the
+// server uses.
+type Searcher interface {
+	SearchWithOptionsContext(ctx context.Context, indexPath string, query string, opts kjarni.SearchOptions) ([]kjarni.SearchResult, error)
+	Close() error
+}
+
+// Config lists the handles a Server exposes, keyed by the name clients use
+// in the model field. The Server takes ownership of every handle and closes
+// them in Close.
+type Config struct {
+	Classifiers map[string]Classifier
+	Embedders   map[string]Embedder
+	Rerankers   map[string]Reranker
+
+	// Searcher queries the indexes named in Indexes. Clients refer to
+	// indexes by name, never by path.
+	Searcher Searcher
+	Indexes  map[string]string
+
+	// MaxBatchSize caps the number of inputs in one request. Defaults to 256.
+	MaxBatchSize int
+	// ExactUsage makes /v1/embeddings count usage tokens with each
+	// embedder's tokenizer instead of estimating them. See
+	// OpenAIEmbeddings.ExactUsage.
+	ExactUsage bool
+	// BatchDelay, if positive, holds single-text requests to /v1/embed and
+	// /v1/embeddings for up to this long so that concurrent requests to the
+	// same embedder are encoded together, trading a little latency for
+	// throughput. Zero encodes each request on its own.
+	BatchDelay time.Duration
+	// MaxBodyBytes caps the request body size. Defaults to 10 MiB.
+	MaxBodyBytes int64
+	// ShutdownTimeout bounds how long ListenAndServe waits for in-flight
+	// requests after its context is done. Defaults to 30 seconds.
+	ShutdownTimeout time.Duration
+}
+
+// Server is an http.Handler serving the configured handles.
+type Server struct {
+	cfg       Config
+	mux       *http.ServeMux
+	ready     atomic.Bool
+	closeOnce sync.Once
+}
+
+// New creates a server for the given configuration. At least one handle
+// must be configured.
+func New(cfg Config) (*Server, error) {
+	if len(cfg.Classifiers)+len(cfg.Embedders)+len(cfg.Rerankers) == 0 && cfg.Searcher == nil {
+		return nil, errors.New("server: no models configured")
+	}
+	if cfg.Searcher == nil && len(cfg.Indexes) > 0 {
+		return nil, errors.New("server: indexes configured without a searcher")
+	}
+	if cfg.MaxBatchSize == 0 {
+		cfg.MaxBatchSize = 256
+	}
+	if cfg.MaxBodyBytes == 0 {
+		cfg.MaxBodyBytes = 10 << 20
+	}
+	if cfg.ShutdownTimeout == 0 {
+		cfg.ShutdownTimeout = 30 * time.Second
+	}
+	if cfg.BatchDelay < 0 {
+		return nil, fmt.Errorf("server: BatchDelay must not be negative, got %v", cfg.BatchDelay)
+	}
+	if cfg.BatchDelay > 0 {
+		cfg.Embedders = batchEmbedders(cfg.Embedders, cfg.BatchDelay)
+	}
+
+	s := &Server{cfg: cfg, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.HandleFunc("/v1/classify", s.post(s.handleClassify))
+	s.mux.HandleFunc("/v1/embed", s.post(s.handleEmbed))
+	s.mux.HandleFunc("/v1/rerank", s.post(s.handleRerank))
+	s.mux.HandleFunc("/v1/search", s.post(s.handleSearch))
+	if len(cfg.Embedders) > 0 {
+		s.mux.Handle("/v1/embeddings", http.MaxBytesHandler(&OpenAIEmbeddings{
+			Embedders:    cfg.Embedders,
+			MaxBatchSize: cfg.MaxBatchSize,
+			ExactUsage:   cfg.ExactUsage,
+		}, cfg.MaxBodyBytes))
+	}
+	s.ready.Store(true)
+	return s, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Handle registers an additional handler on the server's mux.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// ListenAndServe serves on addr until ctx is done, then marks the server
+// not ready, waits up to ShutdownTimeout for in-flight requests, and closes
+// every handle.
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ctx, ln)
+}
+
+// Serve is like ListenAndServe but accepts connections on ln.
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve(ln) }()
+
+	select {
+	case err := <-errc:
+		s.Close()
+		return err
+	case <-ctx.Done():
+	}
+
+	s.ready.Store(false)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	err := srv.Shutdown(shutdownCtx)
+	if cerr := s.Close(); err == nil {
+		err = cerr
+	}
+	<-errc
+	return err
+}
+
+// Close marks the server not ready and releases every configured handle.
+// Safe to call multiple times.
+func (s *Server) Close() error {
+	var errs []error
+	s.closeOnce.Do(func() {
+		s.ready.Store(false)
+		for _, c := range s.cfg.Classifiers {
+			errs = append(errs, c.Close())
+		}
+		for _, e := range s.cfg.Embedders {
+			errs = append(errs, e.Close())
+		}
+		for _, r := range s.cfg.Rerankers {
+			errs = append(errs, r.Close())
+		}
+		if s.cfg.Searcher != nil {
+			errs = append(errs, s.cfg.Searcher.Close())
+		}
+	})
+	return errors.Join(errs...)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "shutting down"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"status":      "ready",
+		"classifiers": keys(s.cfg.Classifiers),
+		"embedders":   keys(s.cfg.Embedders),
+		"rerankers":   keys(s.cfg.Rerankers),
+		"indexes":     keys(s.cfg.Indexes),
+	})
+}
+
+// post restricts h to POST requests and limits the body size.
+func (s *Server) post(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxBodyBytes)
+		h(w, r)
+	}
+}
+
+// Inputs is a JSON value that may be a single string or an array of strings.
+type Inputs []string
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (in *Inputs) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*in = Inputs{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return errors.New("input must be a string or an array of strings")
+	}
+	*in = many
+	return nil
+}
+
+// checkInputs validates the size of the named request field.
+func (s *Server) checkInputs(field string, in []string) error {
+	if len(in) == 0 {
+		return fmt.Errorf("%s must not be empty", field)
+	}
+	if len(in) > s.cfg.MaxBatchSize {
+		return fmt.Errorf("%s has %d items, maximum is %d", field, len(in), s.cfg.MaxBatchSize)
+	}
+	return nil
+}
+
+// pick returns the handle named model, or the only handle if model is empty.
+func pick[T any](kind string, m map[string]T, model string) (T, string, error) {
+	var zero T
+	if model == "" {
+		if len(m) == 1 {
+			for name, v := range m {
+				return v, name, nil
+			}
+		}
+		if len(m) == 0 {
+			return zero, "", fmt.Errorf("no %s configured", kind)
+		}
+		return zero, "", fmt.Errorf("model is required, available %ss: %v", kind, keys(m))
+	}
+	v, ok := m[model]
+	if !ok {
+		return zero, "", fmt.Errorf("unknown %s %q", kind, model)
+	}
+	return v, model, nil
+}
+
+func keys[T any](m map[string]T) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func decode(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+type errorBody struct {
+	Error errorDetail `json:"error"`
+}
+
+type errorDetail struct {
+	Message string `json:"message"`
+	Code    int32  `json:"code,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, errorBody{Error: errorDetail{Message: msg}})
+}
+
+// writeEngineError maps a kjarni error to an HTTP status.
+func writeEngineError(w http.ResponseWriter, err error) {
+	var kerr *kjarni.KjarniError
+	if !errors.As(err, &kerr) {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, engineStatus(kerr), errorBody{Error: errorDetail{Message: kerr.Message, Code: int32(kerr.Code)}})
+}
+
+// engineStatus returns the HTTP status for a kjarni error code.
+func engineStatus(kerr *kjarni.KjarniError) int {
+	switch kerr.Code {
+	case kjarni.ErrInvalidUtf8, kjarni.ErrInvalidConfig, kjarni.ErrInputTooLong:
+		return http.StatusBadRequest
+	case kjarni.ErrTimeout:
+		return http.StatusGatewayTimeout
+	case kjarni.ErrCancelled, kjarni.ErrGpuUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
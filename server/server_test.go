@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	kjarni "github.com/olafurjohannsson/kjarni-go"
+)
+
+// fakeClassifier labels each text with itself.
+type fakeClassifier struct {
+	closed atomic.Int32
+}
+
+func (f *fakeClassifier) ClassifyBatchContext(ctx context.Context, texts []string) ([]*kjarni.ClassifyResult, error) {
+	out := make([]*kjarni.ClassifyResult, len(texts))
+	for i, t := range texts {
+		out[i] = &kjarni.ClassifyResult{Label: t, Score: 1}
+	}
+	return out, nil
+}
+
+func (f *fakeClassifier) Close() error { f.closed.Add(1); return nil }
+
+// fakeReranker scores document i as i and records whether top-k was used.
+type fakeReranker struct {
+	topK   int
+	closed atomic.Int32
+}
+
+func (f *fakeReranker) RerankContext(ctx context.Context, query string, documents []string) ([]kjarni.RerankResult, error) {
+	f.topK = -1
+	return f.rerank(documents, len(documents)), nil
+}
+
+func (f *fakeReranker) RerankTopKContext(ctx context.Context, query string, documents []string, k int) ([]kjarni.RerankResult, error) {
+	f.topK = k
+	return f.rerank(documents, k), nil
+}
+
+func (f *fakeReranker) rerank(documents []string, k int) []kjarni.RerankResult {
+	out := []kjarni.RerankResult{}
+	for i := len(documents) - 1; i >= 0 && len(out) < k; i-- {
+		out = append(out, kjarni.RerankResult{Index: i, Score: float32(i), Document: documents[i]})
+	}
+	return out
+}
+
+func (f *fakeReranker) Close() error { f.closed.Add(1); return nil }
+
+// fakeSearcher records its last query, fails with err, and, if release is
+// set, blocks until it is closed, signalling started first.
+type fakeSearcher struct {
+	err     error
+	path    string
+	opts    kjarni.SearchOptions
+	started chan struct{}
+	release chan struct{}
+	closed  atomic.Int32
+}
+
+func (f *fakeSearcher) SearchWithOptionsContext(ctx context.Context, indexPath string, query string, opts kjarni.SearchOptions) ([]kjarni.SearchResult, error) {
+	if f.release != nil {
+		close(f.started)
+		<-f.release
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.path, f.opts = indexPath, opts
+	return []kjarni.SearchResult{{Score: 0.5, Text: query, DocumentID: "doc"}}, nil
+}
+
+func (f *fakeSearcher) Close() error { f.closed.Add(1); return nil }
+
+func serve(srv http.Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestHandlers(t *testing.T) {
+	rr := &fakeReranker{}
+	searcher := &fakeSearcher{}
+	srv, err := New(Config{
+		Classifiers:  map[string]Classifier{"sentiment": &fakeClassifier{}},
+		Rerankers:    map[string]Reranker{"a": rr, "b": &fakeReranker{}},
+		Searcher:     searcher,
+		Indexes:      map[string]string{"docs": "/srv/index/docs"},
+		MaxBatchSize: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string // a substring of the response body
+	}{
+		{"classify string", "POST", "/v1/classify", `{"input": "hi"}`, 200, `"model":"sentiment"`},
+		{"classify array", "POST", "/v1/classify", `{"model": "sentiment", "input": ["a", "b"]}`, 200, `"label":"b"`},
+		{"classify empty", "POST", "/v1/classify", `{"input": []}`, 400, "must not be empty"},
+		{"classify too many", "POST", "/v1/classify", `{"input": ["a", "b", "c", "d"]}`, 400, "maximum is 3"},
+		{"classify bad input", "POST", "/v1/classify", `{"input": 3}`, 400, "string or an array"},
+		{"classify unknown field", "POST", "/v1/classify", `{"text": "hi"}`, 400, "unknown field"},
+		{"classify unknown model", "POST", "/v1/classify", `{"model": "x", "input": "hi"}`, 404, `unknown classifier`},
+		{"classify GET", "GET", "/v1/classify", ``, 405, "method not allowed"},
+		{"embed none configured", "POST", "/v1/embed", `{"input": "hi"}`, 404, "no embedder configured"},
+		{"rerank needs model", "POST", "/v1/rerank", `{"query": "q", "documents": ["a"]}`, 404, "model is required"},
+		{"rerank", "POST", "/v1/rerank", `{"model": "a", "query": "q", "documents": ["x", "y", "z"]}`, 200, `"index":2`},
+		{"rerank top k", "POST", "/v1/rerank", `{"model": "a", "query": "q", "documents": ["x", "y"], "top_k": 1}`, 200, `"document":"y"`},
+		{"rerank negative top k", "POST", "/v1/rerank", `{"model": "a", "query": "q", "documents": ["x"], "top_k": -1}`, 400, "top_k"},
+		{"search", "POST", "/v1/search", `{"query": "refunds", "mode": "semantic", "top_k": 5, "filter_key": "lang", "filter_value": "en"}`, 200, `"index":"docs"`},
+		{"search bad mode", "POST", "/v1/search", `{"query": "q", "mode": "fuzzy"}`, 400, "fuzzy"},
+		{"search unknown index", "POST", "/v1/search", `{"index": "/etc", "query": "q"}`, 404, "unknown index"},
+	}
+	for _, tt := range tests {
+		rec := serve(srv, tt.method, tt.path, tt.body)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%s: status %d, want %d with %q: %s", tt.name, rec.Code, tt.status, tt.want, rec.Body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: content type %q", tt.name, ct)
+		}
+	}
+
+	// the last rerank and search requests reached the handles as sent
+	serve(srv, "POST", "/v1/rerank", `{"model": "a", "query": "q", "documents": ["x"]}`)
+	if rr.topK != -1 {
+		t.Errorf("rerank without top_k used top k %d", rr.topK)
+	}
+	serve(srv, "POST", "/v1/search", `{"query": "q", "mode": "keyword", "top_k": 5, "threshold": 0.25, "source_pattern": "*.md"}`)
+	want := kjarni.SearchOptions{Mode: kjarni.Keyword, TopK: 5, Threshold: 0.25, SourcePattern: "*.md"}
+	if searcher.path != "/srv/index/docs" || searcher.opts != want {
+		t.Errorf("search got path %q, options %+v", searcher.path, searcher.opts)
+	}
+	serve(srv, "POST", "/v1/search", `{"query": "q"}`)
+	if searcher.opts.Mode != kjarni.Hybrid {
+		t.Errorf("default mode %v, want hybrid", searcher.opts.Mode)
+	}
+}
+
+func TestEngineErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		want   string
+	}{
+		{&kjarni.KjarniError{Code: kjarni.ErrInvalidConfig, Message: "bad filter"}, 400, `{"error":{"message":"bad filter","code":7}}`},
+		{&kjarni.KjarniError{Code: kjarni.ErrTimeout, Message: "timeout"}, 504, `"code":`},
+		{errors.New("disk failed"), 500, `{"error":{"message":"disk failed"}}`},
+	}
+	for _, tt := range tests {
+		srv, _ := New(Config{Searcher: &fakeSearcher{err: tt.err}, Indexes: map[string]string{"docs": "/d"}})
+		rec := serve(srv, "POST", "/v1/search", `{"query": "q"}`)
+		if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("%v: status %d, want %d with %s: %s", tt.err, rec.Code, tt.status, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	c := &fakeClassifier{}
+	srv, _ := New(Config{Classifiers: map[string]Classifier{"sentiment": c}})
+
+	rec := serve(srv, "GET", "/readyz", "")
+	var body struct {
+		Status      string   `json:"status"`
+		Classifiers []string `json:"classifiers"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != 200 || body.Status != "ready" || len(body.Classifiers) != 1 {
+		t.Errorf("before Close: status %d: %s", rec.Code, rec.Body)
+	}
+
+	srv.Close()
+	srv.Close()
+	if rec := serve(srv, "GET", "/readyz", ""); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("after Close: status %d", rec.Code)
+	}
+	if rec := serve(srv, "GET", "/healthz", ""); rec.Code != http.StatusOK {
+		t.Errorf("healthz after Close: status %d", rec.Code)
+	}
+	if c.closed.Load() != 1 {
+		t.Errorf("classifier closed %d times", c.closed.Load())
+	}
+}
+
+// startSlowSearch serves srv on a new listener until ctx is done and sends
+// it a search request that blocks in the searcher until release is closed.
+func startSlowSearch(t *testing.T, ctx context.Context, srv *Server, searcher *fakeSearcher) (served, requested chan error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served = make(chan error, 1)
+	go func() { served <- srv.Serve(ctx, ln) }()
+
+	requested = make(chan error, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/v1/search", "application/json", strings.NewReader(`{"query": "q"}`))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = errors.New(resp.Status)
+			}
+		}
+		requested <- err
+	}()
+	<-searcher.started
+	return served, requested
+}
+
+func TestServeDrains(t *testing.T) {
+	searcher := &fakeSearcher{started: make(chan struct{}), release: make(chan struct{})}
+	srv, _ := New(Config{Searcher: searcher, Indexes: map[string]string{"docs": "/d"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	served, requested := startSlowSearch(t, ctx, srv, searcher)
+
+	cancel()
+	// shutdown waits for the request, reporting not ready meanwhile
+	deadline := time.Now().Add(5 * time.Second)
+	for serve(srv, "GET", "/readyz", "").Code != http.StatusServiceUnavailable {
+		if time.Now().After(deadline) {
+			t.Fatal("still ready after the context was cancelled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned %v before the request finished", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if searcher.closed.Load() != 0 {
+		t.Fatal("searcher closed while a request was in flight")
+	}
+
+	close(searcher.release)
+	if err := <-requested; err != nil {
+		t.Errorf("in-flight request: %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	if searcher.closed.Load() != 1 {
+		t.Errorf("searcher closed %d times", searcher.closed.Load())
+	}
+}
+
+func TestServeShutdownTimeout(t *testing.T) {
+	searcher := &fakeSearcher{started: make(chan struct{}), release: make(chan struct{})}
+	srv, _ := New(Config{Searcher: searcher, Indexes: map[string]string{"docs": "/d"}, ShutdownTimeout: 10 * time.Millisecond})
+	ctx, cancel := context.WithCancel(context.Background())
+	served, requested := startSlowSearch(t, ctx, srv, searcher)
+
+	cancel()
+	if err := <-served; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Serve: got %v, want a deadline error", err)
+	}
+	close(searcher.release)
+	<-requested
+}
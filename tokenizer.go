@@ -0,0 +1,154 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// Token is a single token produced by a model's tokenizer. Start and End
+// are byte offsets into the tokenized text. Special tokens added by the
+// model, such as [CLS] and [SEP], have Special set and an empty range.
+type Token struct {
+	ID      uint32
+	Start   int
+	End     int
+	Special bool
+}
+
+// tokenizeWith runs the engine tokenizer entry point sym on text. The caller
+// must hold the lock for handle.
+func tokenizeWith(sym uintptr, name string, handle uintptr, text string) ([]Token, error) {
+	if sym == 0 || _tokensFreeSym == 0 {
+		return nil, unsupported(name)
+	}
+
+	textPtr, keepText := cString(text)
+	defer keepText()
+
+	var result ffiTokens
+	r1, _, _ := purego.SyscallN(
+		sym,
+		handle,
+		textPtr,
+		uintptr(unsafe.Pointer(&result)),
+	)
+
+	code := int32(r1)
+	if code != 0 {
+		return nil, lastError(code)
+	}
+
+	defer purego.SyscallN(_tokensFreeSym, uintptr(unsafe.Pointer(&result)))
+	return parseTokens(result), nil
+}
+
+func parseTokens(t ffiTokens) []Token {
+	n := int(t.Len)
+	out := make([]Token, n)
+	if n == 0 {
+		return out
+	}
+
+	idSize := unsafe.Sizeof(uint32(0))
+	offsetSize := unsafe.Sizeof(uintptr(0))
+	for i := range out {
+		id := (*uint32)(unsafe.Add(unsafe.Pointer(t.Ids), uintptr(i)*idSize))
+		start := (*uintptr)(unsafe.Add(unsafe.Pointer(t.Offsets), uintptr(2*i)*offsetSize))
+		end := (*uintptr)(unsafe.Add(unsafe.Pointer(t.Offsets), uintptr(2*i+1)*offsetSize))
+		special := (*uint8)(unsafe.Add(unsafe.Pointer(t.Special), i))
+		out[i] = Token{
+			ID:      *id,
+			Start:   int(*start),
+			End:     int(*end),
+			Special: *special != 0,
+		}
+	}
+	return out
+}
+
+// maxLengthWith returns the model's maximum sequence length in tokens from
+// the engine entry point sym, or 0 if the engine does not report it.
+func maxLengthWith(sym uintptr, handle uintptr) int {
+	if sym == 0 {
+		return 0
+	}
+	r1, _, _ := purego.SyscallN(sym, handle)
+	return int(r1)
+}
+
+// truncateTokens cuts text after the last token that fits in max tokens,
+// counting the special tokens the model adds to every input. It fails if
+// max leaves no room for the special tokens.
+func truncateTokens(text string, tokens []Token, max int) (string, error) {
+	budget := max
+	for _, t := range tokens {
+		if t.Special {
+			budget--
+		}
+	}
+	if budget < 0 {
+		return "", invalidConfig("maxTokens (%d) is less than the %d special tokens", max, max-budget)
+	}
+	if len(tokens) <= max {
+		return text, nil
+	}
+	end := 0
+	for _, t := range tokens {
+		if t.Special {
+			continue
+		}
+		if budget <= 0 {
+			break
+		}
+		end = t.End
+		budget--
+	}
+	return text[:end], nil
+}
+
+// checkMaxTokens fails with ErrUnknown when truncation is off but the
+// engine does not report the model's maximum length through sym, since
+// inputs could not be checked against it.
+func checkMaxTokens(strict bool, maxTokens int, sym string) error {
+	if strict && maxTokens == 0 {
+		return unsupported(sym)
+	}
+	return nil
+}
+
+// firstTooLong returns an *InputTooLongError for the first of n inputs
+// whose length, as returned by count, is over max. Inputs are numbered from
+// offset. It checks ctx before each input.
+func firstTooLong(ctx context.Context, offset, n, max int, count func(i int) (int, error)) error {
+	for i := 0; i < n; i++ {
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		tokens, err := count(i)
+		if err != nil {
+			return err
+		}
+		if tokens > max {
+			return tooLong(offset+i, tokens, max)
+		}
+	}
+	return nil
+}
+
+// tooLong returns the error for input i of the given length.
+func tooLong(i, tokens, max int) error {
+	return &InputTooLongError{Index: i, Tokens: tokens, Max: max}
+}
+
+// shiftTooLong renumbers an *InputTooLongError for a sub-batch that starts
+// at input offset. Other errors are returned unchanged.
+func shiftTooLong(err error, offset int) error {
+	var tl *InputTooLongError
+	if errors.As(err, &tl) {
+		return tooLong(offset+tl.Index, tl.Tokens, tl.Max)
+	}
+	return err
+}
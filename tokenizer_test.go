@@ -0,0 +1,141 @@
+package kjarni
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestTooLong(t *testing.T) {
+	tests := []struct {
+		err    error
+		offset int
+		want   int // input index after shifting, -1 if not an *InputTooLongError
+	}{
+		{tooLong(0, 600, 512), 0, 0},
+		{tooLong(3, 600, 512), 32, 35},
+		{fmt.Errorf("batch: %w", tooLong(3, 600, 512)), 32, 35},
+		{&KjarniError{Code: ErrInputTooLong, Message: "input 1 is 600 tokens, model maximum is 512"}, 32, -1},
+		{errors.New("input 1 is 600 tokens, model maximum is 512"), 32, -1},
+	}
+	for _, tt := range tests {
+		shifted := shiftTooLong(tt.err, tt.offset)
+		var tl *InputTooLongError
+		ok := errors.As(shifted, &tl)
+		switch {
+		case tt.want < 0 && (ok || shifted != tt.err):
+			t.Errorf("%v: shifted to %v", tt.err, shifted)
+		case tt.want >= 0 && (!ok || *tl != InputTooLongError{Index: tt.want, Tokens: 600, Max: 512}):
+			t.Errorf("%v shifted by %d: got %v", tt.err, tt.offset, shifted)
+		case tt.want >= 0 && errorCode(shifted) != ErrInputTooLong:
+			t.Errorf("%v: shifted error has code %d", tt.err, errorCode(shifted))
+		}
+	}
+
+	// the original error is not renumbered in place
+	err := tooLong(3, 600, 512)
+	shiftTooLong(err, 32)
+	if want := "kjarni: input 3 is 600 tokens, model maximum is 512 (code 11)"; err.Error() != want {
+		t.Errorf("got %q, want %q", err, want)
+	}
+}
+
+func TestTruncateTokens(t *testing.T) {
+	text := "hello big world"
+	tokens := []Token{
+		{ID: 101, Special: true},
+		{ID: 1, Start: 0, End: 5},
+		{ID: 2, Start: 6, End: 9},
+		{ID: 3, Start: 10, End: 15},
+		{ID: 102, Special: true},
+	}
+	tests := []struct {
+		max  int
+		want string
+		ok   bool
+	}{
+		{5, "hello big world", true},
+		{10, "hello big world", true},
+		{4, "hello big", true},
+		{3, "hello", true},
+		{2, "", true},
+		{1, "", false},
+		{0, "", false},
+	}
+	for _, tt := range tests {
+		got, err := truncateTokens(text, tokens, tt.max)
+		if got != tt.want || tt.ok != (err == nil) {
+			t.Errorf("max %d: got %q, %v, want %q", tt.max, got, err, tt.want)
+		}
+		if err != nil && errorCode(err) != ErrInvalidConfig {
+			t.Errorf("max %d: got %v, want ErrInvalidConfig", tt.max, err)
+		}
+	}
+}
+
+func TestCheckMaxTokens(t *testing.T) {
+	tests := []struct {
+		strict    bool
+		maxTokens int
+		ok        bool
+	}{
+		{false, 0, true},
+		{false, 512, true},
+		{true, 512, true},
+		// inputs cannot be checked against an unknown maximum
+		{true, 0, false},
+	}
+	for _, tt := range tests {
+		err := checkMaxTokens(tt.strict, tt.maxTokens, "kjarni_embedder_max_length")
+		if tt.ok != (err == nil) {
+			t.Errorf("strict %v, max %d: got %v", tt.strict, tt.maxTokens, err)
+		}
+		if err != nil && errorCode(err) != ErrUnknown {
+			t.Errorf("strict %v, max %d: got %v, want ErrUnknown", tt.strict, tt.maxTokens, err)
+		}
+	}
+}
+
+func TestFirstTooLong(t *testing.T) {
+	// lengths of 100 inputs, checked in sub-batches as EncodeBatchContext
+	// and ClassifyBatchContext do
+	lengths := make([]int, 100)
+	for i := range lengths {
+		lengths[i] = 10
+	}
+	lengths[70], lengths[90] = 600, 700
+	check := func(ctx context.Context) error {
+		return splitBatches(ctx, len(lengths), contextBatchSize, func(start, end int) error {
+			return firstTooLong(ctx, start, end-start, 512, func(i int) (int, error) {
+				return lengths[start+i], nil
+			})
+		})
+	}
+	var tl *InputTooLongError
+	if err := check(context.Background()); !errors.As(err, &tl) || *tl != (InputTooLongError{Index: 70, Tokens: 600, Max: 512}) {
+		t.Errorf("got %v, want input 70", err)
+	}
+	if errorCode(tl) != ErrInputTooLong {
+		t.Errorf("code %d, want ErrInputTooLong", errorCode(tl))
+	}
+
+	lengths[70], lengths[90] = 10, 10
+	if err := check(context.Background()); err != nil {
+		t.Errorf("all inputs fit: got %v", err)
+	}
+
+	errCount := errors.New("tokenizer failed")
+	err := firstTooLong(context.Background(), 0, 2, 512, func(i int) (int, error) { return 0, errCount })
+	if !errors.Is(err, errCount) {
+		t.Errorf("count error: got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err = firstTooLong(ctx, 0, 2, 512, func(i int) (int, error) { calls++; return 0, nil })
+	if errorCode(err) != ErrCancelled || calls != 0 {
+		t.Errorf("cancelled: got %v after %d calls", err, calls)
+	}
+}
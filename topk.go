@@ -0,0 +1,79 @@
+package kjarni
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// topScores keeps the k highest scores passed to push, with their indexes,
+// in a size-k min-heap so a scan over n vectors needs O(k) memory and
+// O(n log k) time. Equal scores keep the lower index, matching a stable sort.
+type topScores struct {
+	k int
+	h scoreHeap
+}
+
+type scoredIndex struct {
+	index int
+	score float32
+}
+
+func newTopScores(k int) *topScores {
+	return &topScores{k: k, h: make(scoreHeap, 0, k)}
+}
+
+func (t *topScores) push(index int, score float32) {
+	if t.k <= 0 {
+		return
+	}
+	s := scoredIndex{index: index, score: score}
+	if len(t.h) < t.k {
+		heap.Push(&t.h, s)
+		return
+	}
+	if t.h.worse(t.h[0], s) {
+		t.h[0] = s
+		heap.Fix(&t.h, 0)
+	}
+}
+
+// sorted returns the kept entries by descending score.
+func (t *topScores) sorted() []scoredIndex {
+	out := []scoredIndex(t.h)
+	sort.Slice(out, func(i, j int) bool { return t.h.worse(out[j], out[i]) })
+	return out
+}
+
+// scoreHeap is a min-heap with the worst kept entry at the root.
+type scoreHeap []scoredIndex
+
+// worse reports whether a ranks below b.
+func (scoreHeap) worse(a, b scoredIndex) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.index > b.index
+}
+
+func (h scoreHeap) Len() int           { return len(h) }
+func (h scoreHeap) Less(i, j int) bool { return h.worse(h[i], h[j]) }
+func (h scoreHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *scoreHeap) Push(x any)        { *h = append(*h, x.(scoredIndex)) }
+func (h *scoreHeap) Pop() any          { old := *h; x := old[len(old)-1]; *h = old[:len(old)-1]; return x }
+
+// topMatches returns the k highest scoring matches by descending score.
+// A negative k keeps them all.
+func topMatches(matches []VectorMatch, k int) []VectorMatch {
+	if k < 0 || k > len(matches) {
+		k = len(matches)
+	}
+	top := newTopScores(k)
+	for i, m := range matches {
+		top.push(i, m.Score)
+	}
+	out := make([]VectorMatch, 0, k)
+	for _, s := range top.sorted() {
+		out = append(out, matches[s.index])
+	}
+	return out
+}
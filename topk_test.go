@@ -0,0 +1,83 @@
+package kjarni
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// sortedMatches is the reference for topMatches: a stable sort by
+// descending score, cut to k.
+func sortedMatches(matches []VectorMatch, k int) []VectorMatch {
+	out := append([]VectorMatch(nil), matches...)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+	if k >= 0 && k < len(out) {
+		out = out[:k]
+	}
+	return out
+}
+
+func TestTopMatches(t *testing.T) {
+	m := func(scores ...float32) []VectorMatch {
+		out := make([]VectorMatch, len(scores))
+		for i, s := range scores {
+			out[i] = VectorMatch{ID: fmt.Sprint(i), Score: s}
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		matches []VectorMatch
+		k       int
+		want    []string
+	}{
+		{"empty", nil, 3, []string{}},
+		{"k zero", m(1, 2), 0, []string{}},
+		{"k negative keeps all", m(1, 3, 2), -1, []string{"1", "2", "0"}},
+		{"k larger than n", m(0.5, 0.9), 5, []string{"1", "0"}},
+		{"cut", m(0.1, 0.9, 0.5, 0.7), 2, []string{"1", "3"}},
+		{"ties keep input order", m(1, 1, 2, 1), 3, []string{"2", "0", "1"}},
+		{"negative scores", m(-3, -1, -2), 2, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		if got := matchIDs(topMatches(tt.matches, tt.k)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTopMatchesRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n += 7 {
+		matches := make([]VectorMatch, n)
+		for i := range matches {
+			// few distinct values so ties are common
+			matches[i] = VectorMatch{ID: fmt.Sprint(i), Score: float32(rng.Intn(10))}
+		}
+		for _, k := range []int{1, 5, n / 2, n, n + 1} {
+			want := sortedMatches(matches, k)
+			got := topMatches(matches, k)
+			if !reflect.DeepEqual(matchIDs(got), matchIDs(want)) {
+				t.Fatalf("n=%d k=%d: got %v, want %v", n, k, matchIDs(got), matchIDs(want))
+			}
+		}
+	}
+}
+
+func TestSearchAllocsIndependentOfSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	allocs := func(n int) float64 {
+		s, _ := NewVectorStore(MetricDot, nil)
+		for i, v := range randomVectors(rng, n, 8) {
+			s.Add(fmt.Sprint(i), v, nil)
+		}
+		q := randomVectors(rng, 1, 8)[0]
+		return testing.AllocsPerRun(10, func() { s.Search(q, 10) })
+	}
+	small, large := allocs(100), allocs(10000)
+	if large > small {
+		t.Errorf("Search allocates %v times over 10000 vectors, %v over 100", large, small)
+	}
+}
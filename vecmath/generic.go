@@ -0,0 +1,70 @@
+package vecmath
+
+// The pure Go kernels widen every element to float64 before multiplying, as
+// the SIMD kernels do, so results agree across platforms up to the order of
+// the float64 additions. Four independent accumulators hide most of the
+// latency of the dependent adds.
+
+func dotGeneric(a, b []float32) float64 {
+	var s0, s1, s2, s3 float64
+	b = b[:len(a)]
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		x := a[i : i+4 : i+4]
+		y := b[i : i+4 : i+4]
+		s0 += float64(x[0]) * float64(y[0])
+		s1 += float64(x[1]) * float64(y[1])
+		s2 += float64(x[2]) * float64(y[2])
+		s3 += float64(x[3]) * float64(y[3])
+	}
+	for ; i < len(a); i++ {
+		s0 += float64(a[i]) * float64(b[i])
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func l2sqGeneric(a, b []float32) float64 {
+	var s0, s1, s2, s3 float64
+	b = b[:len(a)]
+	i := 0
+	for ; i+4 <= len(a); i += 4 {
+		x := a[i : i+4 : i+4]
+		y := b[i : i+4 : i+4]
+		d0 := float64(x[0]) - float64(y[0])
+		d1 := float64(x[1]) - float64(y[1])
+		d2 := float64(x[2]) - float64(y[2])
+		d3 := float64(x[3]) - float64(y[3])
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+	}
+	for ; i < len(a); i++ {
+		d := float64(a[i]) - float64(b[i])
+		s0 += d * d
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func cosineGeneric(a, b []float32) (dot, na, nb float64) {
+	var d0, d1, a0, a1, b0, b1 float64
+	b = b[:len(a)]
+	i := 0
+	for ; i+2 <= len(a); i += 2 {
+		x0, x1 := float64(a[i]), float64(a[i+1])
+		y0, y1 := float64(b[i]), float64(b[i+1])
+		d0 += x0 * y0
+		d1 += x1 * y1
+		a0 += x0 * x0
+		a1 += x1 * x1
+		b0 += y0 * y0
+		b1 += y1 * y1
+	}
+	if i < len(a) {
+		x, y := float64(a[i]), float64(b[i])
+		d0 += x * y
+		a0 += x * x
+		b0 += y * y
+	}
+	return d0 + d1, a0 + a1, b0 + b1
+}
@@ -0,0 +1,147 @@
+// Package vecmath provides float32 vector math for embeddings: dot product,
+// Euclidean distance, cosine similarity and normalization, plus one-vs-many
+// and matrix-vs-matrix variants.
+//
+// Every kernel widens elements to float64 and accumulates in float64, so
+// results stay accurate for long vectors where a float32 running sum drifts,
+// and differ between kernels only in the order of the float64 additions. On
+// amd64 the kernels use AVX-512 or AVX2 with FMA when the CPU supports them,
+// falling back to pure Go otherwise. Build with the purego tag to force the
+// pure Go kernels.
+//
+// Functions panic if their inputs have different lengths.
+package vecmath
+
+import "math"
+
+// kernels, replaced at init by SIMD versions where available
+var (
+	dotKernel    = dotGeneric
+	l2sqKernel   = l2sqGeneric
+	cosineKernel = cosineGeneric
+	impl         = "generic"
+)
+
+// Implementation returns the name of the kernels in use: "avx512", "avx2"
+// or "generic".
+func Implementation() string {
+	return impl
+}
+
+// Dot returns the dot product of a and b.
+func Dot(a, b []float32) float32 {
+	checkLen(a, b)
+	return float32(dotKernel(a, b))
+}
+
+// L2 returns the Euclidean distance between a and b.
+func L2(a, b []float32) float32 {
+	checkLen(a, b)
+	return float32(math.Sqrt(l2sqKernel(a, b)))
+}
+
+// L2Squared returns the squared Euclidean distance between a and b.
+func L2Squared(a, b []float32) float32 {
+	checkLen(a, b)
+	return float32(l2sqKernel(a, b))
+}
+
+// Cosine returns the cosine similarity of a and b, or 0 if either is all zeros.
+func Cosine(a, b []float32) float32 {
+	checkLen(a, b)
+	dot, na, nb := cosineKernel(a, b)
+	return cosine(dot, na, nb)
+}
+
+// Norm returns the Euclidean length of v.
+func Norm(v []float32) float32 {
+	return float32(math.Sqrt(dotKernel(v, v)))
+}
+
+// Normalize scales v in place to unit length. All-zero vectors are left unchanged.
+func Normalize(v []float32) {
+	sum := dotKernel(v, v)
+	if sum == 0 {
+		return
+	}
+	inv := float32(1 / math.Sqrt(sum))
+	for i := range v {
+		v[i] *= inv
+	}
+}
+
+// DotBatch sets out[i] to the dot product of q and vs[i].
+// It panics if len(out) < len(vs).
+func DotBatch(q []float32, vs [][]float32, out []float32) {
+	out = out[:len(vs)]
+	for i, v := range vs {
+		checkLen(q, v)
+		out[i] = float32(dotKernel(q, v))
+	}
+}
+
+// L2Batch sets out[i] to the Euclidean distance between q and vs[i].
+// It panics if len(out) < len(vs).
+func L2Batch(q []float32, vs [][]float32, out []float32) {
+	out = out[:len(vs)]
+	for i, v := range vs {
+		checkLen(q, v)
+		out[i] = float32(math.Sqrt(l2sqKernel(q, v)))
+	}
+}
+
+// CosineBatch sets out[i] to the cosine similarity of q and vs[i].
+// It panics if len(out) < len(vs).
+func CosineBatch(q []float32, vs [][]float32, out []float32) {
+	out = out[:len(vs)]
+	for i, v := range vs {
+		checkLen(q, v)
+		out[i] = cosine(cosineKernel(q, v))
+	}
+}
+
+// DotMatrix sets out[i][j] to the dot product of a[i] and b[j].
+// out must have at least len(a) rows of at least len(b) columns.
+func DotMatrix(a, b [][]float32, out [][]float32) {
+	for i, x := range a {
+		DotBatch(x, b, out[i])
+	}
+}
+
+// L2Matrix sets out[i][j] to the Euclidean distance between a[i] and b[j].
+// out must have at least len(a) rows of at least len(b) columns.
+func L2Matrix(a, b [][]float32, out [][]float32) {
+	for i, x := range a {
+		L2Batch(x, b, out[i])
+	}
+}
+
+// CosineMatrix sets out[i][j] to the cosine similarity of a[i] and b[j].
+// out must have at least len(a) rows of at least len(b) columns.
+func CosineMatrix(a, b [][]float32, out [][]float32) {
+	nb := make([]float64, len(b))
+	for j, y := range b {
+		nb[j] = dotKernel(y, y)
+	}
+	for i, x := range a {
+		na := dotKernel(x, x)
+		row := out[i][:len(b)]
+		for j, y := range b {
+			checkLen(x, y)
+			row[j] = cosine(dotKernel(x, y), na, nb[j])
+		}
+	}
+}
+
+func cosine(dot, na, nb float64) float32 {
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return float32(dot / math.Sqrt(na*nb))
+}
+
+func checkLen(a, b []float32) {
+	if len(a) != len(b) {
+		panic("vecmath: vectors have different lengths")
+	}
+}
@@ -0,0 +1,96 @@
+//go:build amd64 && !purego
+
+package vecmath
+
+import "unsafe"
+
+//go:noescape
+func dotAVX2(a, b unsafe.Pointer, n int) float64
+
+//go:noescape
+func l2sqAVX2(a, b unsafe.Pointer, n int) float64
+
+//go:noescape
+func cosineAVX2(a, b unsafe.Pointer, n int) (dot, na, nb float64)
+
+//go:noescape
+func dotAVX512(a, b unsafe.Pointer, n int) float64
+
+//go:noescape
+func l2sqAVX512(a, b unsafe.Pointer, n int) float64
+
+//go:noescape
+func cosineAVX512(a, b unsafe.Pointer, n int) (dot, na, nb float64)
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+func init() {
+	switch {
+	case hasAVX512():
+		dotKernel = wrap(dotAVX512)
+		l2sqKernel = wrap(l2sqAVX512)
+		cosineKernel = wrap3(cosineAVX512)
+		impl = "avx512"
+	case hasAVX2():
+		dotKernel = wrap(dotAVX2)
+		l2sqKernel = wrap(l2sqAVX2)
+		cosineKernel = wrap3(cosineAVX2)
+		impl = "avx2"
+	}
+}
+
+func wrap(k func(a, b unsafe.Pointer, n int) float64) func(a, b []float32) float64 {
+	return func(a, b []float32) float64 {
+		if len(a) == 0 {
+			return 0
+		}
+		_ = b[len(a)-1]
+		return k(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), len(a))
+	}
+}
+
+func wrap3(k func(a, b unsafe.Pointer, n int) (float64, float64, float64)) func(a, b []float32) (float64, float64, float64) {
+	return func(a, b []float32) (float64, float64, float64) {
+		if len(a) == 0 {
+			return 0, 0, 0
+		}
+		_ = b[len(a)-1]
+		return k(unsafe.Pointer(&a[0]), unsafe.Pointer(&b[0]), len(a))
+	}
+}
+
+// osxsave and the OS saving YMM state are required for any AVX use
+func hasAVX() bool {
+	_, _, ecx, _ := cpuid(1, 0)
+	const osxsave, avx = 1 << 27, 1 << 28
+	if ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+	xcr0, _ := xgetbv()
+	return xcr0&0x6 == 0x6
+}
+
+func hasAVX2() bool {
+	if !hasAVX() {
+		return false
+	}
+	_, _, ecx, _ := cpuid(1, 0)
+	_, ebx, _, _ := cpuid(7, 0)
+	const fma, avx2 = 1 << 12, 1 << 5
+	return ecx&fma != 0 && ebx&avx2 != 0
+}
+
+func hasAVX512() bool {
+	if !hasAVX2() {
+		return false
+	}
+	xcr0, _ := xgetbv()
+	if xcr0&0xe0 != 0xe0 { // opmask, ZMM0-15 and ZMM16-31 state
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	const avx512f = 1 << 16
+	return ebx&avx512f != 0
+}
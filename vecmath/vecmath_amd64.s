@@ -0,0 +1,426 @@
+//go:build amd64 && !purego
+
+#include "textflag.h"
+
+// All kernels widen float32 inputs to float64 with VCVTPS2PD and accumulate
+// with FMA. The main loops process 16 (AVX2) or 32 (AVX-512) elements per
+// iteration across independent accumulators; a scalar loop handles the tail.
+
+// func dotAVX2(a, b unsafe.Pointer, n int) float64
+TEXT ·dotAVX2(SB), NOSPLIT, $0-32
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Y0, Y0, Y0
+	VXORPD Y1, Y1, Y1
+	VXORPD Y2, Y2, Y2
+	VXORPD Y3, Y3, Y3
+
+dot2_loop16:
+	CMPQ CX, $16
+	JL   dot2_reduce
+	VCVTPS2PD (SI), Y4
+	VCVTPS2PD 16(SI), Y5
+	VCVTPS2PD 32(SI), Y6
+	VCVTPS2PD 48(SI), Y7
+	VCVTPS2PD (DI), Y8
+	VCVTPS2PD 16(DI), Y9
+	VCVTPS2PD 32(DI), Y10
+	VCVTPS2PD 48(DI), Y11
+	VFMADD231PD Y4, Y8, Y0
+	VFMADD231PD Y5, Y9, Y1
+	VFMADD231PD Y6, Y10, Y2
+	VFMADD231PD Y7, Y11, Y3
+	ADDQ $64, SI
+	ADDQ $64, DI
+	SUBQ $16, CX
+	JMP  dot2_loop16
+
+dot2_reduce:
+	VADDPD Y1, Y0, Y0
+	VADDPD Y3, Y2, Y2
+	VADDPD Y2, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+
+dot2_tail:
+	TESTQ CX, CX
+	JE    dot2_done
+	VCVTSS2SD (SI), X4, X4
+	VCVTSS2SD (DI), X5, X5
+	VFMADD231SD X4, X5, X0
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  dot2_tail
+
+dot2_done:
+	VZEROUPPER
+	MOVSD X0, ret+24(FP)
+	RET
+
+// func l2sqAVX2(a, b unsafe.Pointer, n int) float64
+TEXT ·l2sqAVX2(SB), NOSPLIT, $0-32
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Y0, Y0, Y0
+	VXORPD Y1, Y1, Y1
+	VXORPD Y2, Y2, Y2
+	VXORPD Y3, Y3, Y3
+
+l2_2_loop16:
+	CMPQ CX, $16
+	JL   l2_2_reduce
+	VCVTPS2PD (SI), Y4
+	VCVTPS2PD 16(SI), Y5
+	VCVTPS2PD 32(SI), Y6
+	VCVTPS2PD 48(SI), Y7
+	VCVTPS2PD (DI), Y8
+	VCVTPS2PD 16(DI), Y9
+	VCVTPS2PD 32(DI), Y10
+	VCVTPS2PD 48(DI), Y11
+	VSUBPD Y8, Y4, Y4
+	VSUBPD Y9, Y5, Y5
+	VSUBPD Y10, Y6, Y6
+	VSUBPD Y11, Y7, Y7
+	VFMADD231PD Y4, Y4, Y0
+	VFMADD231PD Y5, Y5, Y1
+	VFMADD231PD Y6, Y6, Y2
+	VFMADD231PD Y7, Y7, Y3
+	ADDQ $64, SI
+	ADDQ $64, DI
+	SUBQ $16, CX
+	JMP  l2_2_loop16
+
+l2_2_reduce:
+	VADDPD Y1, Y0, Y0
+	VADDPD Y3, Y2, Y2
+	VADDPD Y2, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+
+l2_2_tail:
+	TESTQ CX, CX
+	JE    l2_2_done
+	VCVTSS2SD (SI), X4, X4
+	VCVTSS2SD (DI), X5, X5
+	VSUBSD X5, X4, X4
+	VFMADD231SD X4, X4, X0
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  l2_2_tail
+
+l2_2_done:
+	VZEROUPPER
+	MOVSD X0, ret+24(FP)
+	RET
+
+// func cosineAVX2(a, b unsafe.Pointer, n int) (dot, na, nb float64)
+TEXT ·cosineAVX2(SB), NOSPLIT, $0-48
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Y0, Y0, Y0 // dot
+	VXORPD Y1, Y1, Y1
+	VXORPD Y2, Y2, Y2 // na
+	VXORPD Y3, Y3, Y3
+	VXORPD Y4, Y4, Y4 // nb
+	VXORPD Y5, Y5, Y5
+
+cos2_loop8:
+	CMPQ CX, $8
+	JL   cos2_reduce
+	VCVTPS2PD (SI), Y6
+	VCVTPS2PD 16(SI), Y7
+	VCVTPS2PD (DI), Y8
+	VCVTPS2PD 16(DI), Y9
+	VFMADD231PD Y6, Y8, Y0
+	VFMADD231PD Y7, Y9, Y1
+	VFMADD231PD Y6, Y6, Y2
+	VFMADD231PD Y7, Y7, Y3
+	VFMADD231PD Y8, Y8, Y4
+	VFMADD231PD Y9, Y9, Y5
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $8, CX
+	JMP  cos2_loop8
+
+cos2_reduce:
+	VADDPD Y1, Y0, Y0
+	VADDPD Y3, Y2, Y2
+	VADDPD Y5, Y4, Y4
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+	VEXTRACTF128 $1, Y2, X3
+	VADDPD X3, X2, X2
+	VHADDPD X2, X2, X2
+	VEXTRACTF128 $1, Y4, X5
+	VADDPD X5, X4, X4
+	VHADDPD X4, X4, X4
+
+cos2_tail:
+	TESTQ CX, CX
+	JE    cos2_done
+	VCVTSS2SD (SI), X6, X6
+	VCVTSS2SD (DI), X7, X7
+	VFMADD231SD X6, X7, X0
+	VFMADD231SD X6, X6, X2
+	VFMADD231SD X7, X7, X4
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  cos2_tail
+
+cos2_done:
+	VZEROUPPER
+	MOVSD X0, dot+24(FP)
+	MOVSD X2, na+32(FP)
+	MOVSD X4, nb+40(FP)
+	RET
+
+// func dotAVX512(a, b unsafe.Pointer, n int) float64
+TEXT ·dotAVX512(SB), NOSPLIT, $0-32
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Z0, Z0, Z0
+	VXORPD Z1, Z1, Z1
+	VXORPD Z2, Z2, Z2
+	VXORPD Z3, Z3, Z3
+
+dot5_loop32:
+	CMPQ CX, $32
+	JL   dot5_loop8
+	VCVTPS2PD (SI), Z4
+	VCVTPS2PD 32(SI), Z5
+	VCVTPS2PD 64(SI), Z6
+	VCVTPS2PD 96(SI), Z7
+	VCVTPS2PD (DI), Z8
+	VCVTPS2PD 32(DI), Z9
+	VCVTPS2PD 64(DI), Z10
+	VCVTPS2PD 96(DI), Z11
+	VFMADD231PD Z4, Z8, Z0
+	VFMADD231PD Z5, Z9, Z1
+	VFMADD231PD Z6, Z10, Z2
+	VFMADD231PD Z7, Z11, Z3
+	ADDQ $128, SI
+	ADDQ $128, DI
+	SUBQ $32, CX
+	JMP  dot5_loop32
+
+dot5_loop8:
+	CMPQ CX, $8
+	JL   dot5_reduce
+	VCVTPS2PD (SI), Z4
+	VCVTPS2PD (DI), Z8
+	VFMADD231PD Z4, Z8, Z0
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $8, CX
+	JMP  dot5_loop8
+
+dot5_reduce:
+	VADDPD Z1, Z0, Z0
+	VADDPD Z3, Z2, Z2
+	VADDPD Z2, Z0, Z0
+	VEXTRACTF64X4 $1, Z0, Y1
+	VADDPD Y1, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+
+dot5_tail:
+	TESTQ CX, CX
+	JE    dot5_done
+	VCVTSS2SD (SI), X4, X4
+	VCVTSS2SD (DI), X5, X5
+	VFMADD231SD X4, X5, X0
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  dot5_tail
+
+dot5_done:
+	VZEROUPPER
+	MOVSD X0, ret+24(FP)
+	RET
+
+// func l2sqAVX512(a, b unsafe.Pointer, n int) float64
+TEXT ·l2sqAVX512(SB), NOSPLIT, $0-32
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Z0, Z0, Z0
+	VXORPD Z1, Z1, Z1
+	VXORPD Z2, Z2, Z2
+	VXORPD Z3, Z3, Z3
+
+l2_5_loop32:
+	CMPQ CX, $32
+	JL   l2_5_loop8
+	VCVTPS2PD (SI), Z4
+	VCVTPS2PD 32(SI), Z5
+	VCVTPS2PD 64(SI), Z6
+	VCVTPS2PD 96(SI), Z7
+	VCVTPS2PD (DI), Z8
+	VCVTPS2PD 32(DI), Z9
+	VCVTPS2PD 64(DI), Z10
+	VCVTPS2PD 96(DI), Z11
+	VSUBPD Z8, Z4, Z4
+	VSUBPD Z9, Z5, Z5
+	VSUBPD Z10, Z6, Z6
+	VSUBPD Z11, Z7, Z7
+	VFMADD231PD Z4, Z4, Z0
+	VFMADD231PD Z5, Z5, Z1
+	VFMADD231PD Z6, Z6, Z2
+	VFMADD231PD Z7, Z7, Z3
+	ADDQ $128, SI
+	ADDQ $128, DI
+	SUBQ $32, CX
+	JMP  l2_5_loop32
+
+l2_5_loop8:
+	CMPQ CX, $8
+	JL   l2_5_reduce
+	VCVTPS2PD (SI), Z4
+	VCVTPS2PD (DI), Z8
+	VSUBPD Z8, Z4, Z4
+	VFMADD231PD Z4, Z4, Z0
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $8, CX
+	JMP  l2_5_loop8
+
+l2_5_reduce:
+	VADDPD Z1, Z0, Z0
+	VADDPD Z3, Z2, Z2
+	VADDPD Z2, Z0, Z0
+	VEXTRACTF64X4 $1, Z0, Y1
+	VADDPD Y1, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+
+l2_5_tail:
+	TESTQ CX, CX
+	JE    l2_5_done
+	VCVTSS2SD (SI), X4, X4
+	VCVTSS2SD (DI), X5, X5
+	VSUBSD X5, X4, X4
+	VFMADD231SD X4, X4, X0
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  l2_5_tail
+
+l2_5_done:
+	VZEROUPPER
+	MOVSD X0, ret+24(FP)
+	RET
+
+// func cosineAVX512(a, b unsafe.Pointer, n int) (dot, na, nb float64)
+TEXT ·cosineAVX512(SB), NOSPLIT, $0-48
+	MOVQ a+0(FP), SI
+	MOVQ b+8(FP), DI
+	MOVQ n+16(FP), CX
+	VXORPD Z0, Z0, Z0 // dot
+	VXORPD Z1, Z1, Z1
+	VXORPD Z2, Z2, Z2 // na
+	VXORPD Z3, Z3, Z3
+	VXORPD Z4, Z4, Z4 // nb
+	VXORPD Z5, Z5, Z5
+
+cos5_loop16:
+	CMPQ CX, $16
+	JL   cos5_loop8
+	VCVTPS2PD (SI), Z6
+	VCVTPS2PD 32(SI), Z7
+	VCVTPS2PD (DI), Z8
+	VCVTPS2PD 32(DI), Z9
+	VFMADD231PD Z6, Z8, Z0
+	VFMADD231PD Z7, Z9, Z1
+	VFMADD231PD Z6, Z6, Z2
+	VFMADD231PD Z7, Z7, Z3
+	VFMADD231PD Z8, Z8, Z4
+	VFMADD231PD Z9, Z9, Z5
+	ADDQ $64, SI
+	ADDQ $64, DI
+	SUBQ $16, CX
+	JMP  cos5_loop16
+
+cos5_loop8:
+	CMPQ CX, $8
+	JL   cos5_reduce
+	VCVTPS2PD (SI), Z6
+	VCVTPS2PD (DI), Z8
+	VFMADD231PD Z6, Z8, Z0
+	VFMADD231PD Z6, Z6, Z2
+	VFMADD231PD Z8, Z8, Z4
+	ADDQ $32, SI
+	ADDQ $32, DI
+	SUBQ $8, CX
+	JMP  cos5_loop8
+
+cos5_reduce:
+	VADDPD Z1, Z0, Z0
+	VADDPD Z3, Z2, Z2
+	VADDPD Z5, Z4, Z4
+	VEXTRACTF64X4 $1, Z0, Y1
+	VADDPD Y1, Y0, Y0
+	VEXTRACTF128 $1, Y0, X1
+	VADDPD X1, X0, X0
+	VHADDPD X0, X0, X0
+	VEXTRACTF64X4 $1, Z2, Y3
+	VADDPD Y3, Y2, Y2
+	VEXTRACTF128 $1, Y2, X3
+	VADDPD X3, X2, X2
+	VHADDPD X2, X2, X2
+	VEXTRACTF64X4 $1, Z4, Y5
+	VADDPD Y5, Y4, Y4
+	VEXTRACTF128 $1, Y4, X5
+	VADDPD X5, X4, X4
+	VHADDPD X4, X4, X4
+
+cos5_tail:
+	TESTQ CX, CX
+	JE    cos5_done
+	VCVTSS2SD (SI), X6, X6
+	VCVTSS2SD (DI), X7, X7
+	VFMADD231SD X6, X7, X0
+	VFMADD231SD X6, X6, X2
+	VFMADD231SD X7, X7, X4
+	ADDQ $4, SI
+	ADDQ $4, DI
+	DECQ CX
+	JMP  cos5_tail
+
+cos5_done:
+	VZEROUPPER
+	MOVSD X0, dot+24(FP)
+	MOVSD X2, na+32(FP)
+	MOVSD X4, nb+40(FP)
+	RET
+
+// func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+TEXT ·cpuid(SB), NOSPLIT, $0-24
+	MOVL eaxArg+0(FP), AX
+	MOVL ecxArg+4(FP), CX
+	CPUID
+	MOVL AX, eax+8(FP)
+	MOVL BX, ebx+12(FP)
+	MOVL CX, ecx+16(FP)
+	MOVL DX, edx+20(FP)
+	RET
+
+// func xgetbv() (eax, edx uint32)
+TEXT ·xgetbv(SB), NOSPLIT, $0-8
+	MOVL $0, CX
+	XGETBV
+	MOVL AX, eax+0(FP)
+	MOVL DX, edx+4(FP)
+	RET
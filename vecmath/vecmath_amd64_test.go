@@ -0,0 +1,12 @@
+//go:build amd64 && !purego
+
+package vecmath
+
+func init() {
+	if hasAVX2() {
+		archKernels = append(archKernels, kernelSet{"avx2", wrap(dotAVX2), wrap(l2sqAVX2), wrap3(cosineAVX2)})
+	}
+	if hasAVX512() {
+		archKernels = append(archKernels, kernelSet{"avx512", wrap(dotAVX512), wrap(l2sqAVX512), wrap3(cosineAVX512)})
+	}
+}
@@ -0,0 +1,297 @@
+package vecmath
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type kernelSet struct {
+	name   string
+	dot    func(a, b []float32) float64
+	l2sq   func(a, b []float32) float64
+	cosine func(a, b []float32) (float64, float64, float64)
+}
+
+// archKernels lists the SIMD kernels this CPU can run, filled in by the
+// architecture's test file.
+var archKernels []kernelSet
+
+func allKernels() []kernelSet {
+	return append([]kernelSet{{"generic", dotGeneric, l2sqGeneric, cosineGeneric}}, archKernels...)
+}
+
+func randVec(rng *rand.Rand, n int) []float32 {
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = float32(rng.NormFloat64())
+	}
+	return v
+}
+
+func refDot(a, b []float32) float64 {
+	var s float64
+	for i := range a {
+		s += float64(a[i]) * float64(b[i])
+	}
+	return s
+}
+
+func refL2sq(a, b []float32) float64 {
+	var s float64
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		s += d * d
+	}
+	return s
+}
+
+// near reports whether got matches want to within float64 summation-order
+// error, scaled by the magnitude of the summed terms.
+func near(got, want, scale float64) bool {
+	return math.Abs(got-want) <= 1e-12*(scale+1)
+}
+
+func TestKernels(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// every length up to several main-loop widths, to cover each tail, plus
+	// a few embedding sizes
+	lengths := []int{1000, 1023, 1024, 1025, 4099}
+	for n := 0; n <= 100; n++ {
+		lengths = append(lengths, n)
+	}
+
+	for _, k := range allKernels() {
+		for _, n := range lengths {
+			// offsets shift the start off 16- and 64-byte alignment
+			for off := 0; off < 4; off++ {
+				a := randVec(rng, n+off)[off:]
+				b := randVec(rng, n+off)[off:]
+
+				scale := refDot(absVec(a), absVec(b))
+				if got, want := k.dot(a, b), refDot(a, b); !near(got, want, scale) {
+					t.Fatalf("%s dot n=%d off=%d: got %v, want %v", k.name, n, off, got, want)
+				}
+				if got, want := k.l2sq(a, b), refL2sq(a, b); !near(got, want, want) {
+					t.Fatalf("%s l2sq n=%d off=%d: got %v, want %v", k.name, n, off, got, want)
+				}
+				dot, na, nb := k.cosine(a, b)
+				wantNA, wantNB := refDot(a, a), refDot(b, b)
+				if !near(dot, refDot(a, b), scale) || !near(na, wantNA, wantNA) || !near(nb, wantNB, wantNB) {
+					t.Fatalf("%s cosine n=%d off=%d: got (%v, %v, %v), want (%v, %v, %v)",
+						k.name, n, off, dot, na, nb, refDot(a, b), wantNA, wantNB)
+				}
+			}
+		}
+	}
+}
+
+// TestKernelsReadInBounds places the inputs at the end of larger arrays
+// with sentinel values after them, which would show up in the result if a
+// kernel read past the last element.
+func TestKernelsReadInBounds(t *testing.T) {
+	for _, k := range allKernels() {
+		for n := 1; n <= 70; n++ {
+			buf := make([]float32, n+64)
+			for i := range buf {
+				buf[i] = 1
+			}
+			for i := n; i < len(buf); i++ {
+				buf[i] = 1e30
+			}
+			a := buf[:n:n]
+			if got := k.dot(a, a); got != float64(n) {
+				t.Fatalf("%s dot n=%d: got %v, want %d", k.name, n, got, n)
+			}
+			if got := k.l2sq(a, a); got != 0 {
+				t.Fatalf("%s l2sq n=%d: got %v, want 0", k.name, n, got)
+			}
+			if dot, na, nb := k.cosine(a, a); dot != float64(n) || na != float64(n) || nb != float64(n) {
+				t.Fatalf("%s cosine n=%d: got (%v, %v, %v)", k.name, n, dot, na, nb)
+			}
+		}
+	}
+}
+
+// TestPrecision checks that long sums do not drift the way a float32
+// running sum does.
+func TestPrecision(t *testing.T) {
+	v := make([]float32, 1<<20)
+	for i := range v {
+		v[i] = 1e-3
+	}
+	x := float64(float32(1e-3))
+	want := float64(len(v)) * x * x
+	for _, k := range allKernels() {
+		if got := k.dot(v, v); math.Abs(got-want) > want*1e-9 {
+			t.Errorf("%s: dot of %d values = %v, want %v", k.name, len(v), got, want)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	tests := []struct {
+		name string
+		got  float32
+		want float32
+	}{
+		{"Dot", Dot([]float32{1, 2, 3}, []float32{4, 5, 6}), 32},
+		{"L2", L2([]float32{0, 0}, []float32{3, 4}), 5},
+		{"L2Squared", L2Squared([]float32{0, 0}, []float32{3, 4}), 25},
+		{"Cosine", Cosine([]float32{1, 0}, []float32{1, 1}), float32(1 / math.Sqrt2)},
+		{"Cosine zero", Cosine([]float32{0, 0}, []float32{1, 1}), 0},
+		{"Cosine empty", Cosine(nil, nil), 0},
+		{"Norm", Norm([]float32{3, 4}), 5},
+	}
+	for _, tt := range tests {
+		if math.Abs(float64(tt.got-tt.want)) > 1e-6 {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+
+	v := []float32{3, 4}
+	Normalize(v)
+	if v[0] != 0.6 || v[1] != 0.8 {
+		t.Errorf("Normalize = %v, want [0.6 0.8]", v)
+	}
+	zero := []float32{0, 0}
+	Normalize(zero)
+	if zero[0] != 0 || zero[1] != 0 {
+		t.Errorf("Normalize(zero) = %v", zero)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Dot with different lengths did not panic")
+		}
+	}()
+	Dot([]float32{1}, []float32{1, 2})
+}
+
+func TestBatchAndMatrix(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	q := randVec(rng, 37)
+	vs := [][]float32{randVec(rng, 37), randVec(rng, 37), randVec(rng, 37)}
+
+	batches := []struct {
+		name   string
+		batch  func(q []float32, vs [][]float32, out []float32)
+		matrix func(a, b [][]float32, out [][]float32)
+		single func(a, b []float32) float32
+	}{
+		{"Dot", DotBatch, DotMatrix, Dot},
+		{"L2", L2Batch, L2Matrix, L2},
+		{"Cosine", CosineBatch, CosineMatrix, Cosine},
+	}
+	for _, tt := range batches {
+		out := make([]float32, len(vs))
+		tt.batch(q, vs, out)
+		for i, v := range vs {
+			if want := tt.single(q, v); out[i] != want {
+				t.Errorf("%sBatch[%d] = %v, want %v", tt.name, i, out[i], want)
+			}
+		}
+
+		m := make([][]float32, len(vs))
+		for i := range m {
+			m[i] = make([]float32, len(vs))
+		}
+		tt.matrix(vs, vs, m)
+		for i := range vs {
+			for j := range vs {
+				if want := tt.single(vs[i], vs[j]); math.Abs(float64(m[i][j]-want)) > 1e-6 {
+					t.Errorf("%sMatrix[%d][%d] = %v, want %v", tt.name, i, j, m[i][j], want)
+				}
+			}
+		}
+	}
+}
+
+func absVec(v []float32) []float32 {
+	out := make([]float32, len(v))
+	for i, x := range v {
+		out[i] = float32(math.Abs(float64(x)))
+	}
+	return out
+}
+
+// naiveCosine is a float32 loop, for comparison with the kernels.
+func naiveCosine(a, b []float32) float32 {
+	var dot, na, nb float32
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / float32(math.Sqrt(float64(na)*float64(nb)))
+}
+
+var sink float64
+
+func BenchmarkDot(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, dim := range []int{384, 768, 1024} {
+		x, y := randVec(rng, dim), randVec(rng, dim)
+		for _, k := range allKernels() {
+			b.Run(fmt.Sprintf("%s/%d", k.name, dim), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					sink = k.dot(x, y)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkL2(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, dim := range []int{384, 768, 1024} {
+		x, y := randVec(rng, dim), randVec(rng, dim)
+		for _, k := range allKernels() {
+			b.Run(fmt.Sprintf("%s/%d", k.name, dim), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					sink = k.l2sq(x, y)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCosine(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	for _, dim := range []int{384, 768, 1024} {
+		x, y := randVec(rng, dim), randVec(rng, dim)
+		b.Run(fmt.Sprintf("naive/%d", dim), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				sink = float64(naiveCosine(x, y))
+			}
+		})
+		for _, k := range allKernels() {
+			b.Run(fmt.Sprintf("%s/%d", k.name, dim), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					d, na, nb := k.cosine(x, y)
+					sink = float64(cosine(d, na, nb))
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkCosineBatch scores one query against 10k stored vectors.
+func BenchmarkCosineBatch(b *testing.B) {
+	const n, dim = 10000, 768
+	rng := rand.New(rand.NewSource(1))
+	q := randVec(rng, dim)
+	vs := make([][]float32, n)
+	for i := range vs {
+		vs[i] = randVec(rng, dim)
+	}
+	out := make([]float32, n)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CosineBatch(q, vs, out)
+	}
+}
@@ -0,0 +1,315 @@
+package kjarni
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/olafurjohannsson/kjarni-go/vecmath"
+)
+
+// Metric selects how a VectorStore scores vectors against a query.
+type Metric int
+
+const (
+	// MetricCosine scores by cosine similarity.
+	MetricCosine Metric = 0
+	// MetricDot scores by dot product. Equal to cosine for normalized vectors.
+	MetricDot Metric = 1
+	// MetricL2 scores by negated Euclidean distance, so higher is still better.
+	MetricL2 Metric = 2
+)
+
+// VectorMatch is a single VectorStore search result.
+type VectorMatch struct {
+	ID       string
+	Score    float32
+	Metadata map[string]any
+}
+
+// VectorStore is an in-memory collection of vectors searched exactly by
+// comparing the query against every stored vector. It suits small,
+// frequently changing collections; use an Indexer and Searcher for large
+// on-disk corpora. A VectorStore is safe for concurrent use.
+type VectorStore struct {
+	mu       sync.RWMutex
+	metric   Metric
+	embedder *Embedder
+	model    string
+	normed   bool
+	dim      int
+	ids      []string
+	vectors  [][]float32
+	metadata []map[string]any
+	pos      map[string]int
+}
+
+// NewVectorStore creates an empty store using the given metric. If embedder
+// is non-nil, the text methods (AddText, SearchText, ...) use it to encode
+// texts, and the store's dimension is fixed to embedder.Dim(). Otherwise the
+// dimension is taken from the first vector added.
+func NewVectorStore(metric Metric, embedder *Embedder) (*VectorStore, error) {
+	if !metric.valid() {
+		return nil, invalidConfig("unknown metric %d", int(metric))
+	}
+	s := &VectorStore{
+		metric:   metric,
+		embedder: embedder,
+		pos:      make(map[string]int),
+	}
+	if embedder != nil {
+		s.model = embedder.Model()
+		s.normed = embedder.Normalized()
+		s.dim = embedder.Dim()
+	}
+	return s, nil
+}
+
+// Model returns the name of the embedding model the stored vectors came
+// from, or "" if the store was created without an embedder.
+func (s *VectorStore) Model() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.model
+}
+
+// Len returns the number of vectors in the store.
+func (s *VectorStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.ids)
+}
+
+// Dim returns the dimension of stored vectors, or 0 if it is not yet known.
+func (s *VectorStore) Dim() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.dim
+}
+
+// Add stores a vector under id. It fails if id is already present or the
+// vector has the wrong dimension. The vector is copied.
+func (s *VectorStore) Add(id string, vec []float32, metadata map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pos[id]; ok {
+		return fmt.Errorf("id %q already in vector store", id)
+	}
+	if err := s.check(id, vec, s.dim); err != nil {
+		return err
+	}
+	s.put(id, vec, metadata)
+	return nil
+}
+
+// Upsert stores a vector under id, replacing any existing entry.
+func (s *VectorStore) Upsert(id string, vec []float32, metadata map[string]any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.check(id, vec, s.dim); err != nil {
+		return err
+	}
+	s.put(id, vec, metadata)
+	return nil
+}
+
+// Delete removes id from the store and reports whether it was present.
+func (s *VectorStore) Delete(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.pos[id]
+	if !ok {
+		return false
+	}
+	last := len(s.ids) - 1
+	if i != last {
+		s.ids[i] = s.ids[last]
+		s.vectors[i] = s.vectors[last]
+		s.metadata[i] = s.metadata[last]
+		s.pos[s.ids[i]] = i
+	}
+	s.ids = s.ids[:last]
+	s.vectors = s.vectors[:last]
+	s.metadata = s.metadata[:last]
+	delete(s.pos, id)
+	return true
+}
+
+// Get returns the vector and metadata stored under id. Both are copies; the
+// metadata values themselves are shared with the store.
+func (s *VectorStore) Get(id string) ([]float32, map[string]any, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.pos[id]
+	if !ok {
+		return nil, nil, false
+	}
+	vec := make([]float32, len(s.vectors[i]))
+	copy(vec, s.vectors[i])
+	return vec, maps.Clone(s.metadata[i]), true
+}
+
+// Search returns the k stored vectors scoring highest against query,
+// sorted by descending score.
+func (s *VectorStore) Search(query []float32, k int) ([]VectorMatch, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if k <= 0 || len(s.ids) == 0 {
+		return []VectorMatch{}, nil
+	}
+	if len(query) != s.dim {
+		return nil, fmt.Errorf("query has %d dimensions, vector store expects %d", len(query), s.dim)
+	}
+
+	top := newTopScores(min(k, len(s.ids)))
+	for i, vec := range s.vectors {
+		top.push(i, s.score(query, vec))
+	}
+
+	best := top.sorted()
+	matches := make([]VectorMatch, len(best))
+	for j, b := range best {
+		matches[j] = VectorMatch{
+			ID:       s.ids[b.index],
+			Score:    b.score,
+			Metadata: s.metadata[b.index],
+		}
+	}
+	return matches, nil
+}
+
+// AddText encodes text with the store's embedder and adds it under id.
+func (s *VectorStore) AddText(id, text string, metadata map[string]any) error {
+	vec, err := s.encode(text)
+	if err != nil {
+		return err
+	}
+	return s.Add(id, vec, metadata)
+}
+
+// UpsertText encodes text with the store's embedder and upserts it under id.
+func (s *VectorStore) UpsertText(id, text string, metadata map[string]any) error {
+	vec, err := s.encode(text)
+	if err != nil {
+		return err
+	}
+	return s.Upsert(id, vec, metadata)
+}
+
+// UpsertDocuments encodes the documents in one batch with the store's
+// embedder and upserts each under its ID. Either every document is stored
+// or, on error, none is.
+func (s *VectorStore) UpsertDocuments(docs []Document) error {
+	if s.embedder == nil {
+		return errors.New("vector store has no embedder")
+	}
+	seen := make(map[string]bool, len(docs))
+	texts := make([]string, len(docs))
+	for i, d := range docs {
+		if d.ID == "" {
+			return invalidConfig("document %d has an empty ID", i)
+		}
+		if seen[d.ID] {
+			return invalidConfig("duplicate document ID %q", d.ID)
+		}
+		seen[d.ID] = true
+		texts[i] = d.Text
+	}
+	vecs, err := s.embedder.EncodeBatch(texts)
+	if err != nil {
+		return err
+	}
+	if len(vecs) != len(docs) {
+		return fmt.Errorf("embedder returned %d vectors for %d documents", len(vecs), len(docs))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dim := s.dim
+	if dim == 0 && len(vecs) > 0 {
+		dim = len(vecs[0])
+	}
+	for i, d := range docs {
+		if err := s.check(d.ID, vecs[i], dim); err != nil {
+			return err
+		}
+	}
+	for i, d := range docs {
+		s.put(d.ID, vecs[i], d.Metadata)
+	}
+	return nil
+}
+
+// SearchText encodes query with the store's embedder and searches for it.
+func (s *VectorStore) SearchText(query string, k int) ([]VectorMatch, error) {
+	vec, err := s.encode(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.Search(vec, k)
+}
+
+func (s *VectorStore) encode(text string) ([]float32, error) {
+	if s.embedder == nil {
+		return nil, errors.New("vector store has no embedder")
+	}
+	return s.embedder.Encode(text)
+}
+
+// check reports whether id and vec may be stored, given the store's
+// dimension dim, or 0 if it is not yet known.
+func (s *VectorStore) check(id string, vec []float32, dim int) error {
+	if id == "" {
+		return errors.New("id must not be empty")
+	}
+	if len(id) > maxStoreIDLen {
+		return fmt.Errorf("id is %d bytes, the limit is %d", len(id), maxStoreIDLen)
+	}
+	if len(vec) == 0 {
+		return errors.New("vector must not be empty")
+	}
+	if dim != 0 && len(vec) != dim {
+		return fmt.Errorf("vector has %d dimensions, vector store expects %d", len(vec), dim)
+	}
+	return nil
+}
+
+// put inserts or replaces id after check has accepted it. The caller must
+// hold s.mu for writing.
+func (s *VectorStore) put(id string, vec []float32, metadata map[string]any) {
+	if s.dim == 0 {
+		s.dim = len(vec)
+	}
+	stored := make([]float32, len(vec))
+	copy(stored, vec)
+
+	if i, ok := s.pos[id]; ok {
+		s.vectors[i] = stored
+		s.metadata[i] = metadata
+		return
+	}
+	s.pos[id] = len(s.ids)
+	s.ids = append(s.ids, id)
+	s.vectors = append(s.vectors, stored)
+	s.metadata = append(s.metadata, metadata)
+}
+
+func (m Metric) valid() bool {
+	return m >= MetricCosine && m <= MetricL2
+}
+
+func (s *VectorStore) score(a, b []float32) float32 {
+	switch s.metric {
+	case MetricDot:
+		return vecmath.Dot(a, b)
+	case MetricL2:
+		return -vecmath.L2(a, b)
+	default:
+		return CosineSimilarity(a, b)
+	}
+}
@@ -0,0 +1,192 @@
+package kjarni
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestNewVectorStoreMetric(t *testing.T) {
+	tests := []struct {
+		metric Metric
+		ok     bool
+	}{
+		{MetricCosine, true},
+		{MetricDot, true},
+		{MetricL2, true},
+		{Metric(-1), false},
+		{Metric(3), false},
+	}
+	for _, tt := range tests {
+		_, err := NewVectorStore(tt.metric, nil)
+		if tt.ok && err != nil {
+			t.Errorf("metric %d: unexpected error %v", tt.metric, err)
+		}
+		if !tt.ok {
+			var ke *KjarniError
+			if !errors.As(err, &ke) || ke.Code != ErrInvalidConfig {
+				t.Errorf("metric %d: got %v, want ErrInvalidConfig", tt.metric, err)
+			}
+		}
+	}
+}
+
+func TestVectorStoreSearch(t *testing.T) {
+	vecs := map[string][]float32{
+		"a": {1, 0, 0},
+		"b": {0.8, 0.6, 0},
+		"c": {0, 1, 0},
+		"d": {0, 0, -2},
+	}
+	tests := []struct {
+		metric Metric
+		query  []float32
+		k      int
+		want   []string
+	}{
+		{MetricCosine, []float32{1, 0, 0}, 2, []string{"a", "b"}},
+		{MetricCosine, []float32{0, 2, 0}, 1, []string{"c"}},
+		{MetricDot, []float32{0, 0, -1}, 1, []string{"d"}},
+		{MetricL2, []float32{0, 0.9, 0}, 3, []string{"c", "b", "a"}},
+		{MetricCosine, []float32{1, 0, 0}, 10, []string{"a", "b", "c", "d"}},
+		{MetricCosine, []float32{1, 0, 0}, 0, []string{}},
+	}
+	for _, tt := range tests {
+		s, err := NewVectorStore(tt.metric, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range []string{"a", "b", "c", "d"} {
+			if err := s.Add(id, vecs[id], map[string]any{"id": id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		matches, err := s.Search(tt.query, tt.k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := matchIDs(matches); strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("metric %d query %v k=%d: got %v, want %v", tt.metric, tt.query, tt.k, got, tt.want)
+		}
+		for i := 1; i < len(matches); i++ {
+			if matches[i].Score > matches[i-1].Score {
+				t.Errorf("metric %d: results not sorted: %v", tt.metric, matches)
+			}
+		}
+		for _, m := range matches {
+			if m.Metadata["id"] != m.ID {
+				t.Errorf("metric %d: metadata %v for %q", tt.metric, m.Metadata, m.ID)
+			}
+		}
+	}
+}
+
+func TestVectorStoreMutations(t *testing.T) {
+	s, _ := NewVectorStore(MetricCosine, nil)
+	tests := []struct {
+		name    string
+		op      func() error
+		wantErr bool
+		wantLen int
+	}{
+		{"add", func() error { return s.Add("a", []float32{1, 0}, nil) }, false, 1},
+		{"add sets dim", func() error { return s.Add("b", []float32{0, 1}, nil) }, false, 2},
+		{"add duplicate", func() error { return s.Add("a", []float32{1, 1}, nil) }, true, 2},
+		{"add wrong dim", func() error { return s.Add("c", []float32{1, 0, 0}, nil) }, true, 2},
+		{"add empty vector", func() error { return s.Add("c", nil, nil) }, true, 2},
+		{"add empty id", func() error { return s.Add("", []float32{1, 0}, nil) }, true, 2},
+		{"add long id", func() error { return s.Add(strings.Repeat("x", maxStoreIDLen+1), []float32{1, 0}, nil) }, true, 2},
+		{"upsert existing", func() error { return s.Upsert("a", []float32{0.5, 0.5}, nil) }, false, 2},
+		{"upsert new", func() error { return s.Upsert("c", []float32{-1, 0}, nil) }, false, 3},
+		{"upsert wrong dim", func() error { return s.Upsert("a", []float32{1}, nil) }, true, 3},
+		{"delete", func() error {
+			if !s.Delete("a") {
+				return errors.New("not deleted")
+			}
+			return nil
+		}, false, 2},
+		{"delete missing", func() error {
+			if s.Delete("a") {
+				return errors.New("deleted twice")
+			}
+			return nil
+		}, false, 2},
+	}
+	for _, tt := range tests {
+		err := tt.op()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error %v, want error %t", tt.name, err, tt.wantErr)
+		}
+		if s.Len() != tt.wantLen {
+			t.Errorf("%s: Len() = %d, want %d", tt.name, s.Len(), tt.wantLen)
+		}
+	}
+
+	// the swap-remove in Delete must keep every remaining id reachable
+	for _, id := range []string{"b", "c"} {
+		if _, _, ok := s.Get(id); !ok {
+			t.Errorf("Get(%q) missing after delete", id)
+		}
+	}
+	vec, _, _ := s.Get("c")
+	vec[0] = 42
+	if again, _, _ := s.Get("c"); again[0] != -1 {
+		t.Errorf("Get returned a vector aliasing the store")
+	}
+
+	if err := s.Upsert("c", []float32{-1, 0}, map[string]any{"lang": "en"}); err != nil {
+		t.Fatal(err)
+	}
+	_, meta, _ := s.Get("c")
+	meta["lang"] = "de"
+	meta["extra"] = true
+	if _, again, _ := s.Get("c"); len(again) != 1 || again["lang"] != "en" {
+		t.Errorf("Get returned metadata aliasing the store: %v", again)
+	}
+}
+
+func TestVectorStoreSearchDim(t *testing.T) {
+	s, _ := NewVectorStore(MetricCosine, nil)
+	s.Add("a", []float32{1, 0}, nil)
+	if _, err := s.Search([]float32{1, 0, 0}, 1); err == nil {
+		t.Error("search with wrong dimension succeeded")
+	}
+}
+
+func TestVectorStoreNoEmbedder(t *testing.T) {
+	s, _ := NewVectorStore(MetricCosine, nil)
+	if err := s.AddText("a", "text", nil); err == nil {
+		t.Error("AddText without embedder succeeded")
+	}
+	if err := s.UpsertDocuments([]Document{{ID: "a", Text: "text"}}); err == nil {
+		t.Error("UpsertDocuments without embedder succeeded")
+	}
+}
+
+func TestMetricScore(t *testing.T) {
+	a := []float32{3, 4}
+	b := []float32{0, 4}
+	tests := []struct {
+		metric Metric
+		want   float64
+	}{
+		{MetricCosine, 0.8},
+		{MetricDot, 16},
+		{MetricL2, -3},
+	}
+	for _, tt := range tests {
+		s, _ := NewVectorStore(tt.metric, nil)
+		if got := s.score(a, b); math.Abs(float64(got)-tt.want) > 1e-5 {
+			t.Errorf("metric %d: score = %v, want %v", tt.metric, got, tt.want)
+		}
+	}
+}
+
+func matchIDs(matches []VectorMatch) []string {
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	return ids
+}